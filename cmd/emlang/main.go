@@ -2,25 +2,35 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"log/slog"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
 
 	"github.com/emlang-project/emlang/internal/ast"
+	"github.com/emlang-project/emlang/internal/catalog"
 	"github.com/emlang-project/emlang/internal/config"
 	"github.com/emlang-project/emlang/internal/diagram"
 	"github.com/emlang-project/emlang/internal/formatter"
 	"github.com/emlang-project/emlang/internal/linter"
 	"github.com/emlang-project/emlang/internal/parser"
+	"github.com/emlang-project/emlang/internal/schema"
 	"github.com/emlang-project/emlang/internal/serve"
 	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
 )
 
 const version = "1.0.0"
 const specVersion = "1.0.0"
 
 func main() {
-	args, configPath := extractConfigFlag(os.Args[1:])
+	args, configPath, profile, strict := extractGlobalFlags(os.Args[1:])
 
 	if len(args) < 1 {
 		printUsage()
@@ -37,26 +47,51 @@ func main() {
 	case "version":
 		fmt.Printf("emlang version %s (spec %s)\n", version, specVersion)
 		return
+	case "schema":
+		cmdSchema()
+		return
 	case "help", "-h", "--help":
 		printUsage()
 		return
 	}
 
-	cfg, err := config.Load(configPath)
+	cfg, err := loadConfig(configPath, profile, strict)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
 		os.Exit(1)
 	}
 
+	parseOpts, err := newParseOptions(cfg, strict)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
 	switch command {
 	case "parse":
-		cmdParse(args[1:])
+		cmdParse(args[1:], parseOpts)
+	case "flow":
+		cmdFlow(args[1:], parseOpts)
+	case "spec":
+		cmdSpec(args[1:], parseOpts)
+	case "catalog":
+		cmdCatalog(args[1:], parseOpts)
+	case "owners":
+		cmdOwners(args[1:], parseOpts)
+	case "coverage":
+		cmdCoverage(args[1:], parseOpts)
+	case "canonicalize":
+		cmdCanonicalize(args[1:], parseOpts)
 	case "lint":
-		cmdLint(args[1:], cfg)
+		cmdLint(args[1:], cfg, parseOpts)
 	case "fmt":
-		cmdFmt(args[1:], cfg)
+		cmdFmt(args[1:], cfg, parseOpts)
 	case "diagram":
-		cmdDiagram(args[1:], cfg)
+		cmdDiagram(args[1:], cfg, configPath, profile, strict, parseOpts)
+	case "build":
+		cmdBuild(args[1:], cfg, parseOpts)
+	case "config-dump":
+		cmdConfigDump(cfg)
 	default:
 		fmt.Fprintf(os.Stderr, "Unknown command: %s\n", command)
 		printUsage()
@@ -64,11 +99,27 @@ func main() {
 	}
 }
 
-func extractConfigFlag(args []string) (remaining []string, configPath string) {
+// loadConfig loads the config with the given profile and applies the
+// global flags over it, so that config-dump shows what is in effect.
+func loadConfig(configPath, profile string, strict bool) (*config.Config, error) {
+	cfg, err := config.LoadProfile(configPath, profile)
+	if err != nil {
+		return nil, err
+	}
+	cfg.Parser.Strict = cfg.Parser.Strict || strict
+	return cfg, nil
+}
+
+func extractGlobalFlags(args []string) (remaining []string, configPath string, profile string, strict bool) {
 	for i := 0; i < len(args); i++ {
 		if (args[i] == "-c" || args[i] == "--config") && i+1 < len(args) {
 			configPath = args[i+1]
 			i++
+		} else if args[i] == "--profile" && i+1 < len(args) {
+			profile = args[i+1]
+			i++
+		} else if args[i] == "--strict" {
+			strict = true
 		} else {
 			remaining = append(remaining, args[i])
 		}
@@ -79,19 +130,66 @@ func extractConfigFlag(args []string) (remaining []string, configPath string) {
 func printUsage() {
 	fmt.Println("emlang - The Emlang toolchain (https://emlang-project.github.io/)")
 	fmt.Println()
-	fmt.Println("Usage: emlang [-c <config>] <command> [arguments]")
+	fmt.Println("Usage: emlang [-c <config>] [--profile <name>] [--strict] <command> [arguments]")
 	fmt.Println()
 	fmt.Println("Flags:")
 	fmt.Println("  -c, --config <file>  Path to config file (default: .emlang.yaml, or EMLANG_CONFIG env)")
+	fmt.Println("  --profile <name>     Apply a named profile from the config file")
+	fmt.Println("  --strict             Reject tolerated constructs, see parser.strict in the config")
 	fmt.Println()
 	fmt.Println("Commands:")
 	fmt.Println("  parse <file>         Parse a YAML source file and show structure (use - for stdin)")
-	fmt.Println("  lint <file>          Lint a YAML source file for issues (use - for stdin)")
+	fmt.Println("  flow <file>          Print each slice as a one-line flow of element names")
+	fmt.Println("  spec <file>          Write the tests of each slice as a Markdown acceptance document")
+	fmt.Println("                       -o <file>: output file (default stdout)")
+	fmt.Println("  lint <file>...       Lint YAML source files for issues (use - for stdin)")
+	fmt.Println("                       --only rule[,rule...]: report only the given rules")
+	fmt.Println("                       --format text|markdown|ndjson: output format")
+	fmt.Println("                       --fail-on error|warning|none: exit status threshold (default error)")
+	fmt.Println("                       -q, --quiet: print only files with issues")
+	fmt.Println("                       --write-baseline <file>: record current issues as known")
+	fmt.Println("                       --baseline <file>: report only issues not in the baseline")
+	fmt.Println("                       --since <ref> [path...]: lint only YAML files changed since a git ref")
 	fmt.Println("  fmt <file>           Format a YAML source file (use - for stdin, -w for in-place)")
-	fmt.Println("                       --keys short|long: override key style")
+	fmt.Println("                       --keys short|long|keep: override key style (keep: as written)")
+	fmt.Println("                       --preserve-aliases: keep YAML anchors and aliases")
+	fmt.Println("                       --sort-slices: sort slices alphabetically")
+	fmt.Println("                       --normalize-swimlanes: rewrite \"A / B\" and \"A//B\" as \"A/B\"")
+	fmt.Println("                       --check [-q]: exit non-zero if the file is not formatted")
+	fmt.Println("                       --verify: fail if formatting the output again would change it")
+	fmt.Println("  catalog <file>...    List commands and events with the slices producing and consuming them")
+	fmt.Println("                       --format csv|json: output format (default csv)")
+	fmt.Println("  owners <file>...     List each owner with the slices and elements it owns")
+	fmt.Println("                       --format text|json: output format (default text)")
+	fmt.Println("  coverage <file>...   Report the number of tests of each slice, flagging untested slices")
+	fmt.Println("                       --format text|json: output format (default text)")
+	fmt.Println("                       --min-tests <n>: exit non-zero if a slice has fewer tests")
+	fmt.Println("  canonicalize <file>  Print a sorted, normalized form for golden-file diffs")
 	fmt.Println("  diagram <file>       Generate an HTML diagram (use - for stdin, -o file for output)")
-	fmt.Println("                       --serve [--address 127.0.0.1] [--port 8274]: live-reload server")
+	fmt.Println("                       --serve [--address 127.0.0.1] [--port 8274] [--no-open]: live-reload server")
+	fmt.Println("                       -v, --verbose | -q, --quiet: log requests, or only warnings and errors")
+	fmt.Println("                       --no-external-styling: render external elements like others")
+	fmt.Println("                       --css --name=value: override a CSS variable (repeatable)")
+	fmt.Println("                       --css-file <file>: YAML/JSON map of CSS variables (- for stdin)")
+	fmt.Println("                       CSS precedence: --css > --css-file > config")
+	fmt.Println("                       --theme light|dark|colorblind: built-in color theme, under CSS overrides")
+	fmt.Println("                       --props grid|inline|tooltip|badges: props rendering style")
+	fmt.Println("                       --layout stacked|tabbed: show documents one below the other or in tabs")
+	fmt.Println("                       --slice <name> [--omit-common-css]: embeddable fragment of one slice")
+	fmt.Println("                       --numbers: number elements by their position in the slice")
+	fmt.Println("                       --slice-props: show each slice's props under its name")
+	fmt.Println("                       --separate-exceptions: render exceptions in their own row")
+	fmt.Println("                       --event-layout per-lane|combined: one event row per swimlane, or one row")
+	fmt.Println("                       --tests-only: render only slice names and tests, as a matrix")
+	fmt.Println("                       --footer: summarize slice, element and test counts")
+	fmt.Println("                       --timeline: connect each slice's last event to the next slice")
+	fmt.Println("                       --explain: describe the rows that would render, and why")
+	fmt.Println("                       --format html|outline: grid diagram, or collapsible outline of the model")
+	fmt.Println("                       --force: render even if the grid exceeds diagram.max_columns")
+	fmt.Println("  build <dir> -o <out> Render every .yaml file under dir to standalone HTML in out")
+	fmt.Println("  config-dump          Print the effective config after file lookup, --profile and --strict")
 	fmt.Println("  init                 Create a .emlang.yaml config file with defaults")
+	fmt.Println("  schema               Print a JSON Schema of the file format for editors")
 	fmt.Println("  version              Print version information")
 	fmt.Println("  help                 Show this help message")
 }
@@ -104,14 +202,41 @@ lint:
   #   - command-without-event
   #   - orphan-exception
   #   - slice-missing-event
+  # enable:
+  #   - empty-slice-placeholder
+  # severity:
+  #   command-without-event: error
+  # prop_schema:
+  #   event:
+  #     - occurred_at
 
 fmt:
   # keys: long
 
+parser:
+  # Extra element types allowed per test section (given, when, then)
+  # allow_in_tests:
+  #   when:
+  #     - view
+  # Reject tolerated constructs such as mixed key styles (or use --strict)
+  # strict: true
+
+# Prop keys whose value names another element, besides keys ending in _ref
+# ref_props:
+#   - source_event
+
 diagram:
+  # theme: light
+  # layout: stacked
+  # colors:               # shorthand for the --<type>-color CSS variables
+  #   command: "#a5d8ff"
+  # max_columns: 500
+  # max_label_chars: 40
+
   # serve:
   #   address: 127.0.0.1
   #   port: 8274
+  #   template: page.html.tmpl  # page around served and built diagrams
 
   # css:
   #   --text-color: "#212529"
@@ -122,11 +247,21 @@ diagram:
   #   --event-color: "#ffd8a8"
   #   --exception-color: "#ffc9c9"
   #   --view-color: "#b2f2bb"
+  #   --projection-color: "#d0bfff"
+  #   --external-border-color: "#495057"
+  #   --trigger-border: none
+  #   --command-border: none
+  #   --event-border: none
+  #   --exception-border: none
+  #   --view-border: none
+  #   --projection-border: none
   #   --item-border-radius: 0.5em
   #
   #   --font-family-normal: system-ui
   #   --font-family-props: monospace
   #
+  #   --font-size-groupname: 2.5em
+  #   --font-weight-groupname: bold
   #   --font-size-slicename: 2em
   #   --font-weight-slicename: normal
   #   --font-size-swimlane: 1.5em
@@ -137,6 +272,16 @@ diagram:
   #   --font-weight-label: normal
   #   --font-size-props: 0.75em
   #   --font-weight-props: normal
+  #
+  #   --badge-string-color: "#2f9e44"
+  #   --badge-number-color: "#1971c2"
+  #   --badge-bool-color: "#e8590c"
+  #   --badge-uuid-color: "#9c36b5"
+
+# profiles:
+#   ci:
+#     lint:
+#       ignore: []
 `
 
 func cmdInit() {
@@ -152,59 +297,378 @@ func cmdInit() {
 	fmt.Printf("Created %s\n", path)
 }
 
-func parseFile(arg string) (*ast.Document, string) {
+func cmdConfigDump(cfg *config.Config) {
+	b, err := config.Dump(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	os.Stdout.Write(b)
+}
+
+func cmdSchema() {
+	b, err := schema.JSON()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Println(string(b))
+}
+
+// newParseOptions converts the parser config to the parser options used
+// by every command that parses files. The global --strict flag, strict,
+// enables parser.strict whatever the config says.
+func newParseOptions(cfg *config.Config, strict bool) (parser.Options, error) {
+	opts := parser.Options{Strict: cfg.Parser.Strict || strict}
+	for section, typeNames := range cfg.Parser.AllowInTests {
+		known := false
+		for _, s := range parser.TestSections {
+			known = known || s == section
+		}
+		if !known {
+			return opts, fmt.Errorf("unknown test section %q in parser.allow_in_tests (expected %s)", section, strings.Join(parser.TestSections, ", "))
+		}
+		for _, name := range typeNames {
+			t, ok := ast.ParseElementType(name)
+			if !ok {
+				return opts, fmt.Errorf("unknown element type %q in parser.allow_in_tests.%s", name, section)
+			}
+			if opts.AllowInTests == nil {
+				opts.AllowInTests = make(map[string][]ast.ElementType)
+			}
+			opts.AllowInTests[section] = append(opts.AllowInTests[section], t)
+		}
+	}
+	return opts, nil
+}
+
+func parseFile(arg string, parseOpts parser.Options) (*ast.Document, string) {
+	doc, name, err := loadFile(arg, parseOpts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+		os.Exit(1)
+	}
+	return doc, name
+}
+
+// loadFile reads and parses a file, or stdin for "-". It returns the name
+// to report the file under, even on error.
+func loadFile(arg string, parseOpts parser.Options) (*ast.Document, string, error) {
 	var input io.Reader
-	var name string
+	name := arg
 
 	if arg == "-" {
+		name = "<stdin>"
 		content, err := io.ReadAll(os.Stdin)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error reading input: %v\n", err)
-			os.Exit(1)
+			return nil, name, fmt.Errorf("reading input: %w", err)
 		}
 		input = bytes.NewReader(content)
-		name = "<stdin>"
 	} else {
 		f, err := os.Open(arg)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error reading input: %v\n", err)
-			os.Exit(1)
+			return nil, name, fmt.Errorf("reading input: %w", err)
 		}
 		defer f.Close()
 		input = f
-		name = arg
 	}
 
-	doc, err := parser.Parse(input)
+	doc, err := parser.ParseWithOptions(input, parseOpts)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Parse error in %s: %v\n", name, err)
-		os.Exit(1)
+		return nil, name, fmt.Errorf("parse error: %w", err)
 	}
-
-	return doc, name
+	return doc, name, nil
 }
 
-func cmdParse(args []string) {
+func cmdParse(args []string, parseOpts parser.Options) {
 	if len(args) < 1 {
 		fmt.Fprintln(os.Stderr, "Usage: emlang parse <file>")
 		os.Exit(1)
 	}
 
-	doc, name := parseFile(args[0])
+	doc, name := parseFile(args[0], parseOpts)
 
 	fmt.Printf("Parsed %s successfully\n", name)
 	fmt.Println("----------------------------------------")
 	printDocument(doc)
 }
 
+func cmdCanonicalize(args []string, parseOpts parser.Options) {
+	if len(args) < 1 {
+		fmt.Fprintln(os.Stderr, "Usage: emlang canonicalize <file>")
+		os.Exit(1)
+	}
+
+	doc, _ := parseFile(args[0], parseOpts)
+	os.Stdout.Write(formatter.Canonical(doc))
+}
+
+func cmdFlow(args []string, parseOpts parser.Options) {
+	if len(args) < 1 {
+		fmt.Fprintln(os.Stderr, "Usage: emlang flow <file>")
+		os.Exit(1)
+	}
+
+	doc, _ := parseFile(args[0], parseOpts)
+	for _, slice := range doc.AllSlicesInOrder() {
+		fmt.Println(flowLine(slice))
+	}
+}
+
+// flowLine renders a slice as one line of arrow-joined element names,
+// e.g. "register: User/ClickRegister → RegisterUser → UserRegistered".
+func flowLine(slice *ast.Slice) string {
+	name := slice.Name
+	if name == "" {
+		name = "(anonymous)"
+	}
+	steps := flowSteps(slice)
+	if steps == "" {
+		return name + ":"
+	}
+	return name + ": " + steps
+}
+
+// flowSteps renders the elements of a slice as arrow-joined names, e.g.
+// "User/ClickRegister → RegisterUser → UserRegistered". Exceptions are
+// marked with ⨯ and alt branches are grouped as "(a | b)".
+func flowSteps(slice *ast.Slice) string {
+	var steps []string
+	for i := 0; i < len(slice.Elements); {
+		elem := slice.Elements[i]
+		if elem.Alt == nil {
+			steps = append(steps, flowName(elem))
+			i++
+			continue
+		}
+		alt := elem.Alt
+		var branches []string
+		for _, b := range alt.Branches {
+			var names []string
+			for _, e := range b.Elements {
+				names = append(names, flowName(e))
+			}
+			branches = append(branches, strings.Join(names, " → "))
+		}
+		steps = append(steps, "("+strings.Join(branches, " | ")+")")
+		for i < len(slice.Elements) && slice.Elements[i].Alt == alt {
+			i++
+		}
+	}
+	return strings.Join(steps, " → ")
+}
+
+// flowName returns the swimlane-qualified name of an element,
+// prefixed with ⨯ for exceptions.
+func flowName(elem *ast.Element) string {
+	name := elem.QualifiedName()
+	if elem.Type == ast.ElementException {
+		name = "⨯" + name
+	}
+	return name
+}
+
+func cmdSpec(args []string, parseOpts parser.Options) {
+	flags := pflag.NewFlagSet("spec", pflag.ExitOnError)
+	outputFile := flags.StringP("output", "o", "", "output file")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: emlang spec [-o <file>] <file>")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() < 1 {
+		flags.Usage()
+		os.Exit(1)
+	}
+
+	doc, name := parseFile(flags.Arg(0), parseOpts)
+	var buf bytes.Buffer
+	writeSpec(&buf, name, doc)
+
+	if *outputFile != "" {
+		if err := os.WriteFile(*outputFile, buf.Bytes(), 0644); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing output: %v\n", err)
+			os.Exit(1)
+		}
+	} else {
+		os.Stdout.Write(buf.Bytes())
+	}
+}
+
+// writeSpec writes doc as a Markdown acceptance document titled after
+// the source name: a section per slice with its flow line and each of
+// its tests, the elements of its given, when, then and then-not clauses
+// listed under them.
+func writeSpec(w io.Writer, name string, doc *ast.Document) {
+	fmt.Fprintf(w, "# %s\n", name)
+	for _, slice := range doc.AllSlicesInOrder() {
+		heading := slice.Name
+		if heading == "" {
+			heading = "(anonymous)"
+		}
+		fmt.Fprintf(w, "\n## %s\n", heading)
+		if steps := flowSteps(slice); steps != "" {
+			fmt.Fprintf(w, "\n`%s`\n", steps)
+		}
+
+		if len(slice.TestOrder) == 0 {
+			fmt.Fprintln(w, "\nNo tests.")
+			continue
+		}
+		for _, tn := range slice.TestOrder {
+			test := slice.Tests[tn]
+			fmt.Fprintf(w, "\n### %s\n\n", tn)
+			clauses := []struct {
+				label string
+				elems []*ast.Element
+			}{
+				{"Given", test.Given},
+				{"When", test.When},
+				{"Then", test.Then},
+				{"Then not", test.ThenNot},
+			}
+			for _, c := range clauses {
+				if len(c.elems) == 0 {
+					continue
+				}
+				fmt.Fprintf(w, "- **%s**\n", c.label)
+				for _, elem := range c.elems {
+					fmt.Fprintf(w, "  - %s %s\n", elem.Type, elem.QualifiedName())
+				}
+			}
+		}
+	}
+}
+
+func cmdCatalog(args []string, parseOpts parser.Options) {
+	flags := pflag.NewFlagSet("catalog", pflag.ExitOnError)
+	formatFlag := flags.String("format", "csv", "output format: csv or json")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: emlang catalog [--format csv|json] <file>...")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() < 1 {
+		flags.Usage()
+		os.Exit(1)
+	}
+
+	if *formatFlag != "csv" && *formatFlag != "json" {
+		fmt.Fprintf(os.Stderr, "Error: invalid --format %q (expected csv or json)\n", *formatFlag)
+		os.Exit(1)
+	}
+
+	var sources []catalog.Source
+	for _, arg := range flags.Args() {
+		doc, name := parseFile(arg, parseOpts)
+		sources = append(sources, catalog.Source{File: name, Doc: doc})
+	}
+
+	entries := catalog.Build(sources)
+	var err error
+	if *formatFlag == "json" {
+		err = catalog.WriteJSON(os.Stdout, entries)
+	} else {
+		err = catalog.WriteCSV(os.Stdout, entries)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func cmdOwners(args []string, parseOpts parser.Options) {
+	flags := pflag.NewFlagSet("owners", pflag.ExitOnError)
+	formatFlag := flags.String("format", "text", "output format: text or json")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: emlang owners [--format text|json] <file>...")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() < 1 {
+		flags.Usage()
+		os.Exit(1)
+	}
+
+	if *formatFlag != "text" && *formatFlag != "json" {
+		fmt.Fprintf(os.Stderr, "Error: invalid --format %q (expected text or json)\n", *formatFlag)
+		os.Exit(1)
+	}
+
+	var sources []catalog.Source
+	for _, arg := range flags.Args() {
+		doc, name := parseFile(arg, parseOpts)
+		sources = append(sources, catalog.Source{File: name, Doc: doc})
+	}
+
+	owners := catalog.Owners(sources)
+	var err error
+	if *formatFlag == "json" {
+		err = catalog.WriteOwnersJSON(os.Stdout, owners)
+	} else {
+		err = catalog.WriteOwners(os.Stdout, owners)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func cmdCoverage(args []string, parseOpts parser.Options) {
+	flags := pflag.NewFlagSet("coverage", pflag.ExitOnError)
+	formatFlag := flags.String("format", "text", "output format: text or json")
+	minTests := flags.Int("min-tests", 0, "exit non-zero if a slice has fewer tests")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: emlang coverage [--format text|json] [--min-tests <n>] <file>...")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() < 1 {
+		flags.Usage()
+		os.Exit(1)
+	}
+
+	if *formatFlag != "text" && *formatFlag != "json" {
+		fmt.Fprintf(os.Stderr, "Error: invalid --format %q (expected text or json)\n", *formatFlag)
+		os.Exit(1)
+	}
+
+	var sources []catalog.Source
+	for _, arg := range flags.Args() {
+		doc, name := parseFile(arg, parseOpts)
+		sources = append(sources, catalog.Source{File: name, Doc: doc})
+	}
+
+	coverage := catalog.Coverage(sources)
+	var err error
+	if *formatFlag == "json" {
+		err = catalog.WriteCoverageJSON(os.Stdout, coverage)
+	} else {
+		err = catalog.WriteCoverage(os.Stdout, coverage)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if below := catalog.BelowMinTests(coverage, *minTests); len(below) > 0 {
+		for _, c := range below {
+			fmt.Fprintf(os.Stderr, "%s (document %d): %d test(s), fewer than --min-tests %d\n", c.SliceRef, c.Document, c.Tests, *minTests)
+		}
+		os.Exit(1)
+	}
+}
+
 func printDocument(doc *ast.Document) {
 	fmt.Printf("Document with %d slice(s)\n", len(doc.Slices))
 
-	for _, sd := range doc.SubDocs {
-		for _, name := range sd.SliceOrder {
-			fmt.Println()
-			printSlice(name, sd.Slices[name])
-		}
+	for _, slice := range doc.AllSlicesInOrder() {
+		fmt.Println()
+		printSlice(slice.Name, slice)
 	}
 }
 
@@ -221,8 +685,8 @@ func printSlice(name string, slice *ast.Slice) {
 
 	if len(slice.Tests) > 0 {
 		fmt.Printf("  %d attached test(s)\n", len(slice.Tests))
-		for testName, test := range slice.Tests {
-			printTest("  "+testName, test)
+		for _, testName := range slice.TestOrder {
+			printTest("  "+testName, slice.Tests[testName])
 		}
 	}
 }
@@ -250,6 +714,13 @@ func printTest(name string, test *ast.Test) {
 			printElement("    ", elem)
 		}
 	}
+
+	if len(test.ThenNot) > 0 {
+		fmt.Printf("  Then not: %d element(s)\n", len(test.ThenNot))
+		for _, elem := range test.ThenNot {
+			printElement("    ", elem)
+		}
+	}
 }
 
 func printElement(indent string, elem *ast.Element) {
@@ -268,12 +739,18 @@ func printElement(indent string, elem *ast.Element) {
 	}
 }
 
-func cmdFmt(args []string, cfg *config.Config) {
+func cmdFmt(args []string, cfg *config.Config, parseOpts parser.Options) {
 	flags := pflag.NewFlagSet("fmt", pflag.ExitOnError)
 	writeFlag := flags.BoolP("write", "w", false, "write result to source file instead of stdout")
-	keysFlag := flags.String("keys", "", "key style: short or long")
+	keysFlag := flags.String("keys", "", "key style: short, long, or keep (as written per element)")
+	aliasesFlag := flags.Bool("preserve-aliases", false, "keep YAML anchors and aliases instead of expanding them")
+	sortFlag := flags.Bool("sort-slices", false, "sort slices alphabetically within each document")
+	lanesFlag := flags.Bool("normalize-swimlanes", false, "trim spaces around and collapse repeated slashes in swimlane paths")
+	checkFlag := flags.Bool("check", false, "report whether the file is formatted instead of printing it")
+	quietFlag := flags.BoolP("quiet", "q", false, "with --check, print nothing when the file is formatted")
+	verifyFlag := flags.Bool("verify", false, "fail if formatting the output again would change it")
 	flags.Usage = func() {
-		fmt.Fprintln(os.Stderr, "Usage: emlang fmt [-w] [--keys short|long] <file>")
+		fmt.Fprintln(os.Stderr, "Usage: emlang fmt [-w | --check [-q]] [--verify] [--keys short|long|keep] [--preserve-aliases] [--sort-slices] [--normalize-swimlanes] <file>")
 		flags.PrintDefaults()
 	}
 	flags.Parse(args)
@@ -289,8 +766,12 @@ func cmdFmt(args []string, cfg *config.Config) {
 		fmt.Fprintln(os.Stderr, "Error: -w cannot be used with stdin")
 		os.Exit(1)
 	}
+	if *checkFlag && (*writeFlag || inputArg == "-") {
+		fmt.Fprintln(os.Stderr, "Error: --check cannot be used with -w or stdin")
+		os.Exit(1)
+	}
 
-	doc, _ := parseFile(inputArg)
+	doc, _ := parseFile(inputArg, parseOpts)
 
 	// Priority: flag > config > default
 	keyStyle := "long"
@@ -301,9 +782,36 @@ func cmdFmt(args []string, cfg *config.Config) {
 		keyStyle = *keysFlag
 	}
 
-	out := formatter.Format(doc, formatter.Options{KeyStyle: keyStyle})
+	opts := formatter.Options{
+		KeyStyle:           keyStyle,
+		PreserveAliases:    *aliasesFlag,
+		SortSlices:         *sortFlag,
+		NormalizeSwimlanes: *lanesFlag,
+	}
+	format := func(doc *ast.Document) []byte { return formatter.Format(doc, opts) }
+	out := format(doc)
+
+	if *verifyFlag {
+		if err := verifyIdempotent(out, format, parseOpts); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s: %v\n", inputArg, err)
+			os.Exit(1)
+		}
+	}
 
-	if *writeFlag {
+	if *checkFlag {
+		src, err := os.ReadFile(inputArg)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading input: %v\n", err)
+			os.Exit(1)
+		}
+		if !bytes.Equal(src, out) {
+			fmt.Printf("%s: not formatted\n", inputArg)
+			os.Exit(1)
+		}
+		if !*quietFlag {
+			fmt.Printf("%s: formatted\n", inputArg)
+		}
+	} else if *writeFlag {
 		if err := os.WriteFile(inputArg, out, 0644); err != nil {
 			fmt.Fprintf(os.Stderr, "Error writing %s: %v\n", inputArg, err)
 			os.Exit(1)
@@ -313,14 +821,152 @@ func cmdFmt(args []string, cfg *config.Config) {
 	}
 }
 
-func cmdDiagram(args []string, cfg *config.Config) {
+// verifyIdempotent parses out, the output of format, and formats it again,
+// returning an error if the second pass does not reproduce out.
+func verifyIdempotent(out []byte, format func(*ast.Document) []byte, parseOpts parser.Options) error {
+	doc, err := parser.ParseWithOptions(bytes.NewReader(out), parseOpts)
+	if err != nil {
+		return fmt.Errorf("formatted output does not parse: %w", err)
+	}
+	again := format(doc)
+	if bytes.Equal(out, again) {
+		return nil
+	}
+	first := strings.Split(string(out), "\n")
+	second := strings.Split(string(again), "\n")
+	line := 0
+	for line < len(first) && line < len(second) && first[line] == second[line] {
+		line++
+	}
+	var was, now string
+	if line < len(first) {
+		was = first[line]
+	}
+	if line < len(second) {
+		now = second[line]
+	}
+	return fmt.Errorf("formatting is not idempotent: line %d changes from %q to %q on a second pass", line+1, was, now)
+}
+
+// readCSSFile reads a YAML or JSON mapping of CSS variable overrides
+// from path, or from stdin if path is "-".
+func readCSSFile(path string) (map[string]string, error) {
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading CSS file: %w", err)
+	}
+	return parseCSSFile(data)
+}
+
+// parseCSSFile decodes a YAML (or JSON) mapping of CSS variables.
+func parseCSSFile(data []byte) (map[string]string, error) {
+	var css map[string]string
+	if err := yaml.Unmarshal(data, &css); err != nil {
+		return nil, fmt.Errorf("parsing CSS file: %w", err)
+	}
+	for key := range css {
+		if !strings.HasPrefix(key, "--") {
+			return nil, fmt.Errorf("invalid CSS variable %q in CSS file: must start with --", key)
+		}
+	}
+	return css, nil
+}
+
+// mergeCSS returns a new map with over's entries taking precedence over base.
+func mergeCSS(base, over map[string]string) map[string]string {
+	merged := make(map[string]string, len(base)+len(over))
+	for k, v := range base {
+		merged[k] = v
+	}
+	for k, v := range over {
+		merged[k] = v
+	}
+	return merged
+}
+
+// mergeCSSFlags returns base overlaid with --css flag values of the form
+// "--name=value". Flag values take precedence over base.
+func mergeCSSFlags(base map[string]string, flagValues []string) (map[string]string, error) {
+	merged := mergeCSS(base, nil)
+	for _, fv := range flagValues {
+		key, value, ok := strings.Cut(fv, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid --css value %q: expected --name=value", fv)
+		}
+		if !strings.HasPrefix(key, "--") {
+			return nil, fmt.Errorf("invalid --css variable %q: must start with --", key)
+		}
+		merged[key] = value
+	}
+	return merged, nil
+}
+
+// isPropsStyle reports whether s is a valid --props value.
+func isPropsStyle(s string) bool {
+	for _, style := range diagram.PropsStyles {
+		if s == style {
+			return true
+		}
+	}
+	return false
+}
+
+// isLayout reports whether s is a valid document layout.
+func isLayout(s string) bool {
+	for _, layout := range diagram.Layouts {
+		if s == layout {
+			return true
+		}
+	}
+	return false
+}
+
+// isEventLayout reports whether s is a valid event row layout.
+func isEventLayout(s string) bool {
+	for _, layout := range diagram.EventLayouts {
+		if s == layout {
+			return true
+		}
+	}
+	return false
+}
+
+func cmdDiagram(args []string, cfg *config.Config, configPath, profile string, strict bool, parseOpts parser.Options) {
 	flags := pflag.NewFlagSet("diagram", pflag.ExitOnError)
 	outputFile := flags.StringP("output", "o", "", "output file")
 	serveFlag := flags.Bool("serve", false, "start a live-reload HTTP server")
 	portFlag := flags.Int("port", 0, "port for the live-reload server")
 	addressFlag := flags.String("address", "", "listen address for the live-reload server")
+	noOpenFlag := flags.Bool("no-open", false, "do not open a browser when serving")
+	verboseFlag := flags.BoolP("verbose", "v", false, "with --serve, also log every request")
+	quietFlag := flags.BoolP("quiet", "q", false, "with --serve, log only warnings and errors")
+	noExternalFlag := flags.Bool("no-external-styling", false, "render external: true elements like any other")
+	cssFlag := flags.StringArray("css", nil, "CSS variable override as --name=value (repeatable)")
+	cssFileFlag := flags.String("css-file", "", "YAML/JSON map of CSS variable overrides (use - for stdin)")
+	separateExceptionsFlag := flags.Bool("separate-exceptions", false, "render exceptions in their own row below events")
+	eventLayoutFlag := flags.String("event-layout", diagram.EventsPerLane, "event rows: "+strings.Join(diagram.EventLayouts, ", "))
+	testsOnlyFlag := flags.Bool("tests-only", false, "render only slice names and tests")
+	footerFlag := flags.Bool("footer", false, "summarize slice, element and test counts under each document")
+	timelineFlag := flags.Bool("timeline", false, "connect the last event of each slice to the same-named element of the next")
+	formatFlag := flags.String("format", "html", "output format: html (the grid diagram) or outline (collapsible tree)")
+	explainFlag := flags.Bool("explain", false, "describe the rows each document would render, and why, instead of rendering")
+	slicePropsFlag := flags.Bool("slice-props", false, "show the props of each slice under its name")
+	numbersFlag := flags.Bool("numbers", false, "number elements by their position in the slice")
+	forceFlag := flags.Bool("force", false, "render even if the grid exceeds diagram.max_columns")
+	themeFlag := flags.String("theme", cfg.Diagram.Theme, "built-in theme: "+strings.Join(diagram.ThemeNames, ", "))
+	propsFlag := flags.String("props", diagram.PropsGrid, "props rendering: "+strings.Join(diagram.PropsStyles, ", "))
+	layoutFlag := flags.String("layout", cfg.Diagram.Layout, "document layout: "+strings.Join(diagram.Layouts, ", "))
+	sliceFlag := flags.String("slice", "", "render only this slice, with ids unique to it, for embedding")
+	omitCommonCSSFlag := flags.Bool("omit-common-css", false, "leave out the stylesheet shared by all diagrams, for pages that include it once")
+	templateFlag := flags.String("template", cfg.Diagram.Serve.Template, "with --serve, html/template `file` for the page around the diagram")
 	flags.Usage = func() {
-		fmt.Fprintln(os.Stderr, "Usage: emlang diagram [-o output.html] [--serve [--address 127.0.0.1] [--port 8274]] <file>")
+		fmt.Fprintln(os.Stderr, "Usage: emlang diagram [-o output.html] [--serve [--address 127.0.0.1] [--port 8274] [--no-open] [-v|-q]] <file>")
 		flags.PrintDefaults()
 	}
 	flags.Parse(args)
@@ -335,103 +981,946 @@ func cmdDiagram(args []string, cfg *config.Config) {
 		os.Exit(1)
 	}
 
-	inputArg := flags.Arg(0)
+	if *explainFlag && *serveFlag {
+		fmt.Fprintln(os.Stderr, "Error: --explain and --serve are mutually exclusive")
+		os.Exit(1)
+	}
 
-	if *serveFlag {
-		if inputArg == "-" {
-			fmt.Fprintln(os.Stderr, "Error: --serve cannot be used with stdin")
+	if *sliceFlag != "" && *serveFlag {
+		fmt.Fprintln(os.Stderr, "Error: --slice and --serve are mutually exclusive")
+		os.Exit(1)
+	}
+
+	switch *formatFlag {
+	case "html":
+	case "outline":
+		if *serveFlag || *explainFlag {
+			fmt.Fprintln(os.Stderr, "Error: --format outline cannot be used with --serve or --explain")
 			os.Exit(1)
 		}
+	default:
+		fmt.Fprintf(os.Stderr, "Error: invalid --format %q (expected html or outline)\n", *formatFlag)
+		os.Exit(1)
+	}
 
-		// Priority: flag > config > default
-		addr := "127.0.0.1"
-		if cfg.Diagram.Serve.Address != "" {
-			addr = cfg.Diagram.Serve.Address
-		}
-		if flags.Changed("address") {
-			addr = *addressFlag
-		}
+	if flags.Changed("template") && !*serveFlag {
+		fmt.Fprintln(os.Stderr, "Error: --template requires --serve")
+		os.Exit(1)
+	}
 
-		port := 8274
-		if cfg.Diagram.Serve.Port != 0 {
+	if *verboseFlag && *quietFlag {
+		fmt.Fprintln(os.Stderr, "Error: --verbose and --quiet are mutually exclusive")
+		os.Exit(1)
+	}
+
+	inputArg := flags.Arg(0)
+
+	if !isPropsStyle(*propsFlag) {
+		fmt.Fprintf(os.Stderr, "Error: invalid --props %q (expected %s)\n", *propsFlag, strings.Join(diagram.PropsStyles, ", "))
+		os.Exit(1)
+	}
+
+	if _, ok := diagram.Themes[*themeFlag]; *themeFlag != "" && !ok {
+		fmt.Fprintf(os.Stderr, "Error: invalid --theme %q (expected %s)\n", *themeFlag, strings.Join(diagram.ThemeNames, ", "))
+		os.Exit(1)
+	}
+
+	if *layoutFlag != "" && !isLayout(*layoutFlag) {
+		fmt.Fprintf(os.Stderr, "Error: invalid --layout %q (expected %s)\n", *layoutFlag, strings.Join(diagram.Layouts, ", "))
+		os.Exit(1)
+	}
+
+	if !isEventLayout(*eventLayoutFlag) {
+		fmt.Fprintf(os.Stderr, "Error: invalid --event-layout %q (expected %s)\n", *eventLayoutFlag, strings.Join(diagram.EventLayouts, ", "))
+		os.Exit(1)
+	}
+
+	if *cssFileFlag == "-" && inputArg == "-" {
+		fmt.Fprintln(os.Stderr, "Error: --css-file - cannot be used with stdin input")
+		os.Exit(1)
+	}
+
+	var fileCSS map[string]string
+	if *cssFileFlag != "" {
+		var err error
+		fileCSS, err = readCSSFile(*cssFileFlag)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	// configure builds the generator from cfg and the flags, which take
+	// precedence, so that a config reloaded by the server applies the same way.
+	configure := func(cfg *config.Config) (*diagram.Generator, error) {
+		if err := checkDiagramConfig(cfg); err != nil {
+			return nil, err
+		}
+
+		// Priority: flags > css-file > config
+		css, err := mergeCSSFlags(mergeCSS(configCSS(cfg), fileCSS), *cssFlag)
+		if err != nil {
+			return nil, err
+		}
+
+		gen := newGenerator(cfg)
+		gen.CSSOverrides = css
+		gen.NoExternalStyling = *noExternalFlag
+		gen.PropsStyle = *propsFlag
+		if flags.Changed("theme") {
+			gen.Theme = *themeFlag
+		}
+		if flags.Changed("layout") {
+			gen.Layout = *layoutFlag
+		}
+		gen.ShowStepNumbers = *numbersFlag
+		gen.SliceProps = *slicePropsFlag
+		gen.SeparateExceptionRow = *separateExceptionsFlag
+		gen.EventLayout = *eventLayoutFlag
+		gen.TestsOnly = *testsOnlyFlag
+		gen.ShowFooter = *footerFlag
+		gen.Timeline = *timelineFlag
+		gen.OmitCommonCSS = *omitCommonCSSFlag
+		if *forceFlag {
+			gen.MaxColumns = 0
+		}
+		return gen, nil
+	}
+
+	gen, err := configure(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if *serveFlag {
+		if inputArg == "-" {
+			fmt.Fprintln(os.Stderr, "Error: --serve cannot be used with stdin")
+			os.Exit(1)
+		}
+
+		// Priority: flag > config > default
+		addr := "127.0.0.1"
+		if cfg.Diagram.Serve.Address != "" {
+			addr = cfg.Diagram.Serve.Address
+		}
+		if flags.Changed("address") {
+			addr = *addressFlag
+		}
+
+		port := 8274
+		if cfg.Diagram.Serve.Port != 0 {
 			port = cfg.Diagram.Serve.Port
 		}
 		if flags.Changed("port") {
 			port = *portFlag
 		}
 
-		if err := serve.Start(inputArg, addr, port, cfg); err != nil {
+		level := slog.LevelInfo
+		if *verboseFlag {
+			level = slog.LevelDebug
+		} else if *quietFlag {
+			level = slog.LevelWarn
+		}
+
+		var page *diagram.Page
+		if *templateFlag != "" {
+			page, err = diagram.ReadPage(*templateFlag)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+		}
+
+		opts := serve.Options{
+			Page:    page,
+			Address: addr,
+			Port:    port,
+			NoOpen:  *noOpenFlag,
+			Logger:  serve.NewLogger(os.Stdout, os.Stderr, level),
+			Parse:   parseOpts,
+
+			ConfigPath: config.Path(configPath),
+			Reload: func() (*diagram.Generator, parser.Options, error) {
+				cfg, err := loadConfig(configPath, profile, strict)
+				if err != nil {
+					return nil, parser.Options{}, err
+				}
+				parseOpts, err := newParseOptions(cfg, strict)
+				if err != nil {
+					return nil, parser.Options{}, err
+				}
+				gen, err := configure(cfg)
+				return gen, parseOpts, err
+			},
+		}
+		if err := serve.Start(inputArg, gen, opts); err != nil {
 			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 			os.Exit(1)
 		}
 		return
 	}
 
-	doc, _ := parseFile(inputArg)
+	doc, name := parseFile(inputArg, parseOpts)
 
-	gen := diagram.New()
-	gen.CSSOverrides = cfg.Diagram.CSS
-	html, err := gen.Generate(doc)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Diagram generation error: %v\n", err)
-		os.Exit(1)
+	if *sliceFlag != "" {
+		doc, err = diagram.SliceDocument(doc, *sliceFlag)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if *explainFlag {
+		fmt.Print(gen.Explain(doc))
+		return
+	}
+
+	var result *diagram.Result
+	if *formatFlag == "outline" {
+		html, err := diagram.Outline(doc)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Outline generation error: %v\n", err)
+			os.Exit(1)
+		}
+		result = &diagram.Result{HTML: html}
+	} else {
+		result, err = gen.GenerateResult(doc)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Diagram generation error: %v\n", err)
+			if errors.Is(err, diagram.ErrTooManyColumns) {
+				fmt.Fprintln(os.Stderr, "Use --force to render anyway.")
+			}
+			os.Exit(1)
+		}
+	}
+
+	for _, w := range result.Warnings {
+		fmt.Fprintf(os.Stderr, "Warning: %s:%s\n", name, w)
 	}
 
 	if *outputFile != "" {
-		if err := os.WriteFile(*outputFile, html, 0644); err != nil {
+		if err := os.WriteFile(*outputFile, result.HTML, 0644); err != nil {
 			fmt.Fprintf(os.Stderr, "Error writing output: %v\n", err)
 			os.Exit(1)
 		}
 	} else {
-		os.Stdout.Write(html)
+		os.Stdout.Write(result.HTML)
 	}
 }
 
-func cmdLint(args []string, cfg *config.Config) {
-	if len(args) < 1 {
-		fmt.Fprintln(os.Stderr, "Usage: emlang lint <file>")
+// newGenerator creates a diagram generator configured from the diagram
+// section of cfg.
+func newGenerator(cfg *config.Config) *diagram.Generator {
+	gen := diagram.New()
+	gen.CSSOverrides = configCSS(cfg)
+	gen.Theme = cfg.Diagram.Theme
+	gen.RefProps = cfg.RefProps
+	gen.MaxLabelChars = cfg.Diagram.MaxLabelChars
+	gen.Layout = cfg.Diagram.Layout
+	if cfg.Diagram.MaxColumns > 0 {
+		gen.MaxColumns = cfg.Diagram.MaxColumns
+	}
+	return gen
+}
+
+// checkDiagramConfig validates the diagram settings of cfg that
+// newGenerator does not check.
+func checkDiagramConfig(cfg *config.Config) error {
+	if _, ok := diagram.Themes[cfg.Diagram.Theme]; cfg.Diagram.Theme != "" && !ok {
+		return fmt.Errorf("invalid diagram.theme %q (expected %s)", cfg.Diagram.Theme, strings.Join(diagram.ThemeNames, ", "))
+	}
+	if cfg.Diagram.Layout != "" && !isLayout(cfg.Diagram.Layout) {
+		return fmt.Errorf("invalid diagram.layout %q (expected %s)", cfg.Diagram.Layout, strings.Join(diagram.Layouts, ", "))
+	}
+	for typeName := range cfg.Diagram.Colors {
+		if _, ok := ast.ParseElementType(typeName); !ok {
+			return fmt.Errorf("unknown element type %q in diagram.colors", typeName)
+		}
+	}
+	return nil
+}
+
+// configCSS returns the CSS variables set by the diagram section of cfg:
+// the --<type>-color variable of each diagram.colors entry, overridden
+// by diagram.css. Unknown type names are skipped; see checkDiagramConfig.
+func configCSS(cfg *config.Config) map[string]string {
+	if len(cfg.Diagram.Colors) == 0 {
+		return cfg.Diagram.CSS
+	}
+	colors := make(map[string]string, len(cfg.Diagram.Colors))
+	for typeName, color := range cfg.Diagram.Colors {
+		if t, ok := ast.ParseElementType(typeName); ok {
+			colors["--"+t.String()+"-color"] = color
+		}
+	}
+	return mergeCSS(colors, cfg.Diagram.CSS)
+}
+
+func cmdBuild(args []string, cfg *config.Config, parseOpts parser.Options) {
+	flags := pflag.NewFlagSet("build", pflag.ExitOnError)
+	outputDir := flags.StringP("output", "o", "", "output directory")
+	formatFlag := flags.String("format", "html", "output format (html)")
+	manifestPath := flags.String("manifest", "", "write a JSON manifest of the built pages to `file`")
+	failFastFlag := flags.Bool("fail-fast", false, "stop at the first file that fails")
+	templateFlag := flags.String("template", cfg.Diagram.Serve.Template, "html/template `file` for the page around each diagram")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: emlang build <dir> -o <output-dir> [--manifest <file>] [--fail-fast] [--template <file>]")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() < 1 || *outputDir == "" {
+		flags.Usage()
+		os.Exit(1)
+	}
+	if *formatFlag != "html" {
+		fmt.Fprintf(os.Stderr, "Error: unsupported --format %q (expected html)\n", *formatFlag)
+		os.Exit(1)
+	}
+	if err := checkDiagramConfig(cfg); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	var page *diagram.Page
+	if *templateFlag != "" {
+		var err error
+		page, err = diagram.ReadPage(*templateFlag)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	manifest, failed, err := buildTree(flags.Arg(0), *outputDir, newGenerator(cfg), page, parseOpts, *failFastFlag, os.Stdout)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if *manifestPath != "" {
+		if err := writeManifest(*manifestPath, manifest); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: writing manifest: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if failed > 0 {
 		os.Exit(1)
 	}
+}
 
-	doc, name := parseFile(args[0])
+// manifestEntry describes one page written by build, for tools such as
+// docs generators that build navigation from the output.
+type manifestEntry struct {
+	Source    string   `json:"source"` // relative to the source directory
+	Output    string   `json:"output"` // relative to the output directory
+	Title     string   `json:"title"`
+	Hash      string   `json:"hash"`      // changes whenever the source does
+	Documents []string `json:"documents"` // HTML id of each document on the page
+	Slices    []string `json:"slices"`
+}
 
+// buildTree renders every .yaml and .yml file under src, except hidden ones,
+// to a standalone HTML page at the same relative path under dst, reporting
+// each file to w. Pages are wrapped with page, or the built-in page if nil.
+// With failFast, it stops at the first file that fails.
+// It returns a manifest entry for each page written and the number of
+// files that failed; err is set only if src cannot be walked.
+func buildTree(src, dst string, gen *diagram.Generator, page *diagram.Page, parseOpts parser.Options, failFast bool, w io.Writer) (manifest []manifestEntry, failed int, err error) {
+	manifest = []manifestEntry{}
+	err = filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		// Skip hidden entries such as .git or the .emlang.yaml config
+		if path != src && strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		ext := filepath.Ext(path)
+		if d.IsDir() || (ext != ".yaml" && ext != ".yml") {
+			return nil
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		outRel := strings.TrimSuffix(rel, ext) + ".html"
+		out := filepath.Join(dst, outRel)
+		doc, err := buildFile(path, out, rel, gen, page, parseOpts)
+		if err != nil {
+			fmt.Fprintf(w, "FAIL %s: %v\n", rel, err)
+			failed++
+			if failFast {
+				return fs.SkipAll
+			}
+			return nil
+		}
+		fmt.Fprintf(w, "ok   %s -> %s\n", rel, out)
+		manifest = append(manifest, newManifestEntry(filepath.ToSlash(rel), filepath.ToSlash(outRel), doc))
+		return nil
+	})
+	return manifest, failed, err
+}
+
+// buildFile renders the file at path to an HTML page at out, wrapped with
+// page, and returns the parsed document.
+func buildFile(path, out, title string, gen *diagram.Generator, page *diagram.Page, parseOpts parser.Options) (*ast.Document, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	doc, err := parser.ParseWithOptions(f, parseOpts)
+	if err != nil {
+		return nil, fmt.Errorf("parse error: %w", err)
+	}
+	html, err := gen.Generate(doc)
+	if err != nil {
+		return nil, fmt.Errorf("diagram generation error: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(out), 0755); err != nil {
+		return nil, err
+	}
+	body, err := page.Render(html, title, "")
+	if err != nil {
+		return nil, err
+	}
+	return doc, os.WriteFile(out, body, 0644)
+}
+
+// newManifestEntry describes the page built from doc.
+func newManifestEntry(source, output string, doc *ast.Document) manifestEntry {
+	entry := manifestEntry{
+		Source:    source,
+		Output:    output,
+		Title:     source,
+		Hash:      diagram.SourceHash(doc),
+		Documents: diagram.DocumentIDs(doc),
+		Slices:    []string{},
+	}
+	if len(doc.SubDocs) > 0 && doc.SubDocs[0].Title != "" {
+		entry.Title = doc.SubDocs[0].Title
+	}
+	for _, sd := range doc.SubDocs {
+		entry.Slices = append(entry.Slices, sd.SliceOrder...)
+	}
+	return entry
+}
+
+// writeManifest writes the build manifest to path as indented JSON.
+func writeManifest(path string, manifest []manifestEntry) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// newLinter creates a linter configured from the lint section of cfg.
+func newLinter(cfg *config.Config) (*linter.Linter, error) {
 	lint := linter.New()
 	for _, rule := range cfg.Lint.Ignore {
 		lint.IgnoreRules[rule] = true
 	}
-	issues := lint.Lint(doc)
+	for _, rule := range cfg.Lint.Enable {
+		lint.EnableRules[rule] = true
+	}
+	for rule, name := range cfg.Lint.Severity {
+		if !linter.IsRule(rule) {
+			return nil, fmt.Errorf("unknown rule %q in lint.severity", rule)
+		}
+		s, ok := linter.ParseSeverity(name)
+		if !ok {
+			return nil, fmt.Errorf("invalid severity %q for %s in lint.severity (expected error or warning)", name, rule)
+		}
+		lint.Severities[rule] = s
+	}
+	for typeName, keys := range cfg.Lint.PropSchema {
+		t, ok := ast.ParseElementType(typeName)
+		if !ok {
+			return nil, fmt.Errorf("unknown element type %q in lint.prop_schema", typeName)
+		}
+		lint.PropSchema[t] = keys
+	}
+	lint.RefProps = cfg.RefProps
+	return lint, nil
+}
+
+// filterRules keeps only the issues reported by the given rules.
+// An empty rule list keeps every issue.
+func filterRules(issues []linter.Issue, rules []string) []linter.Issue {
+	if len(rules) == 0 {
+		return issues
+	}
+	keep := make(map[string]bool, len(rules))
+	for _, r := range rules {
+		keep[r] = true
+	}
+	var filtered []linter.Issue
+	for _, issue := range issues {
+		if keep[issue.Rule] {
+			filtered = append(filtered, issue)
+		}
+	}
+	return filtered
+}
+
+// gitRunner runs git with the given arguments and returns its output.
+type gitRunner func(args ...string) ([]byte, error)
+
+// runGit runs the git command in the current directory.
+func runGit(args ...string) ([]byte, error) {
+	out, err := exec.Command("git", args...).Output()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+		msg, _, _ := strings.Cut(strings.TrimSpace(string(exitErr.Stderr)), "\n")
+		return nil, errors.New(msg)
+	}
+	return out, err
+}
+
+// changedFiles returns the YAML files changed since ref, relative to the
+// current directory, leaving out deleted and hidden files (such as the
+// config). New files not yet added to git count as changed, unless
+// ignored. With paths, only the files at or under one of them are kept.
+func changedFiles(git gitRunner, ref string, paths []string) ([]string, error) {
+	if _, err := git("rev-parse", "--is-inside-work-tree"); err != nil {
+		return nil, fmt.Errorf("--since needs a git repository: %w", err)
+	}
+	out, err := git("diff", "--name-only", "--relative", "--diff-filter=d", ref)
+	if err != nil {
+		return nil, fmt.Errorf("listing files changed since %s: %w", ref, err)
+	}
+	untracked, err := git("ls-files", "--others", "--exclude-standard")
+	if err != nil {
+		return nil, fmt.Errorf("listing untracked files: %w", err)
+	}
+
+	var files []string
+	seen := map[string]bool{}
+	for _, line := range strings.Split(string(out)+"\n"+string(untracked), "\n") {
+		file := strings.TrimSpace(line)
+		if file == "" || seen[file] || strings.HasPrefix(filepath.Base(file), ".") {
+			continue
+		}
+		if ext := filepath.Ext(file); ext != ".yaml" && ext != ".yml" {
+			continue
+		}
+		if len(paths) > 0 && !underAny(file, paths) {
+			continue
+		}
+		seen[file] = true
+		files = append(files, file)
+	}
+	return files, nil
+}
+
+// underAny reports whether file is one of paths or inside one of them.
+func underAny(file string, paths []string) bool {
+	file = filepath.Clean(file)
+	for _, p := range paths {
+		p = filepath.Clean(p)
+		if p == "." || file == p || strings.HasPrefix(file, p+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// baselineEntry identifies a known lint issue. Lines are left out so that
+// entries keep matching as the file around the issue changes.
+type baselineEntry struct {
+	File    string `json:"file"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// baselineFile is the JSON document written by lint --write-baseline.
+type baselineFile struct {
+	Issues []baselineEntry `json:"issues"`
+}
+
+// writeBaseline writes every issue of results as a baseline.
+func writeBaseline(w io.Writer, results []lintResult) error {
+	b := baselineFile{Issues: []baselineEntry{}}
+	for _, r := range results {
+		for _, issue := range r.issues {
+			b.Issues = append(b.Issues, baselineEntry{File: baselinePath(r.name), Rule: issue.Rule, Message: issue.Message})
+		}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(b)
+}
+
+// baselinePath normalizes a file path for a baseline entry, so that
+// "./a.yaml" and "a.yaml", or Windows separators, match the same entry.
+func baselinePath(name string) string {
+	return filepath.ToSlash(filepath.Clean(name))
+}
+
+// readBaseline reads a baseline written by writeBaseline.
+func readBaseline(path string) ([]baselineEntry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading baseline: %w", err)
+	}
+	var b baselineFile
+	if err := json.Unmarshal(data, &b); err != nil {
+		return nil, fmt.Errorf("parsing baseline %s: %w", path, err)
+	}
+	return b.Issues, nil
+}
+
+// applyBaseline removes the issues recorded in baseline from results.
+// Each entry suppresses one matching issue, so a second occurrence of a
+// known issue is still reported.
+func applyBaseline(results []lintResult, baseline []baselineEntry) []lintResult {
+	known := make(map[baselineEntry]int)
+	for _, e := range baseline {
+		e.File = baselinePath(e.File)
+		known[e]++
+	}
+	filtered := make([]lintResult, len(results))
+	for i, r := range results {
+		filtered[i] = lintResult{name: r.name, err: r.err}
+		for _, issue := range r.issues {
+			key := baselineEntry{File: baselinePath(r.name), Rule: issue.Rule, Message: issue.Message}
+			if known[key] > 0 {
+				known[key]--
+				continue
+			}
+			filtered[i].issues = append(filtered[i].issues, issue)
+		}
+	}
+	return filtered
+}
 
-	if len(issues) == 0 {
-		fmt.Printf("%s: OK (no issues found)\n", name)
+// lintFiles lints each file in turn with lintOne. If stop is not nil,
+// it returns early, after the first result for which stop reports true.
+func lintFiles(files []string, lintOne func(string) lintResult, stop func(lintResult) bool) []lintResult {
+	var results []lintResult
+	for _, file := range files {
+		r := lintOne(file)
+		results = append(results, r)
+		if stop != nil && stop(r) {
+			break
+		}
+	}
+	return results
+}
+
+// lintResult holds the issues reported for one file, or the error that
+// kept it from being linted.
+type lintResult struct {
+	name   string
+	issues []linter.Issue
+	err    error // reading or parsing the file failed
+}
+
+// reported returns the issues of r, with a read or parse error reported
+// as a parse-error issue so that it is counted like any other error.
+func (r lintResult) reported() []linter.Issue {
+	if r.err == nil {
+		return r.issues
+	}
+	return []linter.Issue{{Rule: "parse-error", Message: r.err.Error(), Severity: linter.SeverityError}}
+}
+
+// lintTotals aggregates lint results over all linted files.
+type lintTotals struct {
+	files           int
+	filesWithIssues int
+	errors          int
+	warnings        int
+	failed          bool // true if any issue reaches the --fail-on severity
+}
+
+// summarizeLint totals results. failOn is "error", "warning" or "none".
+func summarizeLint(results []lintResult, failOn string) lintTotals {
+	var t lintTotals
+	for _, r := range results {
+		t.files++
+		if len(r.reported()) > 0 {
+			t.filesWithIssues++
+		}
+		for _, issue := range r.reported() {
+			if issue.Severity == linter.SeverityError {
+				t.errors++
+			} else {
+				t.warnings++
+			}
+		}
+	}
+
+	switch failOn {
+	case "error":
+		t.failed = t.errors > 0
+	case "warning":
+		t.failed = t.errors+t.warnings > 0
+	}
+	return t
+}
+
+func (t lintTotals) String() string {
+	return fmt.Sprintf("%d file(s), %d with issues, %d error(s), %d warning(s)",
+		t.files, t.filesWithIssues, t.errors, t.warnings)
+}
+
+// writeMarkdownIssues writes lint results as a Markdown table,
+// suitable for posting as a pull request comment.
+func writeMarkdownIssues(w io.Writer, results []lintResult) {
+	var names []string
+	count := 0
+	for _, r := range results {
+		names = append(names, "`"+r.name+"`")
+		count += len(r.reported())
+	}
+	if count == 0 {
+		fmt.Fprintf(w, "No issues found in %s.\n", strings.Join(names, ", "))
 		return
 	}
 
-	errorCount := 0
-	warningCount := 0
-	for _, issue := range issues {
-		if issue.Severity == linter.SeverityError {
-			errorCount++
-		} else {
-			warningCount++
+	cell := strings.NewReplacer("|", "\\|", "\n", " ")
+	fmt.Fprintln(w, "| File | Line | Severity | Rule | Message |")
+	fmt.Fprintln(w, "|------|------|----------|------|---------|")
+	for _, r := range results {
+		for _, issue := range r.reported() {
+			fmt.Fprintf(w, "| %s | %d | %s | `%s` | %s |\n",
+				cell.Replace(r.name), issue.Line, issue.Severity, issue.Rule, cell.Replace(issue.Message))
 		}
 	}
+}
 
-	fmt.Printf("%s: %d issue(s) found\n", name, len(issues))
-	fmt.Println("----------------------------------------")
+// ndjsonIssue is one line of lint --format ndjson output.
+type ndjsonIssue struct {
+	File     string `json:"file"`
+	Line     int    `json:"line"`
+	Column   int    `json:"column"`
+	Severity string `json:"severity"`
+	Rule     string `json:"rule"`
+	Message  string `json:"message"`
+}
 
-	for _, issue := range issues {
-		severity := "warning"
-		if issue.Severity == linter.SeverityError {
-			severity = "error"
+// writeNDJSONIssues writes the issues of one file as newline-delimited
+// JSON, one object per issue. A file without issues writes nothing, and
+// a file that failed to parse writes one parse-error object.
+func writeNDJSONIssues(w io.Writer, r lintResult) error {
+	enc := json.NewEncoder(w)
+	for _, issue := range r.reported() {
+		err := enc.Encode(ndjsonIssue{
+			File:     r.name,
+			Line:     issue.Line,
+			Column:   issue.Column,
+			Severity: issue.Severity.String(),
+			Rule:     issue.Rule,
+			Message:  issue.Message,
+		})
+		if err != nil {
+			return err
 		}
-		fmt.Printf("%s:%d:%d: %s: %s [%s]\n",
-			name, issue.Line, issue.Column, severity, issue.Message, issue.Rule)
 	}
+	return nil
+}
 
-	fmt.Println("----------------------------------------")
-	fmt.Printf("Summary: %d error(s), %d warning(s)\n", errorCount, warningCount)
+// writeTextIssues writes lint results as text, one section per file,
+// followed by a total line when several files were linted. With quiet,
+// files without issues are left out and a clean run writes nothing.
+func writeTextIssues(w io.Writer, results []lintResult, totals lintTotals, quiet bool) {
+	printed := 0
+	for _, r := range results {
+		if quiet && len(r.reported()) == 0 {
+			continue
+		}
+		if printed > 0 {
+			fmt.Fprintln(w)
+		}
+		writeTextResult(w, r)
+		printed++
+	}
+	if len(results) > 1 && printed > 0 {
+		fmt.Fprintln(w)
+		fmt.Fprintf(w, "Total: %s\n", totals)
+	}
+}
+
+// writeTextResult writes the lint section for one file.
+func writeTextResult(w io.Writer, r lintResult) {
+	if r.err != nil {
+		fmt.Fprintf(w, "%s: %v\n", r.name, r.err)
+		return
+	}
+	if len(r.issues) == 0 {
+		fmt.Fprintf(w, "%s: OK (no issues found)\n", r.name)
+		return
+	}
+
+	t := summarizeLint([]lintResult{r}, "none")
+
+	fmt.Fprintf(w, "%s: %d issue(s) found\n", r.name, len(r.issues))
+	fmt.Fprintln(w, "----------------------------------------")
+
+	for _, issue := range r.issues {
+		fmt.Fprintf(w, "%s:%d:%d: %s: %s [%s]\n",
+			r.name, issue.Line, issue.Column, issue.Severity, issue.Message, issue.Rule)
+	}
+
+	fmt.Fprintln(w, "----------------------------------------")
+	fmt.Fprintf(w, "Summary: %d error(s), %d warning(s)\n", t.errors, t.warnings)
+}
+
+func cmdLint(args []string, cfg *config.Config, parseOpts parser.Options) {
+	flags := pflag.NewFlagSet("lint", pflag.ExitOnError)
+	onlyFlag := flags.StringSlice("only", nil, "report only these rules (comma-separated or repeated)")
+	formatFlag := flags.String("format", "text", "output format: text, markdown or ndjson")
+	failOnFlag := flags.String("fail-on", "error", "exit non-zero on issues of this severity or higher: error, warning or none")
+	quietFlag := flags.BoolP("quiet", "q", false, "print nothing for files without issues")
+	baselineFlag := flags.String("baseline", "", "suppress the known issues recorded in this baseline file")
+	writeBaselineFlag := flags.String("write-baseline", "", "record the current issues in this baseline file")
+	sinceFlag := flags.String("since", "", "lint only the YAML files changed since this git ref, under the given paths if any")
+	failFastFlag := flags.Bool("fail-fast", false, "stop at the first file with issues reaching the --fail-on severity")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: emlang lint [--only rule[,rule...]] [--format text|markdown|ndjson] [--fail-on error|warning|none] [-q] [--fail-fast] [--baseline file | --write-baseline file] <file>...")
+		fmt.Fprintln(os.Stderr, "       emlang lint --since <ref> [options] [path...]")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() < 1 && *sinceFlag == "" {
+		flags.Usage()
+		os.Exit(1)
+	}
+
+	for _, rule := range *onlyFlag {
+		if !linter.IsRule(rule) {
+			fmt.Fprintf(os.Stderr, "Error: unknown rule %q in --only\n", rule)
+			os.Exit(1)
+		}
+	}
+
+	switch *formatFlag {
+	case "text", "markdown", "ndjson":
+	default:
+		fmt.Fprintf(os.Stderr, "Error: invalid --format %q (expected text, markdown or ndjson)\n", *formatFlag)
+		os.Exit(1)
+	}
+
+	switch *failOnFlag {
+	case "error", "warning", "none":
+	default:
+		fmt.Fprintf(os.Stderr, "Error: invalid --fail-on %q (expected error, warning or none)\n", *failOnFlag)
+		os.Exit(1)
+	}
+
+	if *baselineFlag != "" && *writeBaselineFlag != "" {
+		fmt.Fprintln(os.Stderr, "Error: --baseline and --write-baseline are mutually exclusive")
+		os.Exit(1)
+	}
+	if *failFastFlag && *writeBaselineFlag != "" {
+		fmt.Fprintln(os.Stderr, "Error: --fail-fast and --write-baseline are mutually exclusive")
+		os.Exit(1)
+	}
+
+	lint, err := newLinter(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	files := flags.Args()
+	if *sinceFlag != "" {
+		files, err = changedFiles(runGit, *sinceFlag, flags.Args())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		if len(files) == 0 {
+			if !*quietFlag && *formatFlag != "ndjson" {
+				fmt.Printf("No changed YAML files since %s\n", *sinceFlag)
+			}
+			return
+		}
+	}
+
+	var baseline []baselineEntry
+	if *baselineFlag != "" {
+		baseline, err = readBaseline(*baselineFlag)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	lintOne := func(arg string) lintResult {
+		doc, name, err := loadFile(arg, parseOpts)
+		if err != nil {
+			return lintResult{name: name, err: err}
+		}
+		r := lintResult{
+			name:   name,
+			issues: filterRules(lint.Lint(doc), *onlyFlag),
+		}
+		return applyBaseline([]lintResult{r}, baseline)[0]
+	}
+	if *formatFlag == "ndjson" && *writeBaselineFlag == "" {
+		// Stream each file's issues as soon as it is linted rather than
+		// once every file is done.
+		lintFile := lintOne
+		lintOne = func(arg string) lintResult {
+			r := lintFile(arg)
+			if err := writeNDJSONIssues(os.Stdout, r); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			return r
+		}
+	}
+	var stop func(lintResult) bool
+	if *failFastFlag {
+		stop = func(r lintResult) bool {
+			return summarizeLint([]lintResult{r}, *failOnFlag).failed
+		}
+	}
+	results := lintFiles(files, lintOne, stop)
+
+	if *writeBaselineFlag != "" {
+		for _, r := range results {
+			if r.err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", r.name, r.err)
+				os.Exit(1)
+			}
+		}
+		var buf bytes.Buffer
+		if err := writeBaseline(&buf, results); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		if err := os.WriteFile(*writeBaselineFlag, buf.Bytes(), 0644); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing %s: %v\n", *writeBaselineFlag, err)
+			os.Exit(1)
+		}
+		t := summarizeLint(results, "none")
+		fmt.Printf("Wrote %d issue(s) to %s\n", t.errors+t.warnings, *writeBaselineFlag)
+		return
+	}
+
+	totals := summarizeLint(results, *failOnFlag)
+
+	switch *formatFlag {
+	case "markdown":
+		if !*quietFlag || totals.filesWithIssues > 0 {
+			writeMarkdownIssues(os.Stdout, results)
+		}
+	case "text":
+		writeTextIssues(os.Stdout, results, totals, *quietFlag)
+	}
 
-	if errorCount > 0 {
+	if totals.failed {
 		os.Exit(1)
 	}
 }
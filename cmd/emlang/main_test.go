@@ -0,0 +1,778 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/emlang-project/emlang/internal/ast"
+	"github.com/emlang-project/emlang/internal/config"
+	"github.com/emlang-project/emlang/internal/diagram"
+	"github.com/emlang-project/emlang/internal/formatter"
+	"github.com/emlang-project/emlang/internal/linter"
+	"github.com/emlang-project/emlang/internal/parser"
+)
+
+func TestFilterRules(t *testing.T) {
+	issues := []linter.Issue{
+		{Rule: "command-without-event"},
+		{Rule: "orphan-exception"},
+		{Rule: "slice-missing-event"},
+	}
+
+	if got := filterRules(issues, nil); len(got) != 3 {
+		t.Errorf("expected all issues without --only, got %d", len(got))
+	}
+
+	got := filterRules(issues, []string{"orphan-exception", "slice-missing-event"})
+	if len(got) != 2 {
+		t.Fatalf("expected 2 issues, got %d", len(got))
+	}
+	if got[0].Rule != "orphan-exception" || got[1].Rule != "slice-missing-event" {
+		t.Errorf("unexpected issues: %v", got)
+	}
+}
+
+func TestWriteMarkdownIssues(t *testing.T) {
+	issues := []linter.Issue{
+		{Rule: "command-without-event", Message: "command should be followed by an event or exception", Line: 4, Column: 7, Severity: linter.SeverityWarning},
+		{Rule: "missing-required-prop", Message: `command "A|B" is missing required prop "id"`, Line: 9, Column: 7, Severity: linter.SeverityError},
+	}
+
+	var buf bytes.Buffer
+	writeMarkdownIssues(&buf, []lintResult{{name: "model.yaml", issues: issues}})
+
+	expected := "| File | Line | Severity | Rule | Message |\n" +
+		"|------|------|----------|------|---------|\n" +
+		"| model.yaml | 4 | warning | `command-without-event` | command should be followed by an event or exception |\n" +
+		"| model.yaml | 9 | error | `missing-required-prop` | command \"A\\|B\" is missing required prop \"id\" |\n"
+	if buf.String() != expected {
+		t.Errorf("markdown output:\ngot:\n%s\nwant:\n%s", buf.String(), expected)
+	}
+
+	buf.Reset()
+	writeMarkdownIssues(&buf, []lintResult{{name: "model.yaml"}})
+	if buf.String() != "No issues found in `model.yaml`.\n" {
+		t.Errorf("unexpected output without issues: %q", buf.String())
+	}
+}
+
+func TestSummarizeLint(t *testing.T) {
+	clean := `
+slices:
+  checkout:
+    - c: PlaceOrder
+    - e: OrderPlaced
+`
+	dirty := `
+slices:
+  checkout:
+    - c: PlaceOrder
+  shipping:
+    - c: ShipOrder
+`
+
+	var results []lintResult
+	for _, f := range []struct{ name, input string }{{"clean.yaml", clean}, {"dirty.yaml", dirty}} {
+		doc, err := parser.Parse(strings.NewReader(f.input))
+		if err != nil {
+			t.Fatalf("%s: parse error: %v", f.name, err)
+		}
+		results = append(results, lintResult{name: f.name, issues: linter.New().Lint(doc)})
+	}
+
+	totals := summarizeLint(results, "error")
+	if totals.files != 2 || totals.filesWithIssues != 1 || totals.errors != 0 || totals.warnings != 4 {
+		t.Errorf("unexpected totals: %+v", totals)
+	}
+	if totals.String() != "2 file(s), 1 with issues, 0 error(s), 4 warning(s)" {
+		t.Errorf("unexpected summary: %q", totals.String())
+	}
+	if totals.failed {
+		t.Error("expected warnings not to fail with --fail-on error")
+	}
+	if !summarizeLint(results, "warning").failed {
+		t.Error("expected warnings to fail with --fail-on warning")
+	}
+	if summarizeLint(results, "none").failed {
+		t.Error("expected nothing to fail with --fail-on none")
+	}
+}
+
+func TestLintFilesParseError(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"bad.yaml":   "slices:\n  checkout:\n    - c: [\n",
+		"clean.yaml": "slices:\n  checkout:\n    - c: PlaceOrder\n    - e: OrderPlaced\n",
+		"dirty.yaml": "slices:\n  checkout:\n    - c: PlaceOrder\n",
+	}
+	var paths []string
+	for _, name := range []string{"bad.yaml", "clean.yaml", "dirty.yaml"} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(files[name]), 0644); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+
+	lintOne := func(arg string) lintResult {
+		doc, name, err := loadFile(arg, parser.Options{})
+		if err != nil {
+			return lintResult{name: name, err: err}
+		}
+		return lintResult{name: name, issues: linter.New().Lint(doc)}
+	}
+	results := lintFiles(paths, lintOne, nil)
+	if len(results) != 3 || results[0].err == nil {
+		t.Fatalf("expected every file linted after the parse error, got %+v", results)
+	}
+
+	totals := summarizeLint(results, "error")
+	if totals.files != 3 || totals.filesWithIssues != 2 || totals.errors != 1 {
+		t.Errorf("unexpected totals: %+v", totals)
+	}
+	if !totals.failed {
+		t.Error("expected the parse error to fail with --fail-on error")
+	}
+
+	var buf bytes.Buffer
+	writeTextIssues(&buf, results, totals, true)
+	out := buf.String()
+	if !strings.Contains(out, paths[0]+": parse error: ") {
+		t.Errorf("expected the parse error in its file section, got:\n%s", out)
+	}
+	if !strings.Contains(out, "dirty.yaml: 2 issue(s) found") || !strings.Contains(out, "Total: 3 file(s), 2 with issues, 1 error(s)") {
+		t.Errorf("expected later files and the totals, got:\n%s", out)
+	}
+}
+
+func TestMergeCSSFlags(t *testing.T) {
+	base := map[string]string{
+		"--event-color":   "#ffd8a8",
+		"--command-color": "#a5d8ff",
+	}
+
+	merged, err := mergeCSSFlags(base, []string{"--event-color=#fff", "--view-color=#0f0"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if merged["--event-color"] != "#fff" {
+		t.Errorf("expected flag to override config, got %q", merged["--event-color"])
+	}
+	if merged["--command-color"] != "#a5d8ff" {
+		t.Errorf("expected config value kept, got %q", merged["--command-color"])
+	}
+	if merged["--view-color"] != "#0f0" {
+		t.Errorf("expected flag-only value, got %q", merged["--view-color"])
+	}
+	if base["--event-color"] != "#ffd8a8" {
+		t.Error("expected base map to be left unchanged")
+	}
+}
+
+func TestLoadConfigFlagOverridesProfile(t *testing.T) {
+	cfgFile := filepath.Join(t.TempDir(), ".emlang.yaml")
+	content := `parser:
+  strict: false
+profiles:
+  ci:
+    parser:
+      strict: false
+`
+	if err := os.WriteFile(cfgFile, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		strict bool
+		want   string
+	}{
+		{false, "strict: false"},
+		{true, "strict: true"},
+	} {
+		cfg, err := loadConfig(cfgFile, "ci", tc.strict)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		out, err := config.Dump(cfg)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !strings.Contains(string(out), tc.want) {
+			t.Errorf("with --strict=%v, expected %q in dump, got:\n%s", tc.strict, tc.want, out)
+		}
+	}
+}
+
+func TestNewLinterSeverity(t *testing.T) {
+	doc, err := parser.Parse(strings.NewReader("slices:\n  checkout:\n    - c: PlaceOrder\n    - e: OrderPlaced\n    - c: ShipOrder\n"))
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+
+	cfg := &config.Config{Lint: config.LintConfig{Severity: map[string]string{"command-without-event": "error"}}}
+	lint, err := newLinter(cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	issues := lint.Lint(doc)
+	if len(issues) != 1 || issues[0].Severity != linter.SeverityError {
+		t.Fatalf("expected command-without-event promoted to error, got %v", issues)
+	}
+	if !summarizeLint([]lintResult{{name: "model.yaml", issues: issues}}, "error").failed {
+		t.Error("expected the promoted issue to fail with --fail-on error")
+	}
+
+	for _, severity := range []map[string]string{
+		{"no-such-rule": "error"},
+		{"command-without-event": "fatal"},
+	} {
+		cfg.Lint.Severity = severity
+		if _, err := newLinter(cfg); err == nil {
+			t.Errorf("expected an error for lint.severity %v", severity)
+		}
+	}
+}
+
+func TestConfigCSSColors(t *testing.T) {
+	cfg := &config.Config{Diagram: config.DiagramConfig{
+		Colors: map[string]string{"event": "#abc", "command": "#def"},
+		CSS:    map[string]string{"--command-color": "#123"},
+	}}
+	if err := checkDiagramConfig(cfg); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	css := newGenerator(cfg).CSSOverrides
+	if css["--event-color"] != "#abc" {
+		t.Errorf("expected colors.event to set --event-color, got %v", css)
+	}
+	if css["--command-color"] != "#123" {
+		t.Errorf("expected css to override colors, got %v", css)
+	}
+
+	cfg.Diagram.Colors["widget"] = "#000"
+	if err := checkDiagramConfig(cfg); err == nil || !strings.Contains(err.Error(), `"widget"`) {
+		t.Errorf("expected an unknown type error, got %v", err)
+	}
+}
+
+func TestMergeCSSFlagsInvalid(t *testing.T) {
+	for _, fv := range []string{"event-color=#fff", "--event-color"} {
+		if _, err := mergeCSSFlags(nil, []string{fv}); err == nil {
+			t.Errorf("expected error for %q", fv)
+		}
+	}
+}
+
+func TestCSSPrecedence(t *testing.T) {
+	config := map[string]string{
+		"--event-color":   "config",
+		"--command-color": "config",
+		"--view-color":    "config",
+	}
+	file, err := parseCSSFile([]byte(`{"--command-color": "file", "--view-color": "file"}`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	css, err := mergeCSSFlags(mergeCSS(config, file), []string{"--view-color=flag"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := map[string]string{
+		"--event-color":   "config",
+		"--command-color": "file",
+		"--view-color":    "flag",
+	}
+	for k, v := range want {
+		if css[k] != v {
+			t.Errorf("%s: expected %q, got %q", k, v, css[k])
+		}
+	}
+}
+
+func TestParseCSSFileYAML(t *testing.T) {
+	css, err := parseCSSFile([]byte("--event-color: \"#fff\"\n"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if css["--event-color"] != "#fff" {
+		t.Errorf("unexpected --event-color: %q", css["--event-color"])
+	}
+
+	if _, err := parseCSSFile([]byte("event-color: red\n")); err == nil {
+		t.Error("expected error for variable without -- prefix")
+	}
+}
+
+func TestFlowLine(t *testing.T) {
+	doc, err := parser.Parse(strings.NewReader(`
+slices:
+  register:
+    - t: User/ClickRegister
+    - c: RegisterUser
+    - alt:
+        ok:
+          - e: UserRegistered
+        taken:
+          - x: Billing/EmailTaken
+  placeholder:
+`))
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+
+	slices := doc.AllSlicesInOrder()
+	want := "register: User/ClickRegister → RegisterUser → (UserRegistered | ⨯Billing/EmailTaken)"
+	if got := flowLine(slices[0]); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got := flowLine(slices[1]); got != "placeholder:" {
+		t.Errorf("got %q for an empty slice", got)
+	}
+}
+
+func TestWriteSpec(t *testing.T) {
+	doc, err := parser.Parse(strings.NewReader(`
+slices:
+  register:
+    steps:
+      - c: RegisterUser
+      - e: Accounts/UserRegistered
+    tests:
+      registers-once:
+        given:
+          - e: Accounts/UserRegistered
+        when:
+          - c: RegisterUser
+        then:
+          - x: EmailTaken
+`))
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+
+	var buf bytes.Buffer
+	writeSpec(&buf, "model.yaml", doc)
+
+	expected := "# model.yaml\n" +
+		"\n" +
+		"## register\n" +
+		"\n" +
+		"`RegisterUser → Accounts/UserRegistered`\n" +
+		"\n" +
+		"### registers-once\n" +
+		"\n" +
+		"- **Given**\n" +
+		"  - event Accounts/UserRegistered\n" +
+		"- **When**\n" +
+		"  - command RegisterUser\n" +
+		"- **Then**\n" +
+		"  - exception EmailTaken\n"
+	if buf.String() != expected {
+		t.Errorf("spec output:\ngot:\n%s\nwant:\n%s", buf.String(), expected)
+	}
+}
+
+func TestBuildTree(t *testing.T) {
+	src := t.TempDir()
+	dst := t.TempDir()
+	files := map[string]string{
+		"a.yaml":          "title: Process A\nslices:\n  a:\n    - c: DoA\n    - e: DidA\n",
+		"sub/b.yml":       "slices:\n  b:\n    - c: DoB\n    - e: DidB\n",
+		"sub/broken.yaml": "slices:\n  c:\n    - z: Unknown\n",
+		"notes.txt":       "not a model",
+		".emlang.yaml":    "lint:\n  ignore: []\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(src, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var out bytes.Buffer
+	manifest, failed, err := buildTree(src, dst, diagram.New(), nil, parser.Options{}, false, &out)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if failed != 1 {
+		t.Errorf("expected 1 failure, got %d", failed)
+	}
+	if !strings.Contains(out.String(), "FAIL "+filepath.Join("sub", "broken.yaml")) {
+		t.Errorf("expected the broken file to be reported, got:\n%s", out.String())
+	}
+
+	for _, name := range []string{"a.html", filepath.Join("sub", "b.html")} {
+		page, err := os.ReadFile(filepath.Join(dst, name))
+		if err != nil {
+			t.Errorf("expected %s: %v", name, err)
+			continue
+		}
+		if !strings.HasPrefix(string(page), "<!DOCTYPE html>") || !strings.Contains(string(page), `class="emlang-documents"`) {
+			t.Errorf("expected a standalone diagram page in %s", name)
+		}
+	}
+	for _, name := range []string{filepath.Join("sub", "broken.html"), "notes.html"} {
+		if _, err := os.Stat(filepath.Join(dst, name)); err == nil {
+			t.Errorf("expected no %s", name)
+		}
+	}
+
+	if len(manifest) != 2 {
+		t.Fatalf("expected 2 manifest entries, got %+v", manifest)
+	}
+	// b has no title, so its source path is used
+	expected := []struct{ source, output, title, slice string }{
+		{"a.yaml", "a.html", "Process A", "a"},
+		{"sub/b.yml", "sub/b.html", "sub/b.yml", "b"},
+	}
+	for i, want := range expected {
+		got := manifest[i]
+		if got.Source != want.source || got.Output != want.output || got.Title != want.title {
+			t.Errorf("entry %d: expected %s -> %s titled %q, got %+v", i, want.source, want.output, want.title, got)
+		}
+		if len(got.Slices) != 1 || got.Slices[0] != want.slice {
+			t.Errorf("entry %d: expected slices [%s], got %v", i, want.slice, got.Slices)
+		}
+		if len(got.Hash) != 12 || len(got.Documents) != 1 || got.Documents[0] != "emlang-document-"+got.Hash+"-0" {
+			t.Errorf("entry %d: unexpected hash %q or documents %v", i, got.Hash, got.Documents)
+		}
+		page, _ := os.ReadFile(filepath.Join(dst, filepath.FromSlash(got.Output)))
+		if !strings.Contains(string(page), `id="`+got.Documents[0]+`"`) {
+			t.Errorf("entry %d: expected the page to contain document id %s", i, got.Documents[0])
+		}
+	}
+}
+
+func TestBuildTreeFailFast(t *testing.T) {
+	src := t.TempDir()
+	dst := t.TempDir()
+	files := map[string]string{
+		"a.yaml": "slices:\n  a:\n    - z: Unknown\n",
+		"b.yaml": "slices:\n  b:\n    - c: DoB\n    - e: DidB\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(src, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var out bytes.Buffer
+	manifest, failed, err := buildTree(src, dst, diagram.New(), nil, parser.Options{}, true, &out)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if failed != 1 || len(manifest) != 0 {
+		t.Errorf("expected 1 failure and no pages, got %d failures and %+v", failed, manifest)
+	}
+	if _, err := os.Stat(filepath.Join(dst, "b.html")); err == nil {
+		t.Error("expected the build to stop before b.yaml")
+	}
+	if strings.Contains(out.String(), "b.yaml") {
+		t.Errorf("expected b.yaml not to be reported, got:\n%s", out.String())
+	}
+}
+
+func TestLintFilesFailFast(t *testing.T) {
+	issue := linter.Issue{Rule: "slice-missing-event", Message: "slice has no events", Severity: linter.SeverityError}
+	var linted []string
+	lintOne := func(file string) lintResult {
+		linted = append(linted, file)
+		if file == "b.yaml" {
+			return lintResult{name: file, issues: []linter.Issue{issue}}
+		}
+		return lintResult{name: file}
+	}
+	stop := func(r lintResult) bool { return summarizeLint([]lintResult{r}, "error").failed }
+	files := []string{"a.yaml", "b.yaml", "c.yaml"}
+
+	results := lintFiles(files, lintOne, stop)
+	if strings.Join(linted, ",") != "a.yaml,b.yaml" || len(results) != 2 {
+		t.Errorf("expected lint to stop after b.yaml, linted %v", linted)
+	}
+
+	linted = nil
+	lintFiles(files, lintOne, nil)
+	if len(linted) != 3 {
+		t.Errorf("expected every file to be linted without fail-fast, linted %v", linted)
+	}
+
+	// A file that fails to parse stops the run like a failing lint issue.
+	linted = nil
+	unparsable := func(file string) lintResult {
+		linted = append(linted, file)
+		if file == "a.yaml" {
+			return lintResult{name: file, err: errors.New("parse error: bad indentation")}
+		}
+		return lintResult{name: file}
+	}
+	results = lintFiles(files, unparsable, stop)
+	if strings.Join(linted, ",") != "a.yaml" || len(results) != 1 || results[0].err == nil {
+		t.Errorf("expected lint to stop after the unparsable a.yaml, linted %v", linted)
+	}
+}
+
+func TestWriteNDJSONIssues(t *testing.T) {
+	results := []lintResult{
+		{name: "a.yaml", issues: []linter.Issue{
+			{Rule: "slice-missing-event", Message: "slice \"pay\" has no events", Line: 3, Column: 5, Severity: linter.SeverityError},
+			{Rule: "dangling-ref", Message: "unknown element", Line: 7, Column: 9, Severity: linter.SeverityWarning},
+		}},
+		{name: "b.yaml"},
+		{name: "bad.yaml", err: errors.New("parse error: bad indentation")},
+		{name: "c.yaml", issues: []linter.Issue{
+			{Rule: "test-missing-when", Message: "test has no when", Line: 2, Column: 1, Severity: linter.SeverityWarning},
+		}},
+	}
+
+	var buf bytes.Buffer
+	for _, r := range results {
+		if err := writeNDJSONIssues(&buf, r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("expected one line per issue, got:\n%s", buf.String())
+	}
+	var got []ndjsonIssue
+	for _, line := range lines {
+		var issue ndjsonIssue
+		if err := json.Unmarshal([]byte(line), &issue); err != nil {
+			t.Fatalf("invalid JSON line %q: %v", line, err)
+		}
+		got = append(got, issue)
+	}
+	want := ndjsonIssue{File: "a.yaml", Line: 3, Column: 5, Severity: "error", Rule: "slice-missing-event", Message: `slice "pay" has no events`}
+	if got[0] != want {
+		t.Errorf("got %+v, want %+v", got[0], want)
+	}
+	want = ndjsonIssue{File: "bad.yaml", Severity: "error", Rule: "parse-error", Message: "parse error: bad indentation"}
+	if got[2] != want {
+		t.Errorf("got %+v, want %+v", got[2], want)
+	}
+	if got[3].File != "c.yaml" || got[3].Severity != "warning" {
+		t.Errorf("expected a c.yaml warning last, got %+v", got[3])
+	}
+}
+
+func TestVerifyIdempotent(t *testing.T) {
+	input := `---
+slices:
+  zeta:
+    - &pay
+      c: Billing / Pay
+      props:
+        amount: number
+        external: true
+    - e: "Paid {at: timestamp}"
+  alpha:
+    steps:
+      - *pay
+      - x: PaymentFailed
+        props:
+          label: |-
+            Payment
+            failed
+    tests:
+      declined:
+        when:
+          - c: Pay
+        then:
+          - x: PaymentFailed
+---
+slices:
+  other:
+    - v: Balance
+`
+	doc, err := parser.Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+
+	for _, opts := range []formatter.Options{
+		{KeyStyle: "long"},
+		{KeyStyle: "short", PreserveAliases: true, SortSlices: true},
+		{KeyStyle: "keep", NormalizeSwimlanes: true},
+	} {
+		format := func(doc *ast.Document) []byte { return formatter.Format(doc, opts) }
+		if err := verifyIdempotent(format(doc), format, parser.Options{}); err != nil {
+			t.Errorf("%+v: unexpected error: %v", opts, err)
+		}
+	}
+
+	// A formatter that only sorts slices on its second pass is not idempotent.
+	passes := 0
+	unstable := func(doc *ast.Document) []byte {
+		passes++
+		return formatter.Format(doc, formatter.Options{SortSlices: passes > 1})
+	}
+	err = verifyIdempotent(unstable(doc), unstable, parser.Options{})
+	if err == nil || !strings.Contains(err.Error(), "not idempotent: line 2") {
+		t.Errorf("expected a non-idempotent error at line 2, got %v", err)
+	}
+}
+
+func TestWriteTextIssuesQuiet(t *testing.T) {
+	issue := linter.Issue{Rule: "slice-missing-event", Message: "slice has no events", Line: 3, Column: 3, Severity: linter.SeverityWarning}
+	clean := []lintResult{{name: "a.yaml"}, {name: "b.yaml"}}
+
+	var buf bytes.Buffer
+	writeTextIssues(&buf, clean, summarizeLint(clean, "error"), true)
+	if buf.Len() != 0 {
+		t.Errorf("expected no output for clean files under quiet, got %q", buf.String())
+	}
+
+	buf.Reset()
+	writeTextIssues(&buf, clean, summarizeLint(clean, "error"), false)
+	if !strings.Contains(buf.String(), "a.yaml: OK") {
+		t.Errorf("expected OK line without quiet, got %q", buf.String())
+	}
+
+	mixed := []lintResult{{name: "a.yaml"}, {name: "b.yaml", issues: []linter.Issue{issue}}}
+	buf.Reset()
+	writeTextIssues(&buf, mixed, summarizeLint(mixed, "error"), true)
+	out := buf.String()
+	if strings.Contains(out, "a.yaml") {
+		t.Errorf("clean file should be omitted under quiet, got %q", out)
+	}
+	if !strings.Contains(out, "b.yaml:3:3: warning") || !strings.Contains(out, "Total: 2 file(s), 1 with issues") {
+		t.Errorf("unexpected quiet output: %q", out)
+	}
+}
+
+func TestBaseline(t *testing.T) {
+	known := linter.Issue{Rule: "slice-missing-event", Message: `slice "a" has no events`, Line: 3, Column: 3, Severity: linter.SeverityWarning}
+	results := []lintResult{{name: "./models/../model.yaml", issues: []linter.Issue{known}}}
+
+	path := filepath.Join(t.TempDir(), ".emlang-baseline.json")
+	var buf bytes.Buffer
+	if err := writeBaseline(&buf, results); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	baseline, err := readBaseline(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(baseline) != 1 || baseline[0] != (baselineEntry{File: "model.yaml", Rule: known.Rule, Message: known.Message}) {
+		t.Fatalf("unexpected baseline: %v", baseline)
+	}
+
+	// The baselined issue no longer fails the build, even on another line.
+	moved := known
+	moved.Line = 10
+	filtered := applyBaseline([]lintResult{{name: "model.yaml", issues: []linter.Issue{moved}}}, baseline)
+	if totals := summarizeLint(filtered, "warning"); totals.failed {
+		t.Errorf("expected baselined issue to be suppressed, got %+v", filtered)
+	}
+
+	// A new issue, or a second occurrence of a known one, still fails it.
+	added := linter.Issue{Rule: "orphan-exception", Message: "exception without preceding command", Line: 5, Column: 7, Severity: linter.SeverityWarning}
+	filtered = applyBaseline([]lintResult{{name: "model.yaml", issues: []linter.Issue{known, added, known}}}, baseline)
+	if totals := summarizeLint(filtered, "warning"); !totals.failed || totals.warnings != 2 {
+		t.Errorf("expected 2 new issues to fail the build, got %+v", filtered)
+	}
+
+	// Paths match however they were written on the command line.
+	filtered = applyBaseline([]lintResult{{name: "./model.yaml", issues: []linter.Issue{known}}}, baseline)
+	if totals := summarizeLint(filtered, "warning"); totals.failed {
+		t.Errorf("expected ./model.yaml to match the baseline, got %+v", filtered)
+	}
+	filtered = applyBaseline([]lintResult{{name: "model.yaml", issues: []linter.Issue{known}}}, []baselineEntry{{File: "./model.yaml", Rule: known.Rule, Message: known.Message}})
+	if totals := summarizeLint(filtered, "warning"); totals.failed {
+		t.Errorf("expected a ./model.yaml entry to match model.yaml, got %+v", filtered)
+	}
+}
+
+func TestChangedFiles(t *testing.T) {
+	var gotArgs []string
+	git := func(args ...string) ([]byte, error) {
+		switch args[0] {
+		case "rev-parse":
+			return []byte("true\n"), nil
+		case "ls-files":
+			return []byte("models/new.yaml\nbilling.yml\nnotes.txt\n"), nil
+		}
+		gotArgs = args
+		return []byte("models/orders.yaml\nmodels/.emlang.yaml\nREADME.md\nbilling.yml\nother/x.yaml\n"), nil
+	}
+
+	// Untracked files are included, once
+	files, err := changedFiles(git, "origin/main", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Join(files, ",") != "models/orders.yaml,billing.yml,other/x.yaml,models/new.yaml" {
+		t.Errorf("unexpected files: %v", files)
+	}
+	if gotArgs[len(gotArgs)-1] != "origin/main" {
+		t.Errorf("expected ref as last git argument, got %v", gotArgs)
+	}
+
+	files, err = changedFiles(git, "origin/main", []string{"models/", "billing.yml"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Join(files, ",") != "models/orders.yaml,billing.yml,models/new.yaml" {
+		t.Errorf("unexpected files under paths: %v", files)
+	}
+}
+
+func TestChangedFilesOutsideRepository(t *testing.T) {
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	t.Setenv("GIT_CEILING_DIRECTORIES", filepath.Dir(dir))
+
+	_, err = changedFiles(runGit, "HEAD", nil)
+	if err == nil {
+		t.Fatal("expected error outside a git repository")
+	}
+	if !strings.Contains(err.Error(), "--since needs a git repository") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestNewParseOptions(t *testing.T) {
+	cfg := &config.Config{Parser: config.ParserConfig{AllowInTests: map[string][]string{"when": {"view"}, "given": {"trigger"}}}}
+	opts, err := newParseOptions(cfg, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := opts.AllowInTests["when"]; len(got) != 1 || got[0] != ast.ElementView {
+		t.Errorf("unexpected when types: %v", got)
+	}
+	if got := opts.AllowInTests["given"]; len(got) != 1 || got[0] != ast.ElementTrigger {
+		t.Errorf("unexpected given types: %v", got)
+	}
+
+	cfg.Parser.AllowInTests = map[string][]string{"then-not": {"view"}}
+	if _, err := newParseOptions(cfg, false); err == nil {
+		t.Error("expected error for unknown test section")
+	}
+	cfg.Parser.AllowInTests = map[string][]string{"when": {"query"}}
+	if _, err := newParseOptions(cfg, false); err == nil {
+		t.Error("expected error for unknown element type")
+	}
+}
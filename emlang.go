@@ -0,0 +1,154 @@
+// Package emlang is the public Go API of the Emlang toolchain.
+//
+// It exposes parsing, linting, formatting and diagram generation for
+// programs that embed emlang. The types are aliases of the toolchain's
+// internal types, so values can be passed freely between the functions
+// of this package.
+package emlang
+
+import (
+	"context"
+	"io"
+
+	"github.com/emlang-project/emlang/internal/ast"
+	"github.com/emlang-project/emlang/internal/diagram"
+	"github.com/emlang-project/emlang/internal/formatter"
+	"github.com/emlang-project/emlang/internal/linter"
+	"github.com/emlang-project/emlang/internal/parser"
+)
+
+// Document model.
+type (
+	Document    = ast.Document
+	SubDoc      = ast.SubDoc
+	Slice       = ast.Slice
+	Group       = ast.Group
+	Test        = ast.Test
+	Element     = ast.Element
+	ElementType = ast.ElementType
+	PropEntry   = ast.PropEntry
+)
+
+// Traversal.
+type (
+	Visitor        = ast.Visitor
+	ElementContext = ast.ElementContext
+)
+
+// Element types.
+const (
+	ElementTrigger    = ast.ElementTrigger
+	ElementCommand    = ast.ElementCommand
+	ElementEvent      = ast.ElementEvent
+	ElementException  = ast.ElementException
+	ElementView       = ast.ElementView
+	ElementProjection = ast.ElementProjection
+)
+
+// Linting.
+type (
+	Linter   = linter.Linter
+	Issue    = linter.Issue
+	Severity = linter.Severity
+)
+
+// Issue severities.
+const (
+	SeverityWarning = linter.SeverityWarning
+	SeverityError   = linter.SeverityError
+)
+
+// FormatOptions controls Format.
+type FormatOptions = formatter.Options
+
+// Diagram generation.
+type (
+	Generator      = diagram.Generator
+	DiagramResult  = diagram.Result
+	DiagramWarning = diagram.Warning
+)
+
+// Errors that callers can test for with errors.Is.
+var (
+	// ErrInvalidDocument matches parse errors caused by the input itself,
+	// such as bad YAML or an unknown key, rather than by reading it.
+	ErrInvalidDocument = parser.ErrInvalid
+	// ErrTooManyColumns matches diagram errors for a document wider than
+	// Generator.MaxColumns.
+	ErrTooManyColumns = diagram.ErrTooManyColumns
+	// ErrSliceNotFound matches DiagramSlice errors for an unknown slice.
+	ErrSliceNotFound = diagram.ErrSliceNotFound
+)
+
+// Parse parses an Emlang YAML document, which may contain several
+// YAML documents separated by ---.
+func Parse(r io.Reader) (*Document, error) {
+	return parser.Parse(r)
+}
+
+// ParseContext is like Parse but stops once ctx is done.
+func ParseContext(ctx context.Context, r io.Reader) (*Document, error) {
+	return parser.ParseContext(ctx, r)
+}
+
+// Walk calls v for each sub-document, slice, test and element of doc,
+// in document order.
+func Walk(doc *Document, v Visitor) {
+	ast.Walk(doc, v)
+}
+
+// NewLinter creates a Linter with all rules enabled.
+func NewLinter() *Linter {
+	return linter.New()
+}
+
+// Lint checks doc with all rules enabled and returns the issues found.
+func Lint(doc *Document) []Issue {
+	return linter.New().Lint(doc)
+}
+
+// LintContext is like Lint but stops once ctx is done.
+func LintContext(ctx context.Context, doc *Document) ([]Issue, error) {
+	return linter.New().LintContext(ctx, doc)
+}
+
+// Format returns doc as canonical Emlang YAML.
+func Format(doc *Document, opts FormatOptions) []byte {
+	return formatter.Format(doc, opts)
+}
+
+// Canonical returns doc in a fully normalized form, with long keys and
+// sorted slices and props, for committing and diffing as a golden file.
+func Canonical(doc *Document) []byte {
+	return formatter.Canonical(doc)
+}
+
+// NewGenerator creates a diagram Generator with default settings.
+func NewGenerator() *Generator {
+	return diagram.New()
+}
+
+// Diagram renders doc as an HTML diagram with default settings.
+func Diagram(doc *Document) ([]byte, error) {
+	return diagram.New().Generate(doc)
+}
+
+// DiagramContext is like Diagram but stops once ctx is done.
+func DiagramContext(ctx context.Context, doc *Document) ([]byte, error) {
+	return diagram.New().GenerateContext(ctx, doc)
+}
+
+// DiagramSlice renders only the named slice of doc, with default
+// settings, as a fragment whose ids are unique to that slice. Use
+// Generator.GenerateSlice with OmitCommonCSS to embed several slices
+// on one page.
+func DiagramSlice(doc *Document, name string) ([]byte, error) {
+	return diagram.New().GenerateSlice(doc, name)
+}
+
+// CommonCSS returns the stylesheet shared by all diagrams, with the given
+// CSS variable overrides, to include once in a page that embeds diagrams
+// generated with Generator.OmitCommonCSS.
+func CommonCSS(overrides map[string]string) string {
+	return diagram.CommonCSS(overrides)
+}
@@ -0,0 +1,39 @@
+package emlang_test
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/emlang-project/emlang"
+)
+
+func Example() {
+	doc, err := emlang.Parse(strings.NewReader(`
+slices:
+  Checkout:
+    - c: PlaceOrder
+`))
+	if err != nil {
+		panic(err)
+	}
+
+	for _, issue := range emlang.Lint(doc) {
+		fmt.Println(issue.Rule)
+	}
+
+	fmt.Print(string(emlang.Format(doc, emlang.FormatOptions{KeyStyle: "long"})))
+
+	html, err := emlang.Diagram(doc)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println(strings.Contains(string(html), "PlaceOrder"))
+
+	// Output:
+	// command-without-event
+	// slice-missing-event
+	// slices:
+	//   Checkout:
+	//     - command: PlaceOrder
+	// true
+}
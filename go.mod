@@ -5,3 +5,7 @@ go 1.21
 require gopkg.in/yaml.v3 v3.0.1
 
 require github.com/spf13/pflag v1.0.10
+
+require github.com/pelletier/go-toml/v2 v2.2.2
+
+require github.com/santhosh-tekuri/jsonschema/v5 v5.3.1
@@ -1,9 +1,35 @@
 package ast
 
+import "strings"
+
 // SubDoc represents a single YAML document (separated by ---).
 type SubDoc struct {
-	Slices     map[string]*Slice // slices in this sub-document
-	SliceOrder []string          // insertion order of slice names
+	Title          string            // optional title, e.g. a tab label in tabbed diagrams
+	Slices         map[string]*Slice // slices in this sub-document
+	SliceOrder     []string          // insertion order of slice names
+	Groups         []*Group          // slice groups, in order
+	CSS            []CSSVar          // CSS variable overrides for this document's diagram
+	GivenTemplates []*GivenTemplate  // reusable given sections, in order
+}
+
+// GivenTemplate is a named, reusable list of test preconditions,
+// referenced from a test with given: {use: Name}.
+type GivenTemplate struct {
+	Name     string
+	Elements []*Element
+}
+
+// CSSVar is a CSS custom property override, e.g. --command-color: "#a5d8ff".
+type CSSVar struct {
+	Name  string
+	Value string
+}
+
+// Group is a named section of related slices.
+type Group struct {
+	Name   string
+	Slices []string // slice names, in order
+	Line   int
 }
 
 // Document is the root node of an Emlang YAML document.
@@ -12,7 +38,20 @@ type SubDoc struct {
 type Document struct {
 	Slices    map[string]*Slice // merged (backwards compat)
 	SubDocs   []*SubDoc         // per YAML document
-	RawSource []byte            // raw YAML input
+	RawSource []byte            // raw YAML input, without BOM and with LF line endings
+}
+
+// AllSlicesInOrder returns the slices of every sub-document in source order.
+// Use it instead of ranging over Slices, whose map order is random and
+// which keeps only the last of several same-named slices.
+func (d *Document) AllSlicesInOrder() []*Slice {
+	var slices []*Slice
+	for _, sd := range d.SubDocs {
+		for _, name := range sd.SliceOrder {
+			slices = append(slices, sd.Slices[name])
+		}
+	}
+	return slices
 }
 
 // Slice represents a named slice (sequence of elements).
@@ -22,17 +61,43 @@ type Slice struct {
 	Elements  []*Element       // slice steps
 	Tests     map[string]*Test // attached tests (extended form only)
 	TestOrder []string         // insertion order of test names
+	Extended  bool             // written in extended form (steps:), even without tests
+	Props     []PropEntry      // slice metadata, e.g. a ticket key (extended form only)
+	Line      int              // source line of the slice name (1-based)
+	Column    int              // source column of the slice name (1-based)
+}
+
+// Alt is a branching point in a slice's steps: one of its branches happens.
+// Branch elements also appear, in order, in Slice.Elements, so consumers
+// that do not care about branching can treat the slice as a flat sequence.
+type Alt struct {
+	Branches []*Branch
+	Line     int
+	Column   int
+}
+
+// Branch is a named alternative flow of an Alt.
+type Branch struct {
+	Name     string
+	Elements []*Element
 }
 
 // Test represents a test with Given-When-Then structure.
 type Test struct {
-	Name     string
-	Given    []*Element // pre-conditions (events, views)
-	When     []*Element // commands being tested
-	Then     []*Element // expected results (events, views, exceptions)
-	HasGiven bool       // true if given key was present in source
-	HasWhen  bool       // true if when key was present in source
-	HasThen  bool       // true if then key was present in source
+	Name          string
+	Given         []*Element  // pre-conditions (events, views)
+	When          []*Element  // commands being tested
+	Then          []*Element  // expected results (events, views, exceptions)
+	ThenNot       []*Element  // results that must not occur (same types as Then)
+	HasGiven      bool        // true if given key was present in source
+	HasWhen       bool        // true if when key was present in source
+	HasThen       bool        // true if then key was present in source
+	HasThenNot    bool        // true if then-not key was present in source
+	GivenTemplate string      // name of the given template Given was expanded from, if any
+	Props         []PropEntry // test metadata, e.g. a ticket key
+	Deprecated    bool        // reserved test prop deprecated: true
+	Line          int         // source line of the test name (1-based)
+	Column        int         // source column of the test name (1-based)
 }
 
 // ElementType represents the type of an element.
@@ -44,49 +109,138 @@ const (
 	ElementEvent
 	ElementException
 	ElementView
+	ElementProjection
 )
 
+// ElementTypeInfo describes how an element type is spelled in YAML.
+type ElementTypeInfo struct {
+	Type    ElementType
+	Name    string   // canonical (long) key, e.g. "command"
+	Short   string   // short key, e.g. "c"
+	Aliases []string // every accepted key, including Name and Short
+}
+
+// ElementTypes is the single source of truth for element type names and keys,
+// indexed by ElementType. The parser, formatter and diagram derive from it.
+var ElementTypes = []ElementTypeInfo{
+	{ElementTrigger, "trigger", "t", []string{"t", "trg", "trigger"}},
+	{ElementCommand, "command", "c", []string{"c", "cmd", "command"}},
+	{ElementEvent, "event", "e", []string{"e", "evt", "event"}},
+	{ElementException, "exception", "x", []string{"x", "err", "exception"}},
+	{ElementView, "view", "v", []string{"v", "view"}},
+	{ElementProjection, "projection", "p", []string{"p", "proj", "projection"}},
+}
+
+// String returns the canonical name of the element type.
 func (t ElementType) String() string {
-	switch t {
-	case ElementTrigger:
-		return "trigger"
-	case ElementCommand:
-		return "command"
-	case ElementEvent:
-		return "event"
-	case ElementException:
-		return "exception"
-	case ElementView:
-		return "view"
-	default:
+	if t < 0 || int(t) >= len(ElementTypes) {
 		return "unknown"
 	}
+	return ElementTypes[t].Name
+}
+
+// Short returns the short YAML key of the element type.
+func (t ElementType) Short() string {
+	if t < 0 || int(t) >= len(ElementTypes) {
+		return "unknown"
+	}
+	return ElementTypes[t].Short
+}
+
+// ParseElementType returns the element type with the given canonical name
+// (as returned by String).
+func ParseElementType(name string) (ElementType, bool) {
+	for _, info := range ElementTypes {
+		if info.Name == name {
+			return info.Type, true
+		}
+	}
+	return 0, false
 }
 
 // PropEntry is a key-value pair that preserves insertion order.
 type PropEntry struct {
-	Key   string
-	Value interface{}
+	Key    string
+	Value  interface{}
+	Line   int // source line of the key (1-based)
+	Column int // source column of the key (1-based)
+}
+
+// ReservedProps lists prop keys that carry meaning for the toolchain
+// rather than being free-form data. They stay in Element.Props for
+// round-tripping but are not rendered as regular props.
+var ReservedProps = map[string]bool{
+	"external":   true,
+	"label":      true,
+	"owner":      true,
+	"col":        true,
+	"deprecated": true,
+}
+
+// RefSuffix marks a prop key whose value references another element
+// by name, e.g. source_event_ref: OrderPlaced.
+const RefSuffix = "_ref"
+
+// IsRefProp reports whether a prop key is a cross-reference to an element:
+// its key ends with RefSuffix or is listed in extra.
+func IsRefProp(key string, extra []string) bool {
+	if strings.HasSuffix(key, RefSuffix) {
+		return true
+	}
+	for _, k := range extra {
+		if k == key {
+			return true
+		}
+	}
+	return false
 }
 
 // Element represents an element in a slice or test.
 type Element struct {
-	Type     ElementType
-	Name     string      // element name (may include Swimlane/Name)
-	Swimlane string      // extracted swimlane if present
-	Props    []PropEntry // free-form properties (ordered)
-	Line     int         // source line (1-based)
-	Column   int         // source column (1-based)
+	Type       ElementType
+	Name       string      // element name (may include Swimlane/Name)
+	RawKey     string      // type key as written in the source, e.g. "c" or "command"
+	Swimlane   string      // extracted swimlane if present
+	Props      []PropEntry // free-form properties (ordered)
+	External   bool        // reserved prop external: true (call to an external system)
+	Label      string      // reserved prop label: display text overriding Name in diagrams
+	Owner      string      // reserved prop owner: team owning the element
+	Col        int         // reserved prop col: diagram column within the slice (0 = sequential)
+	Deprecated bool        // reserved prop deprecated: true (kept for reference only)
+	Alt        *Alt        // enclosing alt construct, if the element is in a branch
+	Branch     string      // name of the enclosing alt branch
+	Anchor     string      // YAML anchor defined on this element (&name), if any
+	Alias      string      // YAML alias this element was parsed from (*name), if any
+	Line       int         // source line (1-based)
+	Column     int         // source column (1-based)
+	Offset     int         // source byte offset (0-based) of Line/Column in RawSource
+}
+
+// QualifiedName returns the name of e qualified by its swimlane, if any,
+// e.g. "Billing/Invoiced".
+func (e *Element) QualifiedName() string {
+	if e.Swimlane == "" {
+		return e.Name
+	}
+	return e.Swimlane + "/" + e.Name
+}
+
+// HasName reports whether name designates e, either as its bare name
+// or qualified by its swimlane ("Swimlane/Name").
+func (e *Element) HasName(name string) bool {
+	return name == e.Name || (e.Swimlane != "" && name == e.Swimlane+"/"+e.Name)
 }
 
 // ParseSwimlane extracts swimlane from element name if present.
 // Format: "Swimlane/ElementName" -> swimlane="Swimlane", name="ElementName"
+//
+// Both parts are trimmed and runs of whitespace inside the swimlane are
+// collapsed to one space, so "Customer /X" and "Customer  /Y" share the
+// lane "Customer".
 func (e *Element) ParseSwimlane() {
-	for i, c := range e.Name {
-		if c == '/' {
-			e.Swimlane = e.Name[:i]
-			e.Name = e.Name[i+1:]
-			return
-		}
+	if i := strings.IndexByte(e.Name, '/'); i >= 0 {
+		e.Swimlane = strings.Join(strings.Fields(e.Name[:i]), " ")
+		e.Name = e.Name[i+1:]
 	}
+	e.Name = strings.TrimSpace(e.Name)
 }
@@ -0,0 +1,140 @@
+package ast
+
+import "testing"
+
+func TestElementTypesTableParity(t *testing.T) {
+	seen := map[string]ElementType{}
+	for i, info := range ElementTypes {
+		if int(info.Type) != i {
+			t.Errorf("ElementTypes[%d] has type %d; table must be indexed by type", i, info.Type)
+		}
+		if info.Type.String() != info.Name {
+			t.Errorf("%d: String() = %q, want %q", i, info.Type.String(), info.Name)
+		}
+		if info.Type.Short() != info.Short {
+			t.Errorf("%d: Short() = %q, want %q", i, info.Type.Short(), info.Short)
+		}
+
+		hasName, hasShort := false, false
+		for _, alias := range info.Aliases {
+			if other, ok := seen[alias]; ok {
+				t.Errorf("alias %q used by both %s and %s", alias, other, info.Type)
+			}
+			seen[alias] = info.Type
+			hasName = hasName || alias == info.Name
+			hasShort = hasShort || alias == info.Short
+		}
+		if !hasName || !hasShort {
+			t.Errorf("%s: aliases %v must include name %q and short %q", info.Name, info.Aliases, info.Name, info.Short)
+		}
+
+		if got, ok := ParseElementType(info.Name); !ok || got != info.Type {
+			t.Errorf("ParseElementType(%q) = %v, %v", info.Name, got, ok)
+		}
+	}
+}
+
+func TestElementTypeUnknown(t *testing.T) {
+	if s := ElementType(-1).String(); s != "unknown" {
+		t.Errorf("expected unknown, got %q", s)
+	}
+	if s := ElementType(len(ElementTypes)).String(); s != "unknown" {
+		t.Errorf("expected unknown, got %q", s)
+	}
+	if _, ok := ParseElementType("widget"); ok {
+		t.Error("expected widget to be unknown")
+	}
+}
+
+func TestWalk(t *testing.T) {
+	elem := func(typ ElementType, name string) *Element { return &Element{Type: typ, Name: name} }
+	place := &Slice{
+		Name:     "place",
+		Elements: []*Element{elem(ElementCommand, "Place"), elem(ElementEvent, "Placed")},
+		Tests: map[string]*Test{
+			"places": {
+				Name:    "places",
+				Given:   []*Element{elem(ElementEvent, "CartCreated")},
+				When:    []*Element{elem(ElementCommand, "Place")},
+				Then:    []*Element{elem(ElementEvent, "Placed")},
+				ThenNot: []*Element{elem(ElementException, "Rejected")},
+			},
+			"again": {
+				Name: "again",
+				When: []*Element{elem(ElementCommand, "Place")},
+			},
+		},
+		TestOrder: []string{"places", "again"},
+	}
+	ship := &Slice{Name: "ship", Elements: []*Element{elem(ElementCommand, "Ship")}}
+	view := &Slice{Name: "view", Elements: []*Element{elem(ElementView, "Orders")}}
+	doc := &Document{SubDocs: []*SubDoc{
+		{Slices: map[string]*Slice{"place": place, "ship": ship}, SliceOrder: []string{"place", "ship"}},
+		{Slices: map[string]*Slice{"view": view}, SliceOrder: []string{"view"}},
+	}}
+
+	var visited []string
+	seen := map[interface{}]bool{}
+	once := func(node interface{}) {
+		if seen[node] {
+			t.Errorf("visited %v twice", node)
+		}
+		seen[node] = true
+	}
+	Walk(doc, Visitor{
+		SubDoc: func(sd *SubDoc) {
+			once(sd)
+			visited = append(visited, "doc")
+		},
+		Slice: func(slice *Slice) {
+			once(slice)
+			visited = append(visited, "slice "+slice.Name)
+		},
+		Test: func(slice *Slice, test *Test) {
+			once(test)
+			visited = append(visited, "test "+slice.Name+"/"+test.Name)
+		},
+		Element: func(e *Element, at ElementContext) {
+			once(e)
+			where := at.Slice.Name
+			if at.Test != nil {
+				where += "/" + at.Test.Name + "/" + at.Section
+			}
+			visited = append(visited, e.Type.Short()+":"+e.Name+" in "+where)
+		},
+	})
+
+	expected := []string{
+		"doc",
+		"slice place",
+		"c:Place in place",
+		"e:Placed in place",
+		"test place/places",
+		"e:CartCreated in place/places/given",
+		"c:Place in place/places/when",
+		"e:Placed in place/places/then",
+		"x:Rejected in place/places/then-not",
+		"test place/again",
+		"c:Place in place/again/when",
+		"slice ship",
+		"c:Ship in ship",
+		"doc",
+		"slice view",
+		"v:Orders in view",
+	}
+	if len(visited) != len(expected) {
+		t.Fatalf("visited:\n%v\nwant:\n%v", visited, expected)
+	}
+	for i := range expected {
+		if visited[i] != expected[i] {
+			t.Errorf("visit %d: got %q, want %q", i, visited[i], expected[i])
+		}
+	}
+
+	// A visitor with only some functions set
+	count := 0
+	Walk(doc, Visitor{Slice: func(*Slice) { count++ }})
+	if count != 3 {
+		t.Errorf("expected 3 slices, got %d", count)
+	}
+}
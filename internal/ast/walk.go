@@ -0,0 +1,69 @@
+package ast
+
+// Visitor receives the nodes of a document from Walk. Nil functions are
+// skipped, so a visitor sets only those it needs.
+type Visitor struct {
+	SubDoc  func(sd *SubDoc)
+	Slice   func(slice *Slice)
+	Test    func(slice *Slice, test *Test)
+	Element func(elem *Element, at ElementContext)
+}
+
+// ElementContext locates an element visited by Walk.
+type ElementContext struct {
+	SubDoc  *SubDoc
+	Slice   *Slice
+	Test    *Test  // nil for the slice's steps
+	Section string // "given", "when", "then" or "then-not" for test elements
+}
+
+// Walk visits doc in document order: each sub-document, then each of its
+// slices, then the slice's steps and each of its tests with the test's
+// elements, section by section. Elements of alt branches are visited
+// once, as steps. Given templates are not visited on their own; their
+// elements are visited in the tests that use them.
+func Walk(doc *Document, v Visitor) {
+	for _, sd := range doc.SubDocs {
+		if v.SubDoc != nil {
+			v.SubDoc(sd)
+		}
+		for _, name := range sd.SliceOrder {
+			walkSlice(sd, sd.Slices[name], v)
+		}
+	}
+}
+
+func walkSlice(sd *SubDoc, slice *Slice, v Visitor) {
+	if v.Slice != nil {
+		v.Slice(slice)
+	}
+	if v.Element != nil {
+		for _, elem := range slice.Elements {
+			v.Element(elem, ElementContext{SubDoc: sd, Slice: slice})
+		}
+	}
+
+	for _, name := range slice.TestOrder {
+		test := slice.Tests[name]
+		if v.Test != nil {
+			v.Test(slice, test)
+		}
+		if v.Element == nil {
+			continue
+		}
+		sections := []struct {
+			name  string
+			elems []*Element
+		}{
+			{"given", test.Given},
+			{"when", test.When},
+			{"then", test.Then},
+			{"then-not", test.ThenNot},
+		}
+		for _, s := range sections {
+			for _, elem := range s.elems {
+				v.Element(elem, ElementContext{SubDoc: sd, Slice: slice, Test: test, Section: s.name})
+			}
+		}
+	}
+}
@@ -0,0 +1,168 @@
+// Package catalog lists the commands and events of Emlang documents,
+// with the slices that produce and consume them, as a governance artifact.
+package catalog
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/emlang-project/emlang/internal/ast"
+)
+
+// Source is a parsed file to include in the catalog.
+type Source struct {
+	File string
+	Doc  *ast.Document
+}
+
+// SliceRef identifies a slice in a source file.
+type SliceRef struct {
+	File  string `json:"file"`
+	Slice string `json:"slice"`
+}
+
+func (r SliceRef) String() string {
+	return r.File + ":" + r.Slice
+}
+
+// Entry is one distinct command or event.
+//
+// A slice produces the commands in its steps and the events that follow
+// a command there. It consumes the events that precede its first command,
+// such as those feeding a view, and those in the given clause of its tests.
+type Entry struct {
+	Type       string     `json:"type"`
+	Name       string     `json:"name"` // qualified by the swimlane, if any
+	ProducedBy []SliceRef `json:"produced_by"`
+	ConsumedBy []SliceRef `json:"consumed_by"`
+	Props      []string   `json:"props"` // prop keys seen on any occurrence, sorted
+}
+
+type key struct {
+	typ  ast.ElementType
+	name string
+}
+
+// Build returns the catalog of the given sources, sorted by type
+// (commands first) and name.
+func Build(sources []Source) []Entry {
+	entries := map[key]*Entry{}
+	props := map[key]map[string]bool{}
+
+	entry := func(elem *ast.Element) *Entry {
+		k := key{elem.Type, elem.QualifiedName()}
+		e, ok := entries[k]
+		if !ok {
+			e = &Entry{Type: elem.Type.String(), Name: k.name, ProducedBy: []SliceRef{}, ConsumedBy: []SliceRef{}}
+			entries[k] = e
+			props[k] = map[string]bool{}
+		}
+		for _, p := range elem.Props {
+			props[k][p.Key] = true
+		}
+		return e
+	}
+
+	for _, src := range sources {
+		for _, sd := range src.Doc.SubDocs {
+			for _, name := range sd.SliceOrder {
+				slice := sd.Slices[name]
+				ref := SliceRef{File: src.File, Slice: name}
+
+				seenCommand := false
+				for _, elem := range slice.Elements {
+					switch elem.Type {
+					case ast.ElementCommand:
+						seenCommand = true
+						addRef(&entry(elem).ProducedBy, ref)
+					case ast.ElementEvent:
+						if seenCommand {
+							addRef(&entry(elem).ProducedBy, ref)
+						} else {
+							addRef(&entry(elem).ConsumedBy, ref)
+						}
+					}
+				}
+
+				for _, testName := range slice.TestOrder {
+					for _, elem := range slice.Tests[testName].Given {
+						if elem.Type == ast.ElementEvent {
+							addRef(&entry(elem).ConsumedBy, ref)
+						}
+					}
+				}
+			}
+		}
+	}
+
+	result := make([]Entry, 0, len(entries))
+	for k, e := range entries {
+		e.Props = []string{}
+		for p := range props[k] {
+			e.Props = append(e.Props, p)
+		}
+		sort.Strings(e.Props)
+		result = append(result, *e)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Type != result[j].Type {
+			return result[i].Type < result[j].Type
+		}
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+// addRef appends ref to refs unless it is already present.
+func addRef(refs *[]SliceRef, ref SliceRef) {
+	for _, r := range *refs {
+		if r == ref {
+			return
+		}
+	}
+	*refs = append(*refs, ref)
+}
+
+// WriteCSV writes the catalog as CSV with a header row. Slices and props
+// are joined with ";" within their cells.
+func WriteCSV(w io.Writer, entries []Entry) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"type", "name", "produced_by", "consumed_by", "props"})
+	for _, e := range entries {
+		cw.Write([]string{e.Type, e.Name, joinRefs(e.ProducedBy), joinRefs(e.ConsumedBy), strings.Join(e.Props, ";")})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteJSON writes the catalog as an indented JSON array.
+func WriteJSON(w io.Writer, entries []Entry) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(entries)
+}
+
+func joinRefs(refs []SliceRef) string {
+	parts := make([]string, len(refs))
+	for i, r := range refs {
+		parts[i] = r.String()
+	}
+	return strings.Join(parts, ";")
+}
+
+// errWriter formats to w until a write fails, keeping the first error,
+// so that a report can be written without checking every line.
+type errWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (ew *errWriter) printf(format string, args ...interface{}) {
+	if ew.err == nil {
+		_, ew.err = fmt.Fprintf(ew.w, format, args...)
+	}
+}
@@ -0,0 +1,234 @@
+package catalog
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/emlang-project/emlang/internal/parser"
+)
+
+const sharedEvent = `
+slices:
+  place-order:
+    steps:
+      - c: PlaceOrder
+        props:
+          cart_id: string
+      - e: OrderPlaced
+        props:
+          order_id: string
+    tests:
+      places:
+        given:
+          - e: CartCreated
+        when:
+          - c: PlaceOrder
+        then:
+          - e: OrderPlaced
+  order-summary:
+    - e: OrderPlaced
+      props:
+        total: number
+    - v: OrderSummary
+`
+
+func TestBuildSharedEvent(t *testing.T) {
+	doc, err := parser.Parse(strings.NewReader(sharedEvent))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteCSV(&buf, Build([]Source{{File: "orders.yaml", Doc: doc}})); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := "type,name,produced_by,consumed_by,props\n" +
+		"command,PlaceOrder,orders.yaml:place-order,,cart_id\n" +
+		"event,CartCreated,,orders.yaml:place-order,\n" +
+		"event,OrderPlaced,orders.yaml:place-order,orders.yaml:order-summary,order_id;total\n"
+	if buf.String() != expected {
+		t.Errorf("catalog:\ngot:\n%s\nwant:\n%s", buf.String(), expected)
+	}
+}
+
+func TestBuildAcrossFiles(t *testing.T) {
+	a, err := parser.Parse(strings.NewReader("slices:\n  pay:\n    - c: Pay\n    - e: Paid\n"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	b, err := parser.Parse(strings.NewReader("slices:\n  ship:\n    - e: Paid\n    - c: Ship\n    - e: Shipped\n"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	entries := Build([]Source{{File: "a.yaml", Doc: a}, {File: "b.yaml", Doc: b}})
+
+	var paid *Entry
+	for i := range entries {
+		if entries[i].Name == "Paid" {
+			paid = &entries[i]
+		}
+	}
+	if paid == nil {
+		t.Fatalf("expected Paid in catalog, got %v", entries)
+	}
+	if joinRefs(paid.ProducedBy) != "a.yaml:pay" || joinRefs(paid.ConsumedBy) != "b.yaml:ship" {
+		t.Errorf("unexpected refs for Paid: produced %v, consumed %v", paid.ProducedBy, paid.ConsumedBy)
+	}
+	if len(entries) != 4 {
+		t.Errorf("expected 4 entries, got %d", len(entries))
+	}
+}
+
+func TestBuildSwimlanes(t *testing.T) {
+	doc, err := parser.Parse(strings.NewReader(`
+slices:
+  bill:
+    - c: Bill
+    - e: Billing/Invoiced
+  ship:
+    - c: Ship
+    - e: Shipping/Invoiced
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteCSV(&buf, Build([]Source{{File: "a.yaml", Doc: doc}})); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := "type,name,produced_by,consumed_by,props\n" +
+		"command,Bill,a.yaml:bill,,\n" +
+		"command,Ship,a.yaml:ship,,\n" +
+		"event,Billing/Invoiced,a.yaml:bill,,\n" +
+		"event,Shipping/Invoiced,a.yaml:ship,,\n"
+	if buf.String() != expected {
+		t.Errorf("catalog:\ngot:\n%s\nwant:\n%s", buf.String(), expected)
+	}
+}
+
+func TestOwners(t *testing.T) {
+	a, err := parser.Parse(strings.NewReader(`
+slices:
+  pay:
+    - c: Pay
+      props:
+        owner: payments
+    - e: Paid
+      props:
+        owner: payments
+  ship:
+    - e: Paid
+    - c: Ship
+      props:
+        owner: logistics
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	b, err := parser.Parse(strings.NewReader("slices:\n  refund:\n    - c: Refund\n      props:\n        owner: payments\n"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteOwners(&buf, Owners([]Source{{File: "a.yaml", Doc: a}, {File: "b.yaml", Doc: b}})); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := "logistics\n" +
+		"  a.yaml:ship\n" +
+		"    command Ship\n" +
+		"payments\n" +
+		"  a.yaml:pay\n" +
+		"    command Pay\n" +
+		"    event Paid\n" +
+		"  b.yaml:refund\n" +
+		"    command Refund\n"
+	if buf.String() != expected {
+		t.Errorf("owners:\ngot:\n%s\nwant:\n%s", buf.String(), expected)
+	}
+
+	if err := WriteOwners(&failingWriter{}, Owners([]Source{{File: "a.yaml", Doc: a}})); err == nil {
+		t.Error("expected a failed write to be reported")
+	}
+}
+
+func TestCoverage(t *testing.T) {
+	doc, err := parser.Parse(strings.NewReader(`
+slices:
+  register:
+    steps:
+      - c: Register
+      - e: Registered
+    tests:
+      registers:
+        when:
+          - c: Register
+        then:
+          - e: Registered
+      rejects:
+        when:
+          - c: Register
+        then:
+          - x: Rejected
+  login:
+    steps:
+      - c: Login
+      - e: LoggedIn
+---
+slices:
+  logout:
+    - c: Logout
+    - e: LoggedOut
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	coverage := Coverage([]Source{{File: "a.yaml", Doc: doc}})
+	var buf bytes.Buffer
+	if err := WriteCoverage(&buf, coverage); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := "SLICE            DOC  TESTS\n" +
+		"a.yaml:register    1      2  ##\n" +
+		"a.yaml:login       1      0  untested\n" +
+		"a.yaml:logout      2      0  untested\n"
+	if buf.String() != expected {
+		t.Errorf("coverage:\ngot:\n%s\nwant:\n%s", buf.String(), expected)
+	}
+
+	if err := WriteCoverage(&failingWriter{}, coverage); err == nil {
+		t.Error("expected a failed write to be reported")
+	}
+
+	if below := BelowMinTests(coverage, 0); len(below) != 0 {
+		t.Errorf("expected no slice below 0 tests, got %v", below)
+	}
+	below := BelowMinTests(coverage, 2)
+	if len(below) != 2 || below[0].Slice != "login" || below[1].Slice != "logout" {
+		t.Errorf("expected login and logout below 2 tests, got %v", below)
+	}
+	if below := BelowMinTests(coverage, 3); len(below) != 3 {
+		t.Errorf("expected all slices below 3 tests, got %v", below)
+	}
+}
+
+// failingWriter accepts its first write and fails the following ones,
+// like a pipe closed by its reader.
+type failingWriter struct{ writes int }
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	w.writes++
+	if w.writes > 1 {
+		return 0, errors.New("closed pipe")
+	}
+	return len(p), nil
+}
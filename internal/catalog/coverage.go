@@ -0,0 +1,76 @@
+package catalog
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+)
+
+// SliceCoverage is the number of tests of a slice.
+type SliceCoverage struct {
+	SliceRef
+	Document int `json:"document"` // 1-based index of the slice's document in File
+	Tests    int `json:"tests"`
+}
+
+// Coverage counts the tests of each slice in sources, in source order.
+// Slices in direct form have no tests.
+func Coverage(sources []Source) []SliceCoverage {
+	var result []SliceCoverage
+	for _, src := range sources {
+		for i, sd := range src.Doc.SubDocs {
+			for _, name := range sd.SliceOrder {
+				result = append(result, SliceCoverage{
+					SliceRef: SliceRef{File: src.File, Slice: name},
+					Document: i + 1,
+					Tests:    len(sd.Slices[name].Tests),
+				})
+			}
+		}
+	}
+	return result
+}
+
+// BelowMinTests returns the slices of coverage with fewer than min tests.
+func BelowMinTests(coverage []SliceCoverage, min int) []SliceCoverage {
+	var below []SliceCoverage
+	for _, c := range coverage {
+		if c.Tests < min {
+			below = append(below, c)
+		}
+	}
+	return below
+}
+
+// WriteCoverage writes the coverage report as a table: each slice, its
+// document, its number of tests and a bar of one # per test, with slices
+// without tests flagged as untested.
+func WriteCoverage(w io.Writer, coverage []SliceCoverage) error {
+	width := len("SLICE")
+	for _, c := range coverage {
+		if n := len(c.SliceRef.String()); n > width {
+			width = n
+		}
+	}
+
+	ew := &errWriter{w: w}
+	ew.printf("%-*s  DOC  TESTS\n", width, "SLICE")
+	for _, c := range coverage {
+		bar := strings.Repeat("#", c.Tests)
+		if c.Tests == 0 {
+			bar = "untested"
+		}
+		ew.printf("%-*s  %3d  %5d  %s\n", width, c.SliceRef, c.Document, c.Tests, bar)
+	}
+	return ew.err
+}
+
+// WriteCoverageJSON writes the coverage report as an indented JSON array.
+func WriteCoverageJSON(w io.Writer, coverage []SliceCoverage) error {
+	if coverage == nil {
+		coverage = []SliceCoverage{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(coverage)
+}
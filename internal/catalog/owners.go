@@ -0,0 +1,102 @@
+package catalog
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+
+	"github.com/emlang-project/emlang/internal/ast"
+)
+
+// Owner lists the slices in which a team owns elements, through the
+// reserved owner prop.
+type Owner struct {
+	Name   string       `json:"name"`
+	Slices []OwnedSlice `json:"slices"`
+}
+
+// OwnedSlice is a slice with the elements of its steps that an owner owns.
+type OwnedSlice struct {
+	SliceRef
+	Elements []OwnedElement `json:"elements"`
+}
+
+// OwnedElement is an element with an owner prop.
+type OwnedElement struct {
+	Type string `json:"type"`
+	Name string `json:"name"`
+}
+
+// Owners groups the owned elements of the slice steps in sources by
+// owner, then by slice. Owners are sorted by name; slices and elements
+// keep their source order, and an element repeated in a slice is listed
+// once. Elements without an owner are left out.
+func Owners(sources []Source) []Owner {
+	owners := map[string]*Owner{}
+
+	for _, src := range sources {
+		for _, sd := range src.Doc.SubDocs {
+			for _, name := range sd.SliceOrder {
+				ref := SliceRef{File: src.File, Slice: name}
+				for _, elem := range sd.Slices[name].Elements {
+					if elem.Owner == "" {
+						continue
+					}
+					o, ok := owners[elem.Owner]
+					if !ok {
+						o = &Owner{Name: elem.Owner}
+						owners[elem.Owner] = o
+					}
+					addOwned(o, ref, elem)
+				}
+			}
+		}
+	}
+
+	result := make([]Owner, 0, len(owners))
+	for _, o := range owners {
+		result = append(result, *o)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+// addOwned records elem, from the slice ref, as owned by o.
+func addOwned(o *Owner, ref SliceRef, elem *ast.Element) {
+	if n := len(o.Slices); n == 0 || o.Slices[n-1].SliceRef != ref {
+		o.Slices = append(o.Slices, OwnedSlice{SliceRef: ref})
+	}
+	s := &o.Slices[len(o.Slices)-1]
+	owned := OwnedElement{Type: elem.Type.String(), Name: elem.Name}
+	for _, e := range s.Elements {
+		if e == owned {
+			return
+		}
+	}
+	s.Elements = append(s.Elements, owned)
+}
+
+// WriteOwners writes the owners report as indented text: each owner,
+// its slices, and their owned elements.
+func WriteOwners(w io.Writer, owners []Owner) error {
+	ew := &errWriter{w: w}
+	for _, o := range owners {
+		ew.printf("%s\n", o.Name)
+		for _, s := range o.Slices {
+			ew.printf("  %s\n", s.SliceRef)
+			for _, e := range s.Elements {
+				ew.printf("    %s %s\n", e.Type, e.Name)
+			}
+		}
+	}
+	return ew.err
+}
+
+// WriteOwnersJSON writes the owners report as an indented JSON array.
+func WriteOwnersJSON(w io.Writer, owners []Owner) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(owners)
+}
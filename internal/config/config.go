@@ -1,69 +1,275 @@
 package config
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
 
 	"gopkg.in/yaml.v3"
 )
 
+// defaultFiles lists the config file names looked up, in order,
+// when no explicit path is given.
+var defaultFiles = []string{".emlang.yaml", ".emlang.json", ".emlang.toml"}
+
+// ErrNotFound matches, with errors.Is, the error Load returns when an
+// explicit config path does not exist. A missing default file is not an
+// error; Config.File is then empty.
+var ErrNotFound = errors.New("config file not found")
+
+// ErrUnknownProfile matches, with errors.Is, the error LoadProfile returns
+// for a profile the config file does not define.
+var ErrUnknownProfile = errors.New("unknown profile")
+
 // Config represents the .emlang.yaml configuration file.
 type Config struct {
-	Lint    LintConfig    `yaml:"lint"`
-	Diagram DiagramConfig `yaml:"diagram"`
-	Fmt     FmtConfig     `yaml:"fmt"`
+	Lint     LintConfig        `yaml:"lint"`
+	Diagram  DiagramConfig     `yaml:"diagram"`
+	Fmt      FmtConfig         `yaml:"fmt"`
+	Parser   ParserConfig      `yaml:"parser"`
+	RefProps []string          `yaml:"ref_props"` // prop keys referencing elements, besides *_ref
+	Profiles map[string]Config `yaml:"profiles,omitempty"`
+	File     string            `yaml:"-"` // path of the loaded file, empty when defaults apply
 }
 
 // FmtConfig holds formatter configuration.
 type FmtConfig struct {
-	Keys string `yaml:"keys"` // "short" or "long" (default "long")
+	Keys string `yaml:"keys"` // "short", "long" or "keep" (default "long")
+}
+
+// ParserConfig relaxes the grammar accepted by the parser.
+type ParserConfig struct {
+	AllowInTests map[string][]string `yaml:"allow_in_tests"` // test section -> extra element types
+	Strict       bool                `yaml:"strict"`         // reject tolerated constructs, see parser.Options.Strict
 }
 
 // LintConfig holds linter configuration.
 type LintConfig struct {
-	Ignore []string `yaml:"ignore"`
+	Ignore     []string            `yaml:"ignore"`
+	Enable     []string            `yaml:"enable"`      // opt-in rules to report
+	Severity   map[string]string   `yaml:"severity"`    // rule -> "error" or "warning"
+	PropSchema map[string][]string `yaml:"prop_schema"` // element type -> required prop keys
 }
 
 // DiagramConfig holds diagram generation configuration.
 type DiagramConfig struct {
-	CSS   map[string]string `yaml:"css"`
-	Serve ServeConfig       `yaml:"serve"`
+	CSS           map[string]string `yaml:"css"`
+	Colors        map[string]string `yaml:"colors"` // element type name to color, under css
+	Serve         ServeConfig       `yaml:"serve"`
+	Theme         string            `yaml:"theme"`           // built-in theme name, see diagram.Themes
+	MaxColumns    int               `yaml:"max_columns"`     // 0 uses the built-in default
+	MaxLabelChars int               `yaml:"max_label_chars"` // 0 disables truncation
+	Layout        string            `yaml:"layout"`          // "stacked" or "tabbed", see diagram.Layouts
 }
 
 // ServeConfig holds live-reload server configuration.
 type ServeConfig struct {
-	Address string `yaml:"address"`
-	Port    int    `yaml:"port"`
+	Address  string `yaml:"address"`
+	Port     int    `yaml:"port"`
+	Template string `yaml:"template"` // html/template file for served and built pages
 }
 
-// Load resolves and loads the config file with priority: flagPath > EMLANG_CONFIG env > .emlang.yaml in cwd.
+// Load resolves and loads the config file with priority: flagPath > EMLANG_CONFIG env > default file.
+// The default file is the first of .emlang.yaml, .emlang.json and .emlang.toml (its [emlang] table)
+// found in the current directory or the nearest parent directory that has one.
+// The format of an explicit path is chosen by its extension, defaulting to YAML.
 // Returns a zero-value config if no file is found at the default path.
 // Returns an error if an explicit path (flag or env) doesn't exist or contains invalid YAML.
+// Unknown keys are rejected so that typos don't silently disable configuration.
+// Environment variable references in values are expanded, see expandEnv.
 func Load(flagPath string) (*Config, error) {
-	path := flagPath
-	explicit := true
-
-	if path == "" {
-		path = os.Getenv("EMLANG_CONFIG")
-	}
+	return LoadProfile(flagPath, "")
+}
 
-	if path == "" {
-		path = ".emlang.yaml"
-		explicit = false
-	}
+// LoadProfile loads the config file like Load, then applies the named profile
+// from the profiles: section on top of the base config.
+//
+// Profile values are deep-merged over the base: mappings merge key by key,
+// while lists and scalars replace the base value entirely.
+// An empty profile name loads the base config unchanged.
+func LoadProfile(flagPath string, profile string) (*Config, error) {
+	path, explicit := resolve(flagPath)
 
 	data, err := os.ReadFile(path)
 	if err != nil {
 		if os.IsNotExist(err) && !explicit {
+			if profile != "" {
+				return nil, fmt.Errorf("%w %q: %w", ErrUnknownProfile, profile, ErrNotFound)
+			}
 			return &Config{}, nil
 		}
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("%w: %w", ErrNotFound, err)
+		}
 		return nil, fmt.Errorf("reading config: %w", err)
 	}
 
-	var cfg Config
-	if err := yaml.Unmarshal(data, &cfg); err != nil {
+	data, err = formatFor(path).toYAML(data)
+	if err != nil {
+		return nil, fmt.Errorf("parsing config %s: %w", path, err)
+	}
+
+	data, err = expandEnv(data, os.LookupEnv)
+	if err != nil {
+		return nil, fmt.Errorf("parsing config %s: %w", path, err)
+	}
+
+	cfg, err := decode(data)
+	if err != nil {
 		return nil, fmt.Errorf("parsing config %s: %w", path, err)
 	}
 
+	if profile == "" {
+		cfg.File = path
+		return cfg, nil
+	}
+
+	p, ok := cfg.Profiles[profile]
+	if !ok {
+		return nil, fmt.Errorf("%w %q in %s", ErrUnknownProfile, profile, path)
+	}
+	if len(p.Profiles) > 0 {
+		return nil, fmt.Errorf("profile %q in %s: profiles cannot be nested", profile, path)
+	}
+
+	merged, err := applyProfile(data, profile)
+	if err != nil {
+		return nil, fmt.Errorf("applying profile %q from %s: %w", profile, path, err)
+	}
+	merged.File = path
+	return merged, nil
+}
+
+// Dump returns cfg as YAML without its profiles section, showing the
+// effective configuration once the selected profile has been applied.
+func Dump(cfg *Config) ([]byte, error) {
+	c := *cfg
+	c.Profiles = nil
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(&c); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Path returns the config file Load reads for flagPath, following the
+// same priority, whether or not the file exists.
+func Path(flagPath string) string {
+	path, _ := resolve(flagPath)
+	return path
+}
+
+// resolve returns the config file path for flagPath, and whether it was
+// given explicitly (by flag or env) rather than found by default.
+func resolve(flagPath string) (path string, explicit bool) {
+	if flagPath != "" {
+		return flagPath, true
+	}
+	if path := os.Getenv("EMLANG_CONFIG"); path != "" {
+		return path, true
+	}
+	return findDefault(), false
+}
+
+// findDefault returns the first default config file found in the current
+// directory or, failing that, in its nearest parent directory containing one,
+// up to the filesystem root. Returns the primary default name if none exists.
+func findDefault() string {
+	dir, err := os.Getwd()
+	if err != nil {
+		return defaultFiles[0]
+	}
+	for {
+		for _, name := range defaultFiles {
+			path := filepath.Join(dir, name)
+			if _, err := os.Stat(path); err == nil {
+				return path
+			}
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return defaultFiles[0]
+		}
+		dir = parent
+	}
+}
+
+// decode strictly decodes YAML data into a Config.
+// Empty input yields a zero-value config.
+func decode(data []byte) (*Config, error) {
+	var cfg Config
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&cfg); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
 	return &cfg, nil
 }
+
+// applyProfile merges the named profile node over the base document node
+// and decodes the result.
+func applyProfile(data []byte, profile string) (*Config, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, err
+	}
+	base := root.Content[0]
+	over := mappingValue(mappingValue(base, "profiles"), profile)
+
+	out, err := yaml.Marshal(mergeNodes(base, over))
+	if err != nil {
+		return nil, err
+	}
+	return decode(out)
+}
+
+// mappingValue returns the value node for key in a mapping node, or nil.
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// mergeNodes deep-merges over into base. Mappings merge key by key;
+// any other node kind in over replaces the base node.
+func mergeNodes(base, over *yaml.Node) *yaml.Node {
+	if over == nil {
+		return base
+	}
+	if base == nil || base.Kind != yaml.MappingNode || over.Kind != yaml.MappingNode {
+		return over
+	}
+
+	merged := *base
+	merged.Content = append([]*yaml.Node(nil), base.Content...)
+	for i := 0; i < len(over.Content); i += 2 {
+		key, val := over.Content[i], over.Content[i+1]
+		found := false
+		for j := 0; j < len(merged.Content); j += 2 {
+			if merged.Content[j].Value == key.Value {
+				merged.Content[j+1] = mergeNodes(merged.Content[j+1], val)
+				found = true
+				break
+			}
+		}
+		if !found {
+			merged.Content = append(merged.Content, key, val)
+		}
+	}
+	return &merged
+}
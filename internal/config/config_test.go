@@ -1,8 +1,11 @@
 package config
 
 import (
+	"errors"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -77,7 +80,9 @@ func TestLoadNoFileReturnsDefaults(t *testing.T) {
 		t.Fatalf("unexpected error: %v", err)
 	}
 
-	_ = cfg // should load without error
+	if cfg.File != "" {
+		t.Errorf("expected no config file for defaults, got %q", cfg.File)
+	}
 }
 
 func TestLoadMissingExplicitPathErrors(t *testing.T) {
@@ -85,6 +90,9 @@ func TestLoadMissingExplicitPathErrors(t *testing.T) {
 	if err == nil {
 		t.Fatal("expected error for missing explicit path")
 	}
+	if !errors.Is(err, ErrNotFound) || !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected ErrNotFound wrapping fs.ErrNotExist, got %v", err)
+	}
 }
 
 func TestLoadEnvVar(t *testing.T) {
@@ -162,3 +170,417 @@ func TestLoadInvalidYAMLErrors(t *testing.T) {
 		t.Fatal("expected error for invalid YAML")
 	}
 }
+
+func TestLoadUnknownKeyErrors(t *testing.T) {
+	dir := t.TempDir()
+	cfgFile := filepath.Join(dir, ".emlang.yaml")
+	if err := os.WriteFile(cfgFile, []byte(`linnt:
+  ignore:
+    - "orphan-exception"
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := Load(cfgFile)
+	if err == nil {
+		t.Fatal("expected error for unknown key")
+	}
+	if !strings.Contains(err.Error(), "linnt") {
+		t.Errorf("expected error to name the unknown key, got %v", err)
+	}
+}
+
+func TestLoadUnknownNestedKeyErrors(t *testing.T) {
+	dir := t.TempDir()
+	cfgFile := filepath.Join(dir, ".emlang.yaml")
+	if err := os.WriteFile(cfgFile, []byte(`diagram:
+  serve:
+    prot: 8080
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := Load(cfgFile)
+	if err == nil {
+		t.Fatal("expected error for unknown nested key")
+	}
+	if !strings.Contains(err.Error(), "prot") {
+		t.Errorf("expected error to name the unknown key, got %v", err)
+	}
+}
+
+func TestLoadEmptyFile(t *testing.T) {
+	dir := t.TempDir()
+	cfgFile := filepath.Join(dir, ".emlang.yaml")
+	if err := os.WriteFile(cfgFile, []byte("# only comments\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Load(cfgFile); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+const profileConfig = `lint:
+  ignore:
+    - "orphan-exception"
+    - "slice-missing-event"
+  severity:
+    command-without-event: warning
+diagram:
+  css:
+    --command-color: "#ddeeff"
+    --event-color: "#ffeedd"
+  serve:
+    port: 9000
+profiles:
+  ci:
+    lint:
+      ignore:
+        - "orphan-exception"
+      severity:
+        command-without-event: error
+    diagram:
+      css:
+        --event-color: "#000000"
+`
+
+func TestLoadProfileMergesOverBase(t *testing.T) {
+	dir := t.TempDir()
+	cfgFile := filepath.Join(dir, ".emlang.yaml")
+	if err := os.WriteFile(cfgFile, []byte(profileConfig), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := LoadProfile(cfgFile, "ci")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Lists replace: slice-missing-event is no longer ignored in ci.
+	if len(cfg.Lint.Ignore) != 1 || cfg.Lint.Ignore[0] != "orphan-exception" {
+		t.Errorf("expected ignore list replaced by profile, got %v", cfg.Lint.Ignore)
+	}
+	// The ci profile promotes command-without-event to an error.
+	if cfg.Lint.Severity["command-without-event"] != "error" {
+		t.Errorf("expected profile severity error, got %q", cfg.Lint.Severity["command-without-event"])
+	}
+	// Maps merge: profile value wins, base-only keys remain.
+	if cfg.Diagram.CSS["--event-color"] != "#000000" {
+		t.Errorf("expected profile --event-color, got %q", cfg.Diagram.CSS["--event-color"])
+	}
+	if cfg.Diagram.CSS["--command-color"] != "#ddeeff" {
+		t.Errorf("expected base --command-color, got %q", cfg.Diagram.CSS["--command-color"])
+	}
+	if cfg.Diagram.Serve.Port != 9000 {
+		t.Errorf("expected base port 9000, got %d", cfg.Diagram.Serve.Port)
+	}
+}
+
+func TestDumpReflectsProfile(t *testing.T) {
+	dir := t.TempDir()
+	cfgFile := filepath.Join(dir, ".emlang.yaml")
+	if err := os.WriteFile(cfgFile, []byte(profileConfig), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := LoadProfile(cfgFile, "ci")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	out, err := Dump(cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	dump := string(out)
+
+	if !strings.Contains(dump, "--event-color: '#000000'") {
+		t.Errorf("expected the profile's --event-color, got:\n%s", dump)
+	}
+	if strings.Contains(dump, "slice-missing-event") {
+		t.Errorf("expected the profile's ignore list to replace the base one, got:\n%s", dump)
+	}
+	if strings.Contains(dump, "profiles") {
+		t.Errorf("expected no profiles section, got:\n%s", dump)
+	}
+
+	// The dump is itself a valid config
+	if _, err := decode(out); err != nil {
+		t.Errorf("dump does not decode as a config: %v", err)
+	}
+}
+
+func TestLoadWithoutProfileUsesBase(t *testing.T) {
+	dir := t.TempDir()
+	cfgFile := filepath.Join(dir, ".emlang.yaml")
+	if err := os.WriteFile(cfgFile, []byte(profileConfig), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := Load(cfgFile)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(cfg.Lint.Ignore) != 2 {
+		t.Errorf("expected base ignore list, got %v", cfg.Lint.Ignore)
+	}
+	if cfg.Diagram.CSS["--event-color"] != "#ffeedd" {
+		t.Errorf("expected base --event-color, got %q", cfg.Diagram.CSS["--event-color"])
+	}
+}
+
+func TestLoadUnknownProfileErrors(t *testing.T) {
+	dir := t.TempDir()
+	cfgFile := filepath.Join(dir, ".emlang.yaml")
+	if err := os.WriteFile(cfgFile, []byte(profileConfig), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := LoadProfile(cfgFile, "staging")
+	if err == nil {
+		t.Fatal("expected error for unknown profile")
+	}
+	if !strings.Contains(err.Error(), "staging") {
+		t.Errorf("expected error to name the profile, got %v", err)
+	}
+	if !errors.Is(err, ErrUnknownProfile) {
+		t.Errorf("expected ErrUnknownProfile, got %v", err)
+	}
+
+	cfg, err := LoadProfile(cfgFile, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.File != cfgFile {
+		t.Errorf("expected File %q, got %q", cfgFile, cfg.File)
+	}
+}
+
+func assertEquivalentConfig(t *testing.T, cfg *Config) {
+	t.Helper()
+	if len(cfg.Lint.Ignore) != 1 || cfg.Lint.Ignore[0] != "orphan-exception" {
+		t.Errorf("expected ignore [orphan-exception], got %v", cfg.Lint.Ignore)
+	}
+	if cfg.Diagram.CSS["--command-color"] != "#ddeeff" {
+		t.Errorf("unexpected --command-color: %q", cfg.Diagram.CSS["--command-color"])
+	}
+	if cfg.Diagram.Serve.Port != 9000 {
+		t.Errorf("expected port 9000, got %d", cfg.Diagram.Serve.Port)
+	}
+	if cfg.Fmt.Keys != "short" {
+		t.Errorf("expected keys short, got %q", cfg.Fmt.Keys)
+	}
+}
+
+var equivalentConfigs = map[string]string{
+	".emlang.yaml": `lint:
+  ignore:
+    - orphan-exception
+diagram:
+  css:
+    --command-color: "#ddeeff"
+  serve:
+    port: 9000
+fmt:
+  keys: short
+`,
+	".emlang.json": `{
+  "lint": {"ignore": ["orphan-exception"]},
+  "diagram": {"css": {"--command-color": "#ddeeff"}, "serve": {"port": 9000}},
+  "fmt": {"keys": "short"}
+}
+`,
+	".emlang.toml": `[tool.other]
+unrelated = true
+
+[emlang.lint]
+ignore = ["orphan-exception"]
+
+[emlang.diagram.css]
+"--command-color" = "#ddeeff"
+
+[emlang.diagram.serve]
+port = 9000
+
+[emlang.fmt]
+keys = "short"
+`,
+}
+
+func TestLoadFormatsByExtension(t *testing.T) {
+	for name, content := range equivalentConfigs {
+		t.Run(name, func(t *testing.T) {
+			cfgFile := filepath.Join(t.TempDir(), name)
+			if err := os.WriteFile(cfgFile, []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			cfg, err := Load(cfgFile)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			assertEquivalentConfig(t, cfg)
+		})
+	}
+}
+
+func TestLoadFormatsFromCwd(t *testing.T) {
+	for name, content := range equivalentConfigs {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			origDir, _ := os.Getwd()
+			os.Chdir(dir)
+			defer os.Chdir(origDir)
+			t.Setenv("EMLANG_CONFIG", "")
+
+			cfg, err := Load("")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			assertEquivalentConfig(t, cfg)
+		})
+	}
+}
+
+func TestLoadJSONUnknownKeyErrors(t *testing.T) {
+	cfgFile := filepath.Join(t.TempDir(), ".emlang.json")
+	if err := os.WriteFile(cfgFile, []byte(`{"linnt": {}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := Load(cfgFile)
+	if err == nil || !strings.Contains(err.Error(), "linnt") {
+		t.Fatalf("expected error naming unknown key, got %v", err)
+	}
+}
+
+func TestLoadTOMLWithoutSection(t *testing.T) {
+	cfgFile := filepath.Join(t.TempDir(), ".emlang.toml")
+	if err := os.WriteFile(cfgFile, []byte("[tool.other]\nkey = 1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := Load(cfgFile)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(cfg.Lint.Ignore) != 0 {
+		t.Errorf("expected empty config, got %+v", cfg)
+	}
+}
+
+func TestLoadSearchesParentDirectories(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, ".emlang.yaml"), []byte(`lint:
+  ignore:
+    - "from-parent"
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	sub := filepath.Join(root, "models", "billing")
+	if err := os.MkdirAll(sub, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	origDir, _ := os.Getwd()
+	os.Chdir(sub)
+	defer os.Chdir(origDir)
+	t.Setenv("EMLANG_CONFIG", "")
+
+	cfg, err := Load("")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(cfg.Lint.Ignore) != 1 || cfg.Lint.Ignore[0] != "from-parent" {
+		t.Errorf("expected ignore rule from parent config, got %v", cfg.Lint.Ignore)
+	}
+}
+
+func TestLoadNearestConfigWins(t *testing.T) {
+	root := t.TempDir()
+	sub := filepath.Join(root, "models")
+	if err := os.MkdirAll(sub, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, ".emlang.yaml"), []byte("lint:\n  ignore: [\"from-root\"]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(sub, ".emlang.yaml"), []byte("lint:\n  ignore: [\"from-sub\"]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	origDir, _ := os.Getwd()
+	os.Chdir(sub)
+	defer os.Chdir(origDir)
+	t.Setenv("EMLANG_CONFIG", "")
+
+	cfg, err := Load("")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(cfg.Lint.Ignore) != 1 || cfg.Lint.Ignore[0] != "from-sub" {
+		t.Errorf("expected nearest config to win, got %v", cfg.Lint.Ignore)
+	}
+}
+
+func TestLoadExpandsEnv(t *testing.T) {
+	t.Setenv("EMLANG_TEST_PORT", "8080")
+	t.Setenv("EMLANG_TEST_COLOR", "#112233")
+	t.Setenv("EMLANG_TEST_FLAG", "true")
+
+	dir := t.TempDir()
+	cfgFile := filepath.Join(dir, ".emlang.yaml")
+	content := `
+diagram:
+  serve:
+    address: ${EMLANG_TEST_ADDRESS:-0.0.0.0}
+    port: ${EMLANG_TEST_PORT}
+  css:
+    --event-color: "${EMLANG_TEST_COLOR}"
+    --flag: $EMLANG_TEST_FLAG
+    --price: "$$5"
+`
+	if err := os.WriteFile(cfgFile, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := Load(cfgFile)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.Diagram.Serve.Port != 8080 {
+		t.Errorf("expected port 8080, got %d", cfg.Diagram.Serve.Port)
+	}
+	if cfg.Diagram.Serve.Address != "0.0.0.0" {
+		t.Errorf("expected default address, got %q", cfg.Diagram.Serve.Address)
+	}
+	if cfg.Diagram.CSS["--event-color"] != "#112233" {
+		t.Errorf("expected expanded color, got %q", cfg.Diagram.CSS["--event-color"])
+	}
+	if cfg.Diagram.CSS["--flag"] != "true" {
+		t.Errorf("expected expanded flag, got %q", cfg.Diagram.CSS["--flag"])
+	}
+	if cfg.Diagram.CSS["--price"] != "$5" {
+		t.Errorf("expected escaped dollar, got %q", cfg.Diagram.CSS["--price"])
+	}
+}
+
+func TestLoadUndefinedEnvErrors(t *testing.T) {
+	dir := t.TempDir()
+	cfgFile := filepath.Join(dir, ".emlang.yaml")
+	if err := os.WriteFile(cfgFile, []byte("diagram:\n  serve:\n    port: ${EMLANG_TEST_UNSET}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := Load(cfgFile)
+	if err == nil || !strings.Contains(err.Error(), `"EMLANG_TEST_UNSET" is not set`) {
+		t.Errorf("expected undefined variable error, got %v", err)
+	}
+}
@@ -0,0 +1,92 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// expandEnv replaces ${VAR} and $VAR references in every scalar value of
+// the YAML document (mapping keys are left alone), so that one checked-in
+// config can adapt per environment, e.g. port: ${PORT}.
+//
+// ${VAR:-default} uses default when VAR is unset or empty, and $$ is a
+// literal $. Any other reference to an unset variable is an error rather
+// than an empty value, so that a missing variable is noticed.
+func expandEnv(data []byte, lookup func(string) (string, bool)) ([]byte, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, err
+	}
+	if root.Kind == 0 {
+		return data, nil
+	}
+
+	changed, err := expandNode(&root, lookup)
+	if err != nil || !changed {
+		return data, err
+	}
+	return yaml.Marshal(&root)
+}
+
+// expandNode expands the scalar values under node, reporting whether any
+// value changed.
+func expandNode(node *yaml.Node, lookup func(string) (string, bool)) (bool, error) {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		if !strings.Contains(node.Value, "$") {
+			return false, nil
+		}
+		value, err := expandString(node.Value, lookup)
+		if err != nil {
+			return false, fmt.Errorf("line %d: %w", node.Line, err)
+		}
+		// Drop the resolved tag so that "${PORT}" can decode as a number.
+		node.Value, node.Tag, node.Style = value, "", 0
+		return true, nil
+	case yaml.MappingNode:
+		changed := false
+		for i := 1; i < len(node.Content); i += 2 {
+			c, err := expandNode(node.Content[i], lookup)
+			if err != nil {
+				return false, err
+			}
+			changed = changed || c
+		}
+		return changed, nil
+	default:
+		changed := false
+		for _, child := range node.Content {
+			c, err := expandNode(child, lookup)
+			if err != nil {
+				return false, err
+			}
+			changed = changed || c
+		}
+		return changed, nil
+	}
+}
+
+// expandString expands the variable references in s.
+func expandString(s string, lookup func(string) (string, bool)) (string, error) {
+	var err error
+	out := os.Expand(s, func(name string) string {
+		if name == "$" {
+			return "$"
+		}
+		if i := strings.Index(name, ":-"); i >= 0 {
+			if v, ok := lookup(name[:i]); ok && v != "" {
+				return v
+			}
+			return name[i+2:]
+		}
+		v, ok := lookup(name)
+		if !ok && err == nil {
+			err = fmt.Errorf("environment variable %q is not set", name)
+		}
+		return v
+	})
+	return out, err
+}
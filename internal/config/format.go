@@ -0,0 +1,64 @@
+package config
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// format converts the contents of a config file in some syntax into
+// equivalent YAML, so that validation and profile merging are shared.
+type format interface {
+	toYAML(data []byte) ([]byte, error)
+}
+
+// formats maps config file extensions to their format.
+var formats = map[string]format{
+	".yaml": yamlFormat{},
+	".yml":  yamlFormat{},
+	".json": jsonFormat{},
+	".toml": tomlFormat{},
+}
+
+// formatFor returns the format for a config path based on its extension.
+// Unknown extensions are treated as YAML.
+func formatFor(path string) format {
+	if f, ok := formats[strings.ToLower(filepath.Ext(path))]; ok {
+		return f
+	}
+	return yamlFormat{}
+}
+
+type yamlFormat struct{}
+
+func (yamlFormat) toYAML(data []byte) ([]byte, error) {
+	return data, nil
+}
+
+type jsonFormat struct{}
+
+func (jsonFormat) toYAML(data []byte) ([]byte, error) {
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(v)
+}
+
+// tomlFormat reads the [emlang] table of a TOML file.
+type tomlFormat struct{}
+
+func (tomlFormat) toYAML(data []byte) ([]byte, error) {
+	var v map[string]interface{}
+	if err := toml.Unmarshal(data, &v); err != nil {
+		return nil, err
+	}
+	section, ok := v["emlang"]
+	if !ok {
+		return nil, nil
+	}
+	return yaml.Marshal(section)
+}
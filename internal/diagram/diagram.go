@@ -2,11 +2,14 @@ package diagram
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha1"
 	"embed"
+	"errors"
 	"fmt"
 	"html/template"
 	"sort"
+	"strings"
 
 	"github.com/emlang-project/emlang/internal/ast"
 )
@@ -19,11 +22,194 @@ var tmpl = template.Must(template.ParseFS(templateFS, "templates/*.gohtml"))
 // Generator generates HTML diagrams from an AST.
 type Generator struct {
 	CSSOverrides map[string]string
+
+	// OmitCommonCSS leaves out the stylesheet shared by all diagrams,
+	// emitting only the per-document rules, so that a page embedding
+	// several diagrams can include CommonCSS once.
+	OmitCommonCSS bool
+
+	// Theme selects a built-in set of CSS variables from Themes.
+	// CSSOverrides take precedence over it. Empty uses the default (light) style.
+	Theme string
+
+	// NoExternalStyling disables the distinct styling of elements marked
+	// with the reserved prop external: true.
+	NoExternalStyling bool
+
+	// PropsStyle selects how element props are rendered:
+	// PropsGrid (default), PropsInline, PropsTooltip or PropsBadges.
+	PropsStyle string
+
+	// SeparateExceptionRow renders exceptions in their own row(s) below
+	// the events instead of sharing the events row.
+	SeparateExceptionRow bool
+
+	// ShowStepNumbers prefixes each slice element with its 1-based position
+	// in the slice, so steps can be referenced in walkthroughs.
+	ShowStepNumbers bool
+
+	// MaxColumns is the largest grid a document may need before Generate
+	// refuses to render it. Zero disables the check.
+	MaxColumns int
+
+	// WarnSliceWidth is the number of columns above which a slice is
+	// reported as a warning by GenerateResult. Zero disables the warning.
+	WarnSliceWidth int
+
+	// RefProps lists prop keys, besides those ending in "_ref", whose value
+	// names another element; in the props grid they link to that element.
+	RefProps []string
+
+	// TestsOnly renders only the slice names and their tests, one narrow
+	// column per slice, as a matrix of acceptance criteria.
+	TestsOnly bool
+
+	// ShowFooter adds a footer under each document summarizing its
+	// slice, element and test counts.
+	ShowFooter bool
+
+	// MaxLabelChars truncates element names longer than this many
+	// characters with an ellipsis; the full name is kept in the title
+	// attribute. Zero disables truncation.
+	MaxLabelChars int
+
+	// Timeline adds a row of connectors between adjacent slices, from the
+	// last event of a slice to the element of the next slice with the
+	// same name, such as the trigger or view it feeds.
+	Timeline bool
+
+	// Layout arranges the documents of a multi-document file:
+	// LayoutStacked (default) or LayoutTabbed.
+	Layout string
+
+	// SliceProps shows the props of each slice under its name.
+	SliceProps bool
+
+	// EventLayout arranges the event and exception rows: EventsPerLane
+	// (default) or EventsCombined.
+	EventLayout string
+}
+
+// Document layouts.
+const (
+	LayoutStacked = "stacked" // one document below the other
+	LayoutTabbed  = "tabbed"  // one document at a time, switched with tabs
+)
+
+// Layouts lists the valid values of Generator.Layout.
+var Layouts = []string{LayoutStacked, LayoutTabbed}
+
+// Event row layouts.
+const (
+	EventsPerLane  = "per-lane" // one row per swimlane
+	EventsCombined = "combined" // one row, each element labeled with its swimlane
+)
+
+// EventLayouts lists the valid values of Generator.EventLayout.
+var EventLayouts = []string{EventsPerLane, EventsCombined}
+
+// DefaultWarnSliceWidth is the default value of Generator.WarnSliceWidth.
+const DefaultWarnSliceWidth = 30
+
+// Warning is a non-fatal problem found while generating a diagram.
+type Warning struct {
+	Message string
+	Line    int
+	Column  int
+}
+
+func (w Warning) String() string {
+	return fmt.Sprintf("%d:%d: %s", w.Line, w.Column, w.Message)
+}
+
+// Result is a generated diagram along with its warnings.
+type Result struct {
+	HTML     []byte
+	Warnings []Warning
+}
+
+// DefaultMaxColumns is the default value of Generator.MaxColumns.
+// Wider grids can make browsers hang.
+const DefaultMaxColumns = 500
+
+// ErrTooManyColumns is returned by Generate when a document needs
+// more grid columns than Generator.MaxColumns.
+var ErrTooManyColumns = errors.New("too many columns")
+
+// ErrSliceNotFound is returned by GenerateSlice and SliceDocument when no
+// document has a slice of the given name.
+var ErrSliceNotFound = errors.New("slice not found")
+
+// Props rendering styles.
+const (
+	PropsGrid    = "grid"    // two-column key/value list
+	PropsInline  = "inline"  // single line, e.g. "customer_id=string, total=number"
+	PropsTooltip = "tooltip" // hover title on the element
+	PropsBadges  = "badges"  // one "key:type" pill per prop, colored by type
+)
+
+// PropsStyles lists the valid values of Generator.PropsStyle.
+var PropsStyles = []string{PropsGrid, PropsInline, PropsTooltip, PropsBadges}
+
+// badgeKinds maps the type words recognized in prop values to the kind
+// that selects a badge's color. Other values get a neutral badge.
+var badgeKinds = map[string]string{
+	"string":  "string",
+	"text":    "string",
+	"number":  "number",
+	"int":     "number",
+	"integer": "number",
+	"float":   "number",
+	"decimal": "number",
+	"bool":    "bool",
+	"boolean": "bool",
+	"uuid":    "uuid",
+}
+
+// Themes maps built-in theme names to the CSS variables they set on top of
+// the default stylesheet, which is the light theme.
+var Themes = map[string]map[string]string{
+	"light": {},
+	"dark": {
+		"--background-color":      "#212529",
+		"--text-color":            "#f8f9fa",
+		"--border-color":          "#495057",
+		"--trigger-color":         "#495057",
+		"--command-color":         "#1c4f7a",
+		"--event-color":           "#7a4a12",
+		"--exception-color":       "#7a2a2a",
+		"--view-color":            "#2b5e36",
+		"--projection-color":      "#4b3a7a",
+		"--external-border-color": "#adb5bd",
+	},
+	// colorblind uses tints of the Okabe-Ito palette, which stays
+	// distinguishable under the common color vision deficiencies, and gives
+	// each element type its own border pattern so color is not the only cue.
+	"colorblind": {
+		"--trigger-color":     "#eeeeee",
+		"--command-color":     "#b3d4ea",
+		"--event-color":       "#f5d899",
+		"--exception-color":   "#f2bf99",
+		"--view-color":        "#99d8c7",
+		"--projection-color":  "#ebc9dc",
+		"--trigger-border":    "1px solid #999999",
+		"--command-border":    "2px solid #0072b2",
+		"--event-border":      "2px dashed #e69f00",
+		"--exception-border":  "2px dotted #d55e00",
+		"--view-border":       "3px double #009e73",
+		"--projection-border": "2px dashed #cc79a7",
+	},
 }
 
+// ThemeNames lists the valid values of Generator.Theme.
+var ThemeNames = []string{"light", "dark", "colorblind"}
+
 // New creates a new diagram Generator.
 func New() *Generator {
-	return &Generator{}
+	return &Generator{
+		MaxColumns:     DefaultMaxColumns,
+		WarnSliceWidth: DefaultWarnSliceWidth,
+	}
 }
 
 // contentHash returns the first 12 hex characters of the SHA-1 hash of raw.
@@ -38,30 +224,97 @@ func documentID(hash string, idx int) string {
 	return fmt.Sprintf("emlang-document-%s-%d", hash, idx)
 }
 
+// SourceHash returns the hash of doc's raw source that Generate embeds in
+// its HTML ids, so it changes whenever the file does.
+func SourceHash(doc *ast.Document) string {
+	return contentHash(doc.RawSource)
+}
+
+// DocumentIDs returns the HTML id Generate gives to each subdocument of
+// doc, in order.
+func DocumentIDs(doc *ast.Document) []string {
+	hash := contentHash(doc.RawSource)
+	ids := make([]string, len(doc.SubDocs))
+	for i := range doc.SubDocs {
+		ids[i] = documentID(hash, i)
+	}
+	return ids
+}
+
 // layout holds precomputed layout info for a subdocument.
 type layout struct {
 	sliceOrder    []string
-	sliceWidths   map[string]int // number of elements per slice
-	totalColumns  int            // (1 swimlane if any) + sum of widths
-	sliceStartCol map[string]int // grid-column start for each slice's div
-	triggerLanes  []string       // unique swimlanes for triggers, in order
-	eventLanes    []string       // unique swimlanes for events/exceptions, in order
-	hasSwimlanes  bool           // true if any element has a swimlane
-	hasMainRow    bool           // true if any element is a command or view
+	sliceWidths   map[string]int       // number of elements per slice
+	totalColumns  int                  // (1 swimlane if any) + sum of widths
+	sliceStartCol map[string]int       // grid-column start for each slice's div
+	columns       map[*ast.Element]int // 1-based column of each slice element within its slice
+	triggerLanes  []string             // unique swimlanes for triggers, in order
+	eventLanes    []string             // unique swimlanes for events (and exceptions unless separate), in order
+	errorLanes    []string             // unique swimlanes for exceptions when separate, in order
+	hasSwimlanes  bool                 // true if any element has a swimlane
+	hasMainRow    bool                 // true if any element is a command or view
+	hasProjRow    bool                 // true if any element is a projection
+	sections      []section            // group header cells, empty without groups
+	docID         string               // HTML id of the document
+	anchors       map[string]string    // element name -> id of its first occurrence in the flow rows
+}
+
+// section is a cell of the group header row: a group spanning its slices,
+// or a single ungrouped slice (empty name).
+type section struct {
+	name     string
+	startCol int
+	span     int
+}
+
+// groupIndex maps each grouped slice name to its group.
+func groupIndex(sd *ast.SubDoc) map[string]*ast.Group {
+	index := make(map[string]*ast.Group)
+	for _, g := range sd.Groups {
+		for _, name := range g.Slices {
+			index[name] = g
+		}
+	}
+	return index
+}
+
+// sliceDisplayOrder returns the order in which slices are rendered.
+// The slices of a group are kept together, in group order,
+// at the position of the first of them in the document.
+func sliceDisplayOrder(sd *ast.SubDoc) []string {
+	if len(sd.Groups) == 0 {
+		return sd.SliceOrder
+	}
+
+	index := groupIndex(sd)
+	placed := make(map[*ast.Group]bool)
+	var order []string
+	for _, name := range sd.SliceOrder {
+		g, ok := index[name]
+		if !ok {
+			order = append(order, name)
+			continue
+		}
+		if !placed[g] {
+			placed[g] = true
+			order = append(order, g.Slices...)
+		}
+	}
+	return order
 }
 
-func computeLayout(sd *ast.SubDoc) *layout {
+func computeLayout(sd *ast.SubDoc, separateExceptions, testsOnly bool) *layout {
 	l := &layout{
-		sliceOrder:    sd.SliceOrder,
+		sliceOrder:    sliceDisplayOrder(sd),
 		sliceWidths:   make(map[string]int),
 		sliceStartCol: make(map[string]int),
+		columns:       make(map[*ast.Element]int),
 	}
 
 	totalWidth := 0
-	for _, name := range sd.SliceOrder {
-		slice := sd.Slices[name]
-		w := len(slice.Elements)
-		if w == 0 {
+	for _, name := range l.sliceOrder {
+		w := elementColumns(sd.Slices[name], l.columns)
+		if w == 0 || testsOnly {
 			w = 1
 		}
 		l.sliceWidths[name] = w
@@ -71,7 +324,8 @@ func computeLayout(sd *ast.SubDoc) *layout {
 	// Collect unique swimlanes by order of appearance
 	triggerSeen := map[string]bool{}
 	eventSeen := map[string]bool{}
-	for _, name := range sd.SliceOrder {
+	errorSeen := map[string]bool{}
+	for _, name := range l.sliceOrder {
 		slice := sd.Slices[name]
 		for _, elem := range slice.Elements {
 			if elem.Swimlane != "" {
@@ -86,7 +340,20 @@ func computeLayout(sd *ast.SubDoc) *layout {
 				}
 			case ast.ElementCommand, ast.ElementView:
 				l.hasMainRow = true
-			case ast.ElementEvent, ast.ElementException:
+			case ast.ElementProjection:
+				l.hasProjRow = true
+			case ast.ElementException:
+				lane := elem.Swimlane
+				if separateExceptions {
+					if !errorSeen[lane] {
+						errorSeen[lane] = true
+						l.errorLanes = append(l.errorLanes, lane)
+					}
+				} else if !eventSeen[lane] {
+					eventSeen[lane] = true
+					l.eventLanes = append(l.eventLanes, lane)
+				}
+			case ast.ElementEvent:
 				lane := elem.Swimlane
 				if !eventSeen[lane] {
 					eventSeen[lane] = true
@@ -96,41 +363,123 @@ func computeLayout(sd *ast.SubDoc) *layout {
 		}
 	}
 
+	// Swimlanes label element rows, which a tests-only diagram does not have
+	if testsOnly {
+		l.hasSwimlanes = false
+	}
+
 	// Swimlane column only when swimlanes are present
 	if l.hasSwimlanes {
 		l.totalColumns = 1 + totalWidth
 		col := 2
-		for _, name := range sd.SliceOrder {
+		for _, name := range l.sliceOrder {
 			l.sliceStartCol[name] = col
 			col += l.sliceWidths[name]
 		}
 	} else {
 		l.totalColumns = totalWidth
 		col := 1
-		for _, name := range sd.SliceOrder {
+		for _, name := range l.sliceOrder {
 			l.sliceStartCol[name] = col
 			col += l.sliceWidths[name]
 		}
 	}
 
+	if len(sd.Groups) > 0 {
+		index := groupIndex(sd)
+		for i := 0; i < len(l.sliceOrder); {
+			members := l.sliceOrder[i : i+1]
+			sec := section{startCol: l.sliceStartCol[l.sliceOrder[i]]}
+			if g, ok := index[l.sliceOrder[i]]; ok {
+				members = g.Slices
+				sec.name = g.Name
+			}
+			for _, name := range members {
+				sec.span += l.sliceWidths[name]
+			}
+			l.sections = append(l.sections, sec)
+			i += len(members)
+		}
+	}
+
 	return l
 }
 
-// elementIndex returns the 1-based position of an element within its slice.
-func elementIndex(slice *ast.Slice, elem *ast.Element) int {
-	for i, e := range slice.Elements {
-		if e == elem {
-			return i + 1
+// elementColumns records in cols the 1-based column of each element
+// within its slice. The elements of an alt construct share one column,
+// stacked by branch. An element's col prop, when within the slice,
+// overrides its sequential column. Returns the number of columns used.
+func elementColumns(slice *ast.Slice, cols map[*ast.Element]int) int {
+	col := 0
+	var prev *ast.Alt
+	for _, e := range slice.Elements {
+		if e.Alt == nil || e.Alt != prev {
+			col++
 		}
+		prev = e.Alt
+		cols[e] = col
 	}
-	return 1
+	for _, e := range slice.Elements {
+		if e.Col > 0 && e.Col <= col {
+			cols[e] = e.Col
+		}
+	}
+	return col
+}
+
+// sliceWidth returns the number of columns a slice's elements occupy.
+func sliceWidth(slice *ast.Slice) int {
+	return elementColumns(slice, make(map[*ast.Element]int, len(slice.Elements)))
 }
 
 // --- Template data structures ---
 
 type diagramData struct {
-	Overrides []cssOverride
-	Documents []documentData
+	OmitCommonCSS bool
+	Overrides     []cssOverride
+	Tabbed        bool
+	Documents     []documentData
+}
+
+// sortedOverrides returns CSS variable overrides sorted by name.
+func sortedOverrides(vars map[string]string) []cssOverride {
+	keys := make([]string, 0, len(vars))
+	for k := range vars {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var overrides []cssOverride
+	for _, k := range keys {
+		overrides = append(overrides, cssOverride{Key: template.CSS(k), Value: template.CSS(vars[k])})
+	}
+	return overrides
+}
+
+// Standalone wraps a generated diagram in a complete HTML page with the
+// given title. Any extra markup, such as scripts, ends the page body.
+func Standalone(fragment []byte, title string, extra ...string) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("<!DOCTYPE html>\n<html><head><meta charset=\"utf-8\"><title>")
+	buf.WriteString(template.HTMLEscapeString(title))
+	buf.WriteString("</title></head>\n<body>\n")
+	buf.Write(fragment)
+	for _, e := range extra {
+		buf.WriteString(e)
+	}
+	buf.WriteString("\n</body></html>\n")
+	return buf.Bytes()
+}
+
+// CommonCSS returns the stylesheet shared by all diagrams, with the given
+// CSS variable overrides, for hosts that generate with OmitCommonCSS and
+// include it once. The result is bare CSS, without a <style> element.
+func CommonCSS(overrides map[string]string) string {
+	var buf bytes.Buffer
+	if err := tmpl.ExecuteTemplate(&buf, "common-css", sortedOverrides(overrides)); err != nil {
+		// The template only ranges over static data and cannot fail.
+		panic(err)
+	}
+	return buf.String()
 }
 
 type cssOverride struct {
@@ -140,11 +489,22 @@ type cssOverride struct {
 
 type documentData struct {
 	ID           string
+	Overrides    []cssOverride // per-document CSS variables
+	Label        string
+	Title        string // tab label: the document title, or "Document N"
 	TotalColumns int
 	HasSwimlanes bool
 	SliceColumns []sliceColumnData
+	GroupColumns []sliceColumnData
+	Groups       []groupData
+	TimelineCols []sliceColumnData // connector placement in the timeline row
 	SliceNames   []sliceNameData
 	Rows         []rowData
+	Footer       string // summary counts, empty unless ShowFooter
+}
+
+type groupData struct {
+	Name string // empty for an ungrouped slice
 }
 
 type sliceColumnData struct {
@@ -155,13 +515,25 @@ type sliceColumnData struct {
 
 type sliceNameData struct {
 	DisplayName string
+	Props       []propData // slice props, when Generator.SliceProps is set
 }
 
 type rowData struct {
 	Class        string
+	Label        string
 	HasSwimlanes bool
 	Swimlane     string
 	Slices       []rowSliceData
+	Connectors   []connectorData // timeline row only
+}
+
+// connectorData is a timeline connector from the last event of a slice
+// to the matching element of the next one.
+type connectorData struct {
+	Name  string // shared element name
+	From  string // source slice name
+	To    string // target slice name
+	Title string
 }
 
 type rowSliceData struct {
@@ -170,63 +542,92 @@ type rowSliceData struct {
 }
 
 type elementData struct {
-	CSSClass string
-	Name     string
-	GridCol  int
-	Props    []propData
+	ID          string // HTML id, for deep links
+	CSSClass    string
+	Label       string // accessible label, e.g. "Command: PlaceOrder"
+	Name        string
+	Ordinal     int    // position marker shown before the name (0 = none)
+	Branch      string // alt branch the element belongs to, if any
+	Owner       string // owning team, from the reserved owner prop
+	Swimlane    string // swimlane shown on the element, in combined event rows
+	GridCol     int
+	Props       []propData // grid style
+	PropsInline string     // inline style
+	Badges      []propData // badges style
+	Tooltip     string     // title attribute: full name if truncated, props in tooltip style
 }
 
 type testData struct {
-	Name     string
-	HasGiven bool
-	Given    []elementData
-	HasWhen  bool
-	When     []elementData
-	HasThen  bool
-	Then     []elementData
+	Name       string
+	Label      string
+	HasGiven   bool
+	Given      []elementData
+	HasWhen    bool
+	When       []elementData
+	HasThen    bool
+	Then       []elementData
+	HasThenNot bool
+	ThenNot    []elementData
 }
 
 type propData struct {
-	Key   string
-	Value string
+	Key    string
+	Value  string
+	Target string     // id of the referenced element, for reference props
+	Kind   string     // badges style: type kind from badgeKinds, if recognized
+	Map    []propData // grid style: entries of a map value, sorted by key
+	List   []propData // grid style: items of a list value, without keys
 }
 
 // --- Build template data ---
 
-func (g *Generator) buildDiagramData(doc *ast.Document) diagramData {
+func (g *Generator) buildDiagramData(ctx context.Context, doc *ast.Document) (diagramData, error) {
 	hash := contentHash(doc.RawSource)
 
-	var overrides []cssOverride
-	if len(g.CSSOverrides) > 0 {
-		keys := make([]string, 0, len(g.CSSOverrides))
-		for k := range g.CSSOverrides {
-			keys = append(keys, k)
+	vars := map[string]string{}
+	if g.Theme != "" {
+		theme, ok := Themes[g.Theme]
+		if !ok {
+			return diagramData{}, fmt.Errorf("unknown theme %q", g.Theme)
 		}
-		sort.Strings(keys)
-		for _, k := range keys {
-			overrides = append(overrides, cssOverride{Key: template.CSS(k), Value: template.CSS(g.CSSOverrides[k])})
+		for k, v := range theme {
+			vars[k] = v
 		}
 	}
+	for k, v := range g.CSSOverrides {
+		vars[k] = v
+	}
+
+	overrides := sortedOverrides(vars)
 
 	var docs []documentData
 	for i, sd := range doc.SubDocs {
-		docs = append(docs, buildDocumentData(hash, i, sd))
+		if err := ctx.Err(); err != nil {
+			return diagramData{}, err
+		}
+		docs = append(docs, g.buildDocumentData(hash, i, sd))
 	}
 
 	return diagramData{
-		Overrides: overrides,
-		Documents: docs,
-	}
+		OmitCommonCSS: g.OmitCommonCSS,
+		Overrides:     overrides,
+		Tabbed:        g.Layout == LayoutTabbed,
+		Documents:     docs,
+	}, nil
 }
 
-func buildDocumentData(hash string, idx int, sd *ast.SubDoc) documentData {
-	l := computeLayout(sd)
+func (g *Generator) buildDocumentData(hash string, idx int, sd *ast.SubDoc) documentData {
+	l := computeLayout(sd, g.SeparateExceptionRow, g.TestsOnly)
+	l.docID = documentID(hash, idx)
+	if !g.TestsOnly {
+		l.anchors = elementAnchors(l, sd)
+	}
 
 	// Slice columns for CSS
 	var cols []sliceColumnData
 	if l.hasSwimlanes {
 		cols = append(cols, sliceColumnData{ChildIndex: 1, StartCol: 1, Span: 1})
-		for i, name := range sd.SliceOrder {
+		for i, name := range l.sliceOrder {
 			cols = append(cols, sliceColumnData{
 				ChildIndex: i + 2,
 				StartCol:   l.sliceStartCol[name],
@@ -234,7 +635,7 @@ func buildDocumentData(hash string, idx int, sd *ast.SubDoc) documentData {
 			})
 		}
 	} else {
-		for i, name := range sd.SliceOrder {
+		for i, name := range l.sliceOrder {
 			cols = append(cols, sliceColumnData{
 				ChildIndex: i + 1,
 				StartCol:   l.sliceStartCol[name],
@@ -243,6 +644,22 @@ func buildDocumentData(hash string, idx int, sd *ast.SubDoc) documentData {
 		}
 	}
 
+	// Group header cells
+	var groupCols []sliceColumnData
+	var groups []groupData
+	first := 1
+	if l.hasSwimlanes {
+		first = 2
+	}
+	for i, sec := range l.sections {
+		groupCols = append(groupCols, sliceColumnData{
+			ChildIndex: i + first,
+			StartCol:   sec.startCol,
+			Span:       sec.span,
+		})
+		groups = append(groups, groupData{Name: sec.name})
+	}
+
 	// Slice names
 	var names []sliceNameData
 	for _, name := range l.sliceOrder {
@@ -250,73 +667,288 @@ func buildDocumentData(hash string, idx int, sd *ast.SubDoc) documentData {
 		if displayName == "" {
 			displayName = "(anonymous)"
 		}
-		names = append(names, sliceNameData{DisplayName: displayName})
+		data := sliceNameData{DisplayName: displayName}
+		if g.SliceProps {
+			data.Props = buildProps(sd.Slices[name].Props)
+		}
+		names = append(names, data)
 	}
 
 	// Rows
 	var rows []rowData
+	var timelineCols []sliceColumnData
+	if !g.TestsOnly {
+		rows = g.buildElementRows(l, sd)
+		if g.Timeline {
+			if row, cols := buildTimelineRow(l, sd); len(cols) > 0 {
+				rows = append(rows, row)
+				timelineCols = cols
+			}
+		}
+	}
+
+	// Tests row
+	if hasTests(sd) {
+		rows = append(rows, g.buildTestsRow(l, sd))
+	}
+
+	var overrides []cssOverride
+	for _, v := range sd.CSS {
+		overrides = append(overrides, cssOverride{Key: template.CSS(v.Name), Value: template.CSS(v.Value)})
+	}
+
+	var footer string
+	if g.ShowFooter {
+		footer = footerText(sd)
+	}
+
+	title := sd.Title
+	if title == "" {
+		title = fmt.Sprintf("Document %d", idx+1)
+	}
+
+	return documentData{
+		ID:           l.docID,
+		Overrides:    overrides,
+		Label:        documentLabel(names),
+		Title:        title,
+		TotalColumns: l.totalColumns,
+		HasSwimlanes: l.hasSwimlanes,
+		SliceColumns: cols,
+		GroupColumns: groupCols,
+		Groups:       groups,
+		TimelineCols: timelineCols,
+		SliceNames:   names,
+		Rows:         rows,
+		Footer:       footer,
+	}
+}
+
+// footerText summarizes a subdocument, e.g. "3 slices, 12 elements, 4 tests".
+func footerText(sd *ast.SubDoc) string {
+	elements, tests := 0, 0
+	for _, slice := range sd.Slices {
+		elements += len(slice.Elements)
+		tests += len(slice.Tests)
+	}
+	return strings.Join([]string{
+		plural(len(sd.Slices), "slice"),
+		plural(elements, "element"),
+		plural(tests, "test"),
+	}, ", ")
+}
+
+// plural formats a count with its noun, e.g. "1 slice" or "3 slices".
+func plural(n int, noun string) string {
+	if n == 1 {
+		return fmt.Sprintf("%d %s", n, noun)
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
+
+// buildElementRows returns the element rows of a subdocument:
+// triggers, commands and views, projections, events and exceptions.
+func (g *Generator) buildElementRows(l *layout, sd *ast.SubDoc) []rowData {
+	var rows []rowData
 
 	// Trigger rows (one per swimlane)
 	for _, lane := range l.triggerLanes {
-		rows = append(rows, buildElementRow(l, sd, "emlang-row-triggers", lane, func(e *ast.Element) bool {
+		rows = append(rows, g.buildElementRow(l, sd, "emlang-row-triggers", lane, func(e *ast.Element) bool {
 			return e.Type == ast.ElementTrigger && e.Swimlane == lane
 		}))
 	}
 
 	// Main row (commands + views)
 	if l.hasMainRow {
-		rows = append(rows, buildElementRow(l, sd, "emlang-row-main", "", func(e *ast.Element) bool {
+		rows = append(rows, g.buildElementRow(l, sd, "emlang-row-main", "", func(e *ast.Element) bool {
 			return e.Type == ast.ElementCommand || e.Type == ast.ElementView
 		}))
 	}
 
+	// Projection row
+	if l.hasProjRow {
+		rows = append(rows, g.buildElementRow(l, sd, "emlang-row-projections", "", func(e *ast.Element) bool {
+			return e.Type == ast.ElementProjection
+		}))
+	}
+
+	if g.EventLayout == EventsCombined {
+		return append(rows, g.buildCombinedEventRows(l, sd)...)
+	}
+
 	// Event rows (one per swimlane)
 	for _, lane := range l.eventLanes {
-		rows = append(rows, buildElementRow(l, sd, "emlang-row-events", lane, func(e *ast.Element) bool {
-			return (e.Type == ast.ElementEvent || e.Type == ast.ElementException) && e.Swimlane == lane
+		rows = append(rows, g.buildElementRow(l, sd, "emlang-row-events", lane, func(e *ast.Element) bool {
+			isEvent := e.Type == ast.ElementEvent || (e.Type == ast.ElementException && !g.SeparateExceptionRow)
+			return isEvent && e.Swimlane == lane
 		}))
 	}
 
-	// Tests row
-	if hasTests(sd) {
-		rows = append(rows, buildTestsRow(l, sd))
+	// Exception rows (one per swimlane), when separated from events
+	for _, lane := range l.errorLanes {
+		rows = append(rows, g.buildElementRow(l, sd, "emlang-row-exceptions", lane, func(e *ast.Element) bool {
+			return e.Type == ast.ElementException && e.Swimlane == lane
+		}))
 	}
 
-	return documentData{
-		ID:           documentID(hash, idx),
-		TotalColumns: l.totalColumns,
-		HasSwimlanes: l.hasSwimlanes,
-		SliceColumns: cols,
-		SliceNames:   names,
-		Rows:         rows,
+	return rows
+}
+
+// rowOf returns a key identifying the element row elem is rendered in,
+// matching the rows of buildElementRows.
+func (g *Generator) rowOf(elem *ast.Element) string {
+	lane := elem.Swimlane
+	switch elem.Type {
+	case ast.ElementTrigger:
+		return "trigger/" + lane
+	case ast.ElementCommand, ast.ElementView:
+		return "main"
+	case ast.ElementProjection:
+		return "projection"
+	}
+	if g.EventLayout == EventsCombined {
+		lane = ""
+	}
+	if elem.Type == ast.ElementException && g.SeparateExceptionRow {
+		return "exception/" + lane
+	}
+	return "event/" + lane
+}
+
+// buildCombinedEventRows returns the event row, and the exception row when
+// separate, with the elements of every swimlane in one row each, labeled
+// with their swimlane.
+func (g *Generator) buildCombinedEventRows(l *layout, sd *ast.SubDoc) []rowData {
+	var rows []rowData
+	if len(l.eventLanes) > 0 {
+		rows = append(rows, g.buildElementRow(l, sd, "emlang-row-events", "", func(e *ast.Element) bool {
+			return e.Type == ast.ElementEvent || (e.Type == ast.ElementException && !g.SeparateExceptionRow)
+		}))
+	}
+	if len(l.errorLanes) > 0 {
+		rows = append(rows, g.buildElementRow(l, sd, "emlang-row-exceptions", "", func(e *ast.Element) bool {
+			return e.Type == ast.ElementException
+		}))
 	}
+	return rows
 }
 
-func buildElementRow(l *layout, sd *ast.SubDoc, class string, lane string, match func(*ast.Element) bool) rowData {
+func (g *Generator) buildElementRow(l *layout, sd *ast.SubDoc, class string, lane string, match func(*ast.Element) bool) rowData {
 	var slices []rowSliceData
-	for _, name := range l.sliceOrder {
+	for s, name := range l.sliceOrder {
 		slice := sd.Slices[name]
 		var elems []elementData
-		for _, elem := range slice.Elements {
+		for i, elem := range slice.Elements {
 			if match(elem) {
-				elems = append(elems, elementData{
-					CSSClass: "emlang-" + elem.Type.String(),
-					Name:     elem.Name,
-					GridCol:  elementIndex(slice, elem),
-					Props:    buildProps(elem.Props),
-				})
+				data := g.buildElement(l, elem)
+				data.ID = elementID(l.docID, s, elem, i)
+				data.GridCol = l.columns[elem]
+				if g.ShowStepNumbers {
+					data.Ordinal = data.GridCol
+				}
+				if g.EventLayout == EventsCombined && (class == "emlang-row-events" || class == "emlang-row-exceptions") {
+					data.Swimlane = elem.Swimlane
+				}
+				elems = append(elems, data)
 			}
 		}
 		slices = append(slices, rowSliceData{Elements: elems})
 	}
 	return rowData{
 		Class:        class,
+		Label:        rowLabel(class, lane),
 		HasSwimlanes: l.hasSwimlanes,
 		Swimlane:     lane,
 		Slices:       slices,
 	}
 }
 
+// buildTimelineRow returns the timeline row of a subdocument and the grid
+// placement of its connectors. Adjacent slices are connected when the last
+// event of the first has the same name as an element of the second.
+func buildTimelineRow(l *layout, sd *ast.SubDoc) (rowData, []sliceColumnData) {
+	row := rowData{
+		Class:        "emlang-row-timeline",
+		Label:        rowLabel("emlang-row-timeline", ""),
+		HasSwimlanes: l.hasSwimlanes,
+	}
+	var cols []sliceColumnData
+	child := 1
+	if l.hasSwimlanes {
+		child = 2
+	}
+
+	for i := 0; i+1 < len(l.sliceOrder); i++ {
+		fromName, toName := l.sliceOrder[i], l.sliceOrder[i+1]
+		from, to := sd.Slices[fromName], sd.Slices[toName]
+
+		var last *ast.Element
+		for _, elem := range from.Elements {
+			if elem.Type == ast.ElementEvent {
+				last = elem
+			}
+		}
+		if last == nil {
+			continue
+		}
+		var target *ast.Element
+		for _, elem := range to.Elements {
+			if elem.Name == last.Name {
+				target = elem
+				break
+			}
+		}
+		if target == nil {
+			continue
+		}
+
+		start := l.sliceStartCol[fromName] + l.columns[last] - 1
+		end := l.sliceStartCol[toName] + l.columns[target] - 1
+		cols = append(cols, sliceColumnData{ChildIndex: child, StartCol: start, Span: end - start + 1})
+		row.Connectors = append(row.Connectors, connectorData{
+			Name:  last.Name,
+			From:  fromName,
+			To:    toName,
+			Title: fmt.Sprintf("%s: %s → %s", last.Name, fromName, toName),
+		})
+		child++
+	}
+	return row, cols
+}
+
+// elementID returns the HTML id of elem, the i-th element of the s-th slice
+// of a document, e.g. "emlang-document-2fd4e1c67a2d-0-1-event-2".
+func elementID(docID string, s int, elem *ast.Element, i int) string {
+	return fmt.Sprintf("%s-%d-%s-%d", docID, s+1, elem.Type, i+1)
+}
+
+// testElementID returns the HTML id of elem, the i-th element of a section
+// of the k-th test of the s-th slice of a document,
+// e.g. "emlang-document-2fd4e1c67a2d-0-1-test-2-when-command-1".
+func testElementID(docID string, s, k int, section string, elem *ast.Element, i int) string {
+	return fmt.Sprintf("%s-%d-test-%d-%s-%s-%d", docID, s+1, k+1, section, elem.Type, i+1)
+}
+
+// elementAnchors maps element names, bare and swimlane-qualified, to the id
+// of their first occurrence in the flow rows, as targets of reference props.
+func elementAnchors(l *layout, sd *ast.SubDoc) map[string]string {
+	anchors := make(map[string]string)
+	for s, name := range l.sliceOrder {
+		for i, elem := range sd.Slices[name].Elements {
+			names := []string{elem.Name}
+			if elem.Swimlane != "" {
+				names = append(names, elem.Swimlane+"/"+elem.Name)
+			}
+			for _, n := range names {
+				if _, ok := anchors[n]; !ok {
+					anchors[n] = elementID(l.docID, s, elem, i)
+				}
+			}
+		}
+	}
+	return anchors
+}
+
 func hasTests(sd *ast.SubDoc) bool {
 	for _, name := range sd.SliceOrder {
 		if len(sd.Slices[name].Tests) > 0 {
@@ -326,70 +958,402 @@ func hasTests(sd *ast.SubDoc) bool {
 	return false
 }
 
-func buildTestsRow(l *layout, sd *ast.SubDoc) rowData {
+func (g *Generator) buildTestsRow(l *layout, sd *ast.SubDoc) rowData {
 	var slices []rowSliceData
-	for _, name := range l.sliceOrder {
+	for s, name := range l.sliceOrder {
 		slice := sd.Slices[name]
 		var tests []testData
-		for _, tn := range slice.TestOrder {
+		for k, tn := range slice.TestOrder {
 			test := slice.Tests[tn]
 			tests = append(tests, testData{
-				Name:     test.Name,
-				HasGiven: test.HasGiven,
-				Given:    buildTestElements(test.Given),
-				HasWhen:  test.HasWhen,
-				When:     buildTestElements(test.When),
-				HasThen:  test.HasThen,
-				Then:     buildTestElements(test.Then),
+				Name:       test.Name,
+				Label:      "Test: " + test.Name,
+				HasGiven:   test.HasGiven,
+				Given:      g.buildTestElements(l, s, k, "given", test.Given),
+				HasWhen:    test.HasWhen,
+				When:       numbered(g.buildTestElements(l, s, k, "when", test.When)),
+				HasThen:    test.HasThen,
+				Then:       g.buildTestElements(l, s, k, "then", test.Then),
+				HasThenNot: test.HasThenNot,
+				ThenNot:    g.buildTestElements(l, s, k, "then-not", test.ThenNot),
 			})
 		}
 		slices = append(slices, rowSliceData{Tests: tests})
 	}
 	return rowData{
 		Class:        "emlang-row-tests",
+		Label:        rowLabel("emlang-row-tests", ""),
 		HasSwimlanes: l.hasSwimlanes,
 		Slices:       slices,
 	}
 }
 
-func buildTestElements(elems []*ast.Element) []elementData {
+// buildTestElements returns the template data for the elements of a section
+// of the k-th test of the s-th slice.
+func (g *Generator) buildTestElements(l *layout, s, k int, section string, elems []*ast.Element) []elementData {
 	var result []elementData
-	for _, elem := range elems {
-		result = append(result, elementData{
-			CSSClass: "emlang-" + elem.Type.String(),
-			Name:     elem.Name,
-			Props:    buildProps(elem.Props),
-		})
+	for i, elem := range elems {
+		data := g.buildElement(l, elem)
+		data.ID = testElementID(l.docID, s, k, section, elem, i)
+		result = append(result, data)
 	}
 	return result
 }
 
-func buildProps(props []ast.PropEntry) []propData {
-	if len(props) == 0 {
-		return nil
+// buildElement returns the template data for an element,
+// with its props rendered in the configured style.
+func (g *Generator) buildElement(l *layout, elem *ast.Element) elementData {
+	name := displayName(elem)
+	data := elementData{
+		CSSClass: g.elementClass(elem),
+		Label:    elementLabel(elem),
+		Name:     truncate(name, g.MaxLabelChars),
+		Branch:   elem.Branch,
+		Owner:    elem.Owner,
+	}
+	if elem.Deprecated {
+		data.Label += " (deprecated)"
+	}
+
+	var title []string
+	if data.Name != name {
+		title = append(title, name)
+	}
+	props := buildProps(elem.Props)
+	for i := range props {
+		if ast.IsRefProp(props[i].Key, g.RefProps) {
+			props[i].Target = l.anchors[props[i].Value]
+		}
 	}
-	result := make([]propData, len(props))
+	switch g.PropsStyle {
+	case PropsInline:
+		data.PropsInline = joinProps(props, ", ")
+	case PropsTooltip:
+		if len(props) > 0 {
+			title = append(title, joinProps(props, "\n"))
+		}
+	case PropsBadges:
+		for i := range props {
+			props[i].Kind = badgeKinds[strings.ToLower(strings.TrimSpace(props[i].Value))]
+		}
+		data.Badges = props
+	default:
+		data.Props = props
+	}
+	data.Tooltip = strings.Join(title, "\n")
+	return data
+}
+
+// truncate shortens s to max characters, the last being an ellipsis.
+// A max of zero or less leaves s unchanged.
+func truncate(s string, max int) string {
+	r := []rune(s)
+	if max <= 0 || len(r) <= max {
+		return s
+	}
+	return string(r[:max-1]) + "…"
+}
+
+// joinProps renders props as key=value pairs separated by sep.
+func joinProps(props []propData, sep string) string {
+	parts := make([]string, len(props))
 	for i, p := range props {
-		result[i] = propData{
-			Key:   p.Key,
-			Value: fmt.Sprintf("%v", p.Value),
+		parts[i] = p.Key + "=" + p.Value
+	}
+	return strings.Join(parts, sep)
+}
+
+// rowLabels maps row classes to their accessible group labels.
+var rowLabels = map[string]string{
+	"emlang-row-triggers":    "Triggers",
+	"emlang-row-main":        "Commands and views",
+	"emlang-row-projections": "Projections",
+	"emlang-row-events":      "Events",
+	"emlang-row-exceptions":  "Exceptions",
+	"emlang-row-tests":       "Tests",
+	"emlang-row-timeline":    "Timeline",
+}
+
+// rowLabel returns the accessible label for a row, qualified by its swimlane.
+func rowLabel(class, lane string) string {
+	label := rowLabels[class]
+	if lane != "" {
+		label += ": " + lane
+	}
+	return label
+}
+
+// documentLabel returns the accessible label for a subdocument figure.
+func documentLabel(names []sliceNameData) string {
+	parts := make([]string, len(names))
+	for i, n := range names {
+		parts[i] = n.DisplayName
+	}
+	return "Event model: " + strings.Join(parts, ", ")
+}
+
+// elementLabel returns the accessible label for an element,
+// e.g. "Command: PlaceOrder".
+func elementLabel(elem *ast.Element) string {
+	typeName := elem.Type.String()
+	return strings.ToUpper(typeName[:1]) + typeName[1:] + ": " + displayName(elem)
+}
+
+// displayName returns the text shown for an element:
+// its label prop if set, otherwise its name.
+func displayName(elem *ast.Element) string {
+	if elem.Label != "" {
+		return elem.Label
+	}
+	return elem.Name
+}
+
+// numbered sets 1-based ordinals on elems when there is more than one,
+// so that a sequence (e.g. several when commands) reads in order.
+func numbered(elems []elementData) []elementData {
+	if len(elems) > 1 {
+		for i := range elems {
+			elems[i].Ordinal = i + 1
 		}
 	}
+	return elems
+}
+
+// elementClass returns the CSS class list for an element.
+func (g *Generator) elementClass(elem *ast.Element) string {
+	class := "emlang-" + elem.Type.String()
+	if elem.External && !g.NoExternalStyling {
+		class += " emlang-external"
+	}
+	if elem.Deprecated {
+		class += " emlang-deprecated"
+	}
+	return class
+}
+
+// buildProps returns the displayable props, skipping reserved keys.
+func buildProps(props []ast.PropEntry) []propData {
+	var result []propData
+	for _, p := range props {
+		if ast.ReservedProps[p.Key] {
+			continue
+		}
+		result = append(result, newPropData(p.Key, p.Value))
+	}
 	return result
 }
 
+// newPropData returns the prop data of a value, with the entries or items
+// of a map or list value for nested rendering. Value holds the flow text,
+// e.g. {a: 1, b: [x, y]}, for the styles that render props as text.
+func newPropData(key string, value interface{}) propData {
+	data := propData{Key: key, Value: propText(value)}
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for _, k := range sortedKeys(v) {
+			data.Map = append(data.Map, newPropData(k, v[k]))
+		}
+	case []interface{}:
+		for _, item := range v {
+			data.List = append(data.List, newPropData("", item))
+		}
+	}
+	return data
+}
+
+// propText formats a prop value as text, with maps and lists in YAML flow
+// style.
+func propText(value interface{}) string {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		entries := make([]string, 0, len(v))
+		for _, k := range sortedKeys(v) {
+			entries = append(entries, k+": "+propText(v[k]))
+		}
+		return "{" + strings.Join(entries, ", ") + "}"
+	case []interface{}:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			items = append(items, propText(item))
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	}
+	return fmt.Sprintf("%v", value)
+}
+
+// sortedKeys returns the keys of m in order.
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// checkColumns returns an error naming the widest slice if the layout of a
+// subdocument needs more than max grid columns.
+func checkColumns(idx int, sd *ast.SubDoc, l *layout, max int) error {
+	if l.totalColumns <= max {
+		return nil
+	}
+	widest := l.sliceOrder[0]
+	for _, name := range l.sliceOrder {
+		if l.sliceWidths[name] > l.sliceWidths[widest] {
+			widest = name
+		}
+	}
+	return fmt.Errorf("document %d: %w: needs %d, limit is %d (slice %q has %d elements)",
+		idx+1, ErrTooManyColumns, l.totalColumns, max, widest, len(sd.Slices[widest].Elements))
+}
+
 // Generate creates an HTML diagram from the given document.
 func (g *Generator) Generate(doc *ast.Document) ([]byte, error) {
+	return g.GenerateContext(context.Background(), doc)
+}
+
+// GenerateSlice creates an HTML diagram of only the named slice of doc,
+// for embedding in prose. Its ids are unique to the slice, so that
+// several slices of one document can be embedded on the same page; set
+// OmitCommonCSS to leave the shared stylesheet to the host page.
+func (g *Generator) GenerateSlice(doc *ast.Document, name string) ([]byte, error) {
+	single, err := SliceDocument(doc, name)
+	if err != nil {
+		return nil, err
+	}
+	return g.Generate(single)
+}
+
+// SliceDocument returns a document holding only the named slice of doc,
+// from the first document that has one, with that document's CSS
+// overrides. Its raw source is tagged with the slice name so that its
+// diagram ids differ from those of doc and of its other slices.
+func SliceDocument(doc *ast.Document, name string) (*ast.Document, error) {
+	for _, sd := range doc.SubDocs {
+		slice, ok := sd.Slices[name]
+		if !ok {
+			continue
+		}
+		single := &ast.SubDoc{
+			Title:      sd.Title,
+			Slices:     map[string]*ast.Slice{name: slice},
+			SliceOrder: []string{name},
+			CSS:        sd.CSS,
+		}
+		raw := append(append([]byte(nil), doc.RawSource...), "\x00slice:"+name...)
+		return &ast.Document{
+			Slices:    single.Slices,
+			SubDocs:   []*ast.SubDoc{single},
+			RawSource: raw,
+		}, nil
+	}
+	return nil, fmt.Errorf("%w: %q", ErrSliceNotFound, name)
+}
+
+// GenerateContext is like Generate but stops with the context's error
+// once ctx is done. Cancellation is checked between subdocuments.
+func (g *Generator) GenerateContext(ctx context.Context, doc *ast.Document) ([]byte, error) {
+	result, err := g.generate(ctx, doc)
+	if err != nil {
+		return nil, err
+	}
+	return result.HTML, nil
+}
+
+// GenerateResult is like Generate but also returns non-fatal warnings,
+// such as overly wide slices or props whose values cannot be rendered.
+func (g *Generator) GenerateResult(doc *ast.Document) (*Result, error) {
+	return g.generate(context.Background(), doc)
+}
+
+// warnings returns the non-fatal problems of a document.
+func (g *Generator) warnings(doc *ast.Document) []Warning {
+	var warnings []Warning
+	for _, slice := range doc.AllSlicesInOrder() {
+		if w := sliceWidth(slice); g.WarnSliceWidth > 0 && w > g.WarnSliceWidth {
+			first := slice.Elements[0]
+			warnings = append(warnings, Warning{
+				Message: fmt.Sprintf("slice %q is %d columns wide (more than %d)", slice.Name, w, g.WarnSliceWidth),
+				Line:    first.Line,
+				Column:  first.Column,
+			})
+		}
+
+		cols := make(map[*ast.Element]int, len(slice.Elements))
+		width := elementColumns(slice, cols)
+		type cell struct {
+			row string
+			col int
+		}
+		cells := map[cell]*ast.Element{}
+		for _, elem := range slice.Elements {
+			if elem.Col > width {
+				warnings = append(warnings, Warning{
+					Message: fmt.Sprintf("col %d of %s %q is outside slice %q (%d columns wide), rendered in sequence", elem.Col, elem.Type, elem.Name, slice.Name, width),
+					Line:    elem.Line,
+					Column:  elem.Column,
+				})
+			}
+			c := cell{g.rowOf(elem), cols[elem]}
+			if other, ok := cells[c]; ok && (other.Alt == nil || other.Alt != elem.Alt) {
+				warnings = append(warnings, Warning{
+					Message: fmt.Sprintf("%s %q is in the same cell as %s %q (column %d of slice %q) and overlaps it", elem.Type, elem.Name, other.Type, other.Name, c.col, slice.Name),
+					Line:    elem.Line,
+					Column:  elem.Column,
+				})
+				continue
+			}
+			cells[c] = elem
+		}
+
+		if g.PropsStyle == "" || g.PropsStyle == PropsGrid {
+			continue // the grid renders nested values as nested lists
+		}
+		elems := append([]*ast.Element(nil), slice.Elements...)
+		for _, tn := range slice.TestOrder {
+			test := slice.Tests[tn]
+			for _, section := range [][]*ast.Element{test.Given, test.When, test.Then, test.ThenNot} {
+				elems = append(elems, section...)
+			}
+		}
+		for _, elem := range elems {
+			for _, p := range elem.Props {
+				switch p.Value.(type) {
+				case map[string]interface{}, []interface{}:
+					warnings = append(warnings, Warning{
+						Message: fmt.Sprintf("prop %q of %s %q has a nested value, shown as text in the %s props style", p.Key, elem.Type, elem.Name, g.PropsStyle),
+						Line:    p.Line,
+						Column:  p.Column,
+					})
+				}
+			}
+		}
+	}
+	return warnings
+}
+
+func (g *Generator) generate(ctx context.Context, doc *ast.Document) (*Result, error) {
 	if len(doc.SubDocs) == 0 {
-		return []byte(""), nil
+		return &Result{HTML: []byte("")}, nil
 	}
 
-	data := g.buildDiagramData(doc)
+	if g.MaxColumns > 0 {
+		for i, sd := range doc.SubDocs {
+			if err := checkColumns(i, sd, computeLayout(sd, g.SeparateExceptionRow, g.TestsOnly), g.MaxColumns); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	data, err := g.buildDiagramData(ctx, doc)
+	if err != nil {
+		return nil, err
+	}
 
 	var buf bytes.Buffer
 	if err := tmpl.ExecuteTemplate(&buf, "diagram", data); err != nil {
 		return nil, fmt.Errorf("executing diagram template: %w", err)
 	}
 
-	return buf.Bytes(), nil
+	return &Result{HTML: buf.Bytes(), Warnings: g.warnings(doc)}, nil
 }
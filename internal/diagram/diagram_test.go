@@ -1,8 +1,11 @@
 package diagram
 
 import (
+	"context"
 	"crypto/sha1"
+	"errors"
 	"fmt"
+	"regexp"
 	"strings"
 	"testing"
 
@@ -92,6 +95,81 @@ slices:
 	assertContains(t, out, `>EmailAlreadyInUse</span>`)
 }
 
+func TestTestThenNot(t *testing.T) {
+	input := `
+slices:
+  Payment:
+    steps:
+      - c: ProcessPayment
+      - e: PaymentProcessed
+    tests:
+      NoDoubleCharge:
+        given:
+          - e: PaymentProcessed
+        when:
+          - c: ProcessPayment
+        then-not:
+          - e: PaymentProcessed
+`
+	doc, err := parser.Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+
+	html, err := New().Generate(doc)
+	if err != nil {
+		t.Fatalf("generate error: %v", err)
+	}
+
+	out := string(html)
+
+	assertContains(t, out, `>THEN NOT</span>`)
+	assertContains(t, out, `<div class="emlang-then-not">`)
+	if strings.Contains(out, `>THEN</span>`) {
+		t.Error("expected no THEN block when only then-not is present")
+	}
+}
+
+func TestMultipleWhenCommandsNumbered(t *testing.T) {
+	input := `
+slices:
+  Checkout:
+    steps:
+      - c: AddItem
+      - c: PlaceOrder
+      - e: OrderPlaced
+    tests:
+      AddThenPlace:
+        when:
+          - c: AddItem
+          - c: PlaceOrder
+        then:
+          - e: OrderPlaced
+      PlaceOnly:
+        when:
+          - c: PlaceOrder
+`
+	doc, err := parser.Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+
+	html, err := New().Generate(doc)
+	if err != nil {
+		t.Fatalf("generate error: %v", err)
+	}
+
+	out := string(html)
+
+	assertContains(t, out, `<span><span class="emlang-ordinal">1.</span> AddItem</span>`)
+	assertContains(t, out, `<span><span class="emlang-ordinal">2.</span> PlaceOrder</span>`)
+
+	// A single when command is not numbered
+	if count := strings.Count(out, `class="emlang-ordinal"`); count != 2 {
+		t.Errorf("expected 2 ordinal markers, got %d", count)
+	}
+}
+
 func TestMultiDocuments(t *testing.T) {
 	input := `---
 slices:
@@ -101,110 +179,1289 @@ slices:
     - e: UserRegistered
 ---
 slices:
-  UserDeletion:
-    - t: Admin/UserManagement
-    - c: DeleteUser
-    - e: UserDeleted
+  UserDeletion:
+    - t: Admin/UserManagement
+    - c: DeleteUser
+    - e: UserDeleted
+`
+	doc, err := parser.Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+
+	gen := New()
+
+	html, err := gen.Generate(doc)
+	if err != nil {
+		t.Fatalf("generate error: %v", err)
+	}
+
+	out := string(html)
+
+	hash := testHash(input)
+
+	// Two documents with content-hash IDs
+	assertContains(t, out, fmt.Sprintf(`id="emlang-document-%s-0"`, hash))
+	assertContains(t, out, fmt.Sprintf(`id="emlang-document-%s-1"`, hash))
+
+	// Both slice names present
+	assertContains(t, out, `>UserRegistration</span>`)
+	assertContains(t, out, `>UserDeletion</span>`)
+
+	// Two separate CSS blocks for grid columns
+	assertContains(t, out, fmt.Sprintf(`#emlang-document-%s-0`, hash))
+	assertContains(t, out, fmt.Sprintf(`#emlang-document-%s-1`, hash))
+}
+
+func TestSwimlanes(t *testing.T) {
+	input := `
+slices:
+  checkout:
+    - t: Customer/ClickCheckout
+    - c: PlaceOrder
+    - e: Warehouse/OrderReady
+    - e: Billing/InvoiceSent
+`
+	doc, err := parser.Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+
+	gen := New()
+
+	html, err := gen.Generate(doc)
+	if err != nil {
+		t.Fatalf("generate error: %v", err)
+	}
+
+	out := string(html)
+
+	// Trigger swimlane
+	assertContains(t, out, `class="emlang-swimlane">Customer</span>`)
+
+	// Event swimlanes
+	assertContains(t, out, `class="emlang-swimlane">Warehouse</span>`)
+	assertContains(t, out, `class="emlang-swimlane">Billing</span>`)
+
+	// Multiple trigger rows and event rows
+	count := strings.Count(out, `emlang-row-triggers`)
+	if count < 1 {
+		t.Errorf("expected at least 1 trigger row, got %d", count)
+	}
+
+	eventRowCount := strings.Count(out, `emlang-row-events`)
+	if eventRowCount < 2 {
+		t.Errorf("expected at least 2 event rows (for 2 swimlanes), got %d", eventRowCount)
+	}
+}
+
+func TestProps(t *testing.T) {
+	input := `
+slices:
+  checkout:
+    - c: PlaceOrder
+      props:
+        customer_id: string
+        total: number
+    - e: OrderPlaced
+`
+	doc, err := parser.Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+
+	gen := New()
+
+	html, err := gen.Generate(doc)
+	if err != nil {
+		t.Fatalf("generate error: %v", err)
+	}
+
+	out := string(html)
+
+	assertContains(t, out, `class="emlang-props"`)
+	assertContains(t, out, `<dt>customer_id</dt>`)
+	assertContains(t, out, `<dd>string</dd>`)
+	assertContains(t, out, `<dt>total</dt>`)
+}
+
+func TestPropsInline(t *testing.T) {
+	input := `
+slices:
+  test:
+    - c: PlaceOrder
+      props:
+        customer_id: string
+        total: number
+`
+	doc, err := parser.Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+
+	gen := New()
+	gen.PropsStyle = PropsInline
+
+	html, err := gen.Generate(doc)
+	if err != nil {
+		t.Fatalf("generate error: %v", err)
+	}
+
+	out := string(html)
+
+	assertContains(t, out, `<span class="emlang-props-inline">customer_id=string, total=number</span>`)
+	if strings.Contains(out, `<dl class="emlang-props">`) {
+		t.Error("expected no props grid in inline style")
+	}
+}
+
+func TestPropsBadges(t *testing.T) {
+	input := `
+slices:
+  test:
+    - c: PlaceOrder
+      props:
+        customer_id: UUID
+        total: number
+        gift: bool
+        note: free text, up to 200 chars
+`
+	doc, err := parser.Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+
+	gen := New()
+	gen.PropsStyle = PropsBadges
+
+	html, err := gen.Generate(doc)
+	if err != nil {
+		t.Fatalf("generate error: %v", err)
+	}
+
+	out := string(html)
+
+	assertContains(t, out, `<span class="emlang-badge emlang-badge-uuid">customer_id:UUID</span>`)
+	assertContains(t, out, `<span class="emlang-badge emlang-badge-number">total:number</span>`)
+	assertContains(t, out, `<span class="emlang-badge emlang-badge-bool">gift:bool</span>`)
+	// Values that are not type words still render, without a type color
+	assertContains(t, out, `<span class="emlang-badge">note:free text, up to 200 chars</span>`)
+	if strings.Contains(out, `<dl class="emlang-props">`) {
+		t.Error("expected no props grid in badges style")
+	}
+}
+
+func TestPropsTooltip(t *testing.T) {
+	input := `
+slices:
+  test:
+    - c: PlaceOrder
+      props:
+        customer_id: string
+        total: number
+`
+	doc, err := parser.Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+
+	gen := New()
+	gen.PropsStyle = PropsTooltip
+
+	html, err := gen.Generate(doc)
+	if err != nil {
+		t.Fatalf("generate error: %v", err)
+	}
+
+	out := string(html)
+
+	assertContains(t, out, "title=\"customer_id=string\ntotal=number\"")
+	if strings.Contains(out, `<dl class="emlang-props">`) {
+		t.Error("expected no props grid in tooltip style")
+	}
+}
+
+func TestMaxColumns(t *testing.T) {
+	var sb strings.Builder
+	sb.WriteString("slices:\n  small:\n    - c: A\n  huge:\n")
+	for i := 0; i < 20; i++ {
+		fmt.Fprintf(&sb, "    - e: E%d\n", i)
+	}
+	doc, err := parser.Parse(strings.NewReader(sb.String()))
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+
+	gen := New()
+	gen.MaxColumns = 10
+
+	_, err = gen.Generate(doc)
+	if !errors.Is(err, ErrTooManyColumns) {
+		t.Fatalf("expected ErrTooManyColumns, got %v", err)
+	}
+	if !strings.Contains(err.Error(), `slice "huge" has 20 elements`) {
+		t.Errorf("expected error to name the widest slice, got %v", err)
+	}
+
+	// Zero disables the check
+	gen.MaxColumns = 0
+	if _, err := gen.Generate(doc); err != nil {
+		t.Errorf("unexpected error without limit: %v", err)
+	}
+}
+
+func TestShowStepNumbers(t *testing.T) {
+	input := `
+slices:
+  checkout:
+    - t: ClickBuy
+    - c: PlaceOrder
+    - e: OrderPlaced
+  shipping:
+    - c: ShipOrder
+`
+	doc, err := parser.Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+
+	gen := New()
+
+	html, err := gen.Generate(doc)
+	if err != nil {
+		t.Fatalf("generate error: %v", err)
+	}
+	if strings.Contains(string(html), `class="emlang-ordinal"`) {
+		t.Error("expected no step numbers by default")
+	}
+
+	gen.ShowStepNumbers = true
+
+	html, err = gen.Generate(doc)
+	if err != nil {
+		t.Fatalf("generate error: %v", err)
+	}
+
+	out := string(html)
+
+	assertContains(t, out, `<span><span class="emlang-ordinal">1.</span> ClickBuy</span>`)
+	assertContains(t, out, `<span><span class="emlang-ordinal">2.</span> PlaceOrder</span>`)
+	assertContains(t, out, `<span><span class="emlang-ordinal">3.</span> OrderPlaced</span>`)
+	// Numbering restarts in each slice
+	assertContains(t, out, `<span><span class="emlang-ordinal">1.</span> ShipOrder</span>`)
+}
+
+func TestLabelProp(t *testing.T) {
+	input := `
+slices:
+  checkout:
+    - c: PlaceOrder
+      props:
+        label: |-
+          Place the
+          order
+        total: number
+`
+	doc, err := parser.Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+
+	html, err := New().Generate(doc)
+	if err != nil {
+		t.Fatalf("generate error: %v", err)
+	}
+
+	out := string(html)
+
+	assertContains(t, out, "<span>Place the\norder</span>")
+	assertContains(t, out, `<dt>total</dt>`)
+	if strings.Contains(out, `<dt>label</dt>`) {
+		t.Error("expected label prop not to be listed in props")
+	}
+	if strings.Contains(out, `>PlaceOrder</span>`) {
+		t.Error("expected label to replace the element name")
+	}
+}
+
+func TestOwnerProp(t *testing.T) {
+	input := `
+slices:
+  checkout:
+    steps:
+      - c: PlaceOrder
+        props:
+          owner: payments
+          total: number
+      - e: OrderPlaced
+    tests:
+      places:
+        when:
+          - c: PlaceOrder
+            props:
+              owner: billing
+        then:
+          - e: OrderPlaced
+`
+	doc, err := parser.Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+
+	html, err := New().Generate(doc)
+	if err != nil {
+		t.Fatalf("generate error: %v", err)
+	}
+
+	out := string(html)
+
+	assertContains(t, out, `<span class="emlang-owner" title="Owner">payments</span>`)
+	assertContains(t, out, `<span class="emlang-owner" title="Owner">billing</span>`)
+	assertContains(t, out, `<dt>total</dt>`)
+	if strings.Contains(out, `<dt>owner</dt>`) {
+		t.Error("expected owner prop not to be listed in props")
+	}
+}
+
+func TestSeparateExceptionRow(t *testing.T) {
+	input := `
+slices:
+  pay:
+    - c: Pay
+    - e: Billing/Paid
+    - x: Billing/PaymentFailed
+`
+	doc, err := parser.Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+
+	gen := New()
+
+	html, err := gen.Generate(doc)
+	if err != nil {
+		t.Fatalf("generate error: %v", err)
+	}
+	if strings.Contains(string(html), "emlang-row-exceptions") {
+		t.Error("expected exceptions to share the events row by default")
+	}
+
+	gen.SeparateExceptionRow = true
+
+	html, err = gen.Generate(doc)
+	if err != nil {
+		t.Fatalf("generate error: %v", err)
+	}
+
+	id := "emlang-document-" + testHash(input) + "-0"
+	expected := `<div class="emlang-row emlang-row-events" role="group" aria-label="Events: Billing">
+<div>
+<span class="emlang-swimlane">Billing</span></div>
+<div>
+<div id="` + id + `-1-event-2" class="emlang-event" style="grid-column: 2" role="img" aria-label="Event: Paid">
+<span>Paid</span>
+</div>
+</div>
+</div>
+<div class="emlang-row emlang-row-exceptions" role="group" aria-label="Exceptions: Billing">
+<div>
+<span class="emlang-swimlane">Billing</span></div>
+<div>
+<div id="` + id + `-1-exception-3" class="emlang-exception" style="grid-column: 3" role="img" aria-label="Exception: PaymentFailed">
+<span>PaymentFailed</span>
+</div>
+</div>
+</div>
+`
+	assertContains(t, string(html), expected)
+}
+
+func TestAltStacked(t *testing.T) {
+	input := `
+slices:
+  checkout:
+    - c: PlaceOrder
+    - alt:
+        accepted:
+          - e: OrderPlaced
+        rejected:
+          - x: OrderRejected
+    - v: Orders
+`
+	doc, err := parser.Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+
+	html, err := New().Generate(doc)
+	if err != nil {
+		t.Fatalf("generate error: %v", err)
+	}
+
+	out := string(html)
+
+	// The alt occupies a single column, its branches stacked in it
+	assertContains(t, out, `repeat(3, auto)`)
+	assertContains(t, out, `class="emlang-event" style="grid-column: 2" role="img" aria-label="Event: OrderPlaced">
+<span>OrderPlaced</span>
+<span class="emlang-branch">accepted</span>`)
+	assertContains(t, out, `class="emlang-exception" style="grid-column: 2" role="img" aria-label="Exception: OrderRejected">
+<span>OrderRejected</span>
+<span class="emlang-branch">rejected</span>`)
+	assertContains(t, out, `class="emlang-view" style="grid-column: 3" role="img" aria-label="View: Orders">`)
+}
+
+func TestGenerateResultWarnings(t *testing.T) {
+	input := `
+slices:
+  small:
+    - c: A
+  wide:
+    - c: B
+    - e: B1
+    - e: B2
+    - e: B3
+      props:
+        items: [a, b]
+`
+	doc, err := parser.Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+
+	gen := New()
+	gen.WarnSliceWidth = 3
+	gen.PropsStyle = PropsInline // the grid renders nested values
+
+	result, err := gen.GenerateResult(doc)
+	if err != nil {
+		t.Fatalf("generate error: %v", err)
+	}
+	if len(result.HTML) == 0 {
+		t.Error("expected HTML output alongside warnings")
+	}
+	if len(result.Warnings) != 2 {
+		t.Fatalf("expected 2 warnings, got %v", result.Warnings)
+	}
+
+	wide := result.Warnings[0]
+	if !strings.Contains(wide.Message, `slice "wide" is 4 columns wide`) || wide.Line != 6 {
+		t.Errorf("unexpected width warning: %v", wide)
+	}
+	nested := result.Warnings[1]
+	if !strings.Contains(nested.Message, `prop "items"`) || nested.Line != 11 {
+		t.Errorf("unexpected prop warning: %v", nested)
+	}
+
+	// Generate discards warnings
+	html, err := gen.Generate(doc)
+	if err != nil {
+		t.Fatalf("generate error: %v", err)
+	}
+	if string(html) != string(result.HTML) {
+		t.Error("expected Generate to return the same HTML as GenerateResult")
+	}
+}
+
+func TestDocumentCSSOverrides(t *testing.T) {
+	input := `
+slices:
+  current:
+    - c: A
+---
+css:
+  --command-color: "#ff0000"
+slices:
+  proposed:
+    - c: B
+`
+	doc, err := parser.Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+
+	gen := New()
+	gen.CSSOverrides = map[string]string{"--event-color": "#00ff00"}
+
+	html, err := gen.Generate(doc)
+	if err != nil {
+		t.Fatalf("generate error: %v", err)
+	}
+
+	out := string(html)
+	hash := contentHash(doc.RawSource)
+
+	first := out[strings.Index(out, "#"+documentID(hash, 0)):strings.Index(out, "#"+documentID(hash, 1))]
+	second := out[strings.Index(out, "#"+documentID(hash, 1)):strings.Index(out, "</style>")]
+
+	if strings.Contains(first, "--command-color") {
+		t.Error("expected the first document's block to have no override")
+	}
+	if !strings.Contains(second, "--command-color: #ff0000;") {
+		t.Errorf("expected the second document's block to override --command-color, got:\n%s", second)
+	}
+
+	// Global overrides still apply to all documents
+	assertContains(t, out, "--event-color: #00ff00;")
+}
+
+func TestMaxLabelChars(t *testing.T) {
+	input := `
+slices:
+  test:
+    - c: RegisterCustomerWithLoyaltyProgram
+    - e: Registered
+`
+	doc, err := parser.Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+
+	gen := New()
+	gen.MaxLabelChars = 12
+
+	html, err := gen.Generate(doc)
+	if err != nil {
+		t.Fatalf("generate error: %v", err)
+	}
+
+	out := string(html)
+
+	assertContains(t, out, `title="RegisterCustomerWithLoyaltyProgram"`)
+	assertContains(t, out, "<span>RegisterCus…</span>")
+	assertContains(t, out, "<span>Registered</span>")
+	if strings.Contains(out, `title="Registered"`) {
+		t.Error("expected no title on a name within the limit")
+	}
+}
+
+func TestTestsOnly(t *testing.T) {
+	input := `
+slices:
+  register:
+    steps:
+      - t: Customer/Form
+      - c: Register
+      - e: Registered
+    tests:
+      happy-path:
+        when:
+          - c: Register
+        then:
+          - e: Registered
+  other:
+    - c: DoOther
+    - e: DidOther
+`
+	doc, err := parser.Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+
+	gen := New()
+	gen.TestsOnly = true
+
+	html, err := gen.Generate(doc)
+	if err != nil {
+		t.Fatalf("generate error: %v", err)
+	}
+
+	out := string(html)
+
+	assertContains(t, out, `class="emlang-row emlang-row-tests"`)
+	assertContains(t, out, "<span>happy-path</span>")
+	assertContains(t, out, "grid-template-columns: repeat(2, auto);")
+	for _, class := range []string{"emlang-row-triggers", "emlang-row-main", "emlang-row-events", "emlang-swimlane"} {
+		if strings.Contains(out, `class="emlang-row `+class) || strings.Contains(out, `class="`+class) {
+			t.Errorf("expected no %s in tests-only diagram", class)
+		}
+	}
+}
+
+func TestShowFooter(t *testing.T) {
+	input := `
+slices:
+  register:
+    steps:
+      - c: Register
+      - e: Registered
+    tests:
+      happy-path:
+        when:
+          - c: Register
+        then:
+          - e: Registered
+  placeholder:
+`
+	doc, err := parser.Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+
+	gen := New()
+	html, err := gen.Generate(doc)
+	if err != nil {
+		t.Fatalf("generate error: %v", err)
+	}
+	if strings.Contains(string(html), `<div class="emlang-footer">`) {
+		t.Error("expected no footer by default")
+	}
+
+	gen.ShowFooter = true
+	html, err = gen.Generate(doc)
+	if err != nil {
+		t.Fatalf("generate error: %v", err)
+	}
+	assertContains(t, string(html), `<div class="emlang-footer">2 slices, 2 elements, 1 test</div>`)
+}
+
+func TestRefPropLinks(t *testing.T) {
+	input := `
+slices:
+  checkout:
+    - c: PlaceOrder
+    - e: OrderPlaced
+  shipping:
+    - c: ShipOrder
+      props:
+        source_event_ref: OrderPlaced
+        policy_ref: Unknown
+        trigger: OrderPlaced
+`
+	doc, err := parser.Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+
+	gen := New()
+	gen.RefProps = []string{"trigger"}
+
+	html, err := gen.Generate(doc)
+	if err != nil {
+		t.Fatalf("generate error: %v", err)
+	}
+
+	out := string(html)
+	id := "emlang-document-" + testHash(input) + "-0-1-event-2"
+
+	assertContains(t, out, `<div id="`+id+`" class="emlang-event"`)
+	assertContains(t, out, `<dt>source_event_ref</dt>
+<dd><a href="#`+id+`">OrderPlaced</a></dd>`)
+	assertContains(t, out, `<dt>trigger</dt>
+<dd><a href="#`+id+`">OrderPlaced</a></dd>`)
+	assertContains(t, out, `<dt>policy_ref</dt>
+<dd>Unknown</dd>`)
+}
+
+func TestElementIDs(t *testing.T) {
+	input := `
+slices:
+  register:
+    steps:
+      - c: Register
+      - e: Registered
+    tests:
+      happy-path:
+        given:
+          - e: Registered
+        when:
+          - c: Register
+        then:
+          - e: Registered
+---
+slices:
+  register:
+    - c: Register
+    - e: Registered
+`
+	doc, err := parser.Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+
+	html, err := New().Generate(doc)
+	if err != nil {
+		t.Fatalf("generate error: %v", err)
+	}
+	again, err := New().Generate(doc)
+	if err != nil {
+		t.Fatalf("generate error: %v", err)
+	}
+	if string(html) != string(again) {
+		t.Error("expected deterministic output")
+	}
+
+	out := string(html)
+	prefix := "emlang-document-" + testHash(input)
+
+	assertContains(t, out, `id="`+prefix+`-0-1-command-1"`)
+	assertContains(t, out, `id="`+prefix+`-0-1-test-1-given-event-1"`)
+	assertContains(t, out, `id="`+prefix+`-0-1-test-1-when-command-1"`)
+	assertContains(t, out, `id="`+prefix+`-1-1-event-2"`)
+
+	elements := regexp.MustCompile(`<div [^>]*class="emlang-(command|event)"`).FindAllString(out, -1)
+	ids := regexp.MustCompile(`id="([^"]+)"`)
+	seen := map[string]bool{}
+	for _, el := range elements {
+		m := ids.FindStringSubmatch(el)
+		if m == nil {
+			t.Errorf("expected an id on %s", el)
+			continue
+		}
+		if seen[m[1]] {
+			t.Errorf("duplicate id %q", m[1])
+		}
+		seen[m[1]] = true
+	}
+	if len(seen) != 7 {
+		t.Errorf("expected 7 element ids, got %d", len(seen))
+	}
+}
+
+func TestThemeColorblind(t *testing.T) {
+	doc, err := parser.Parse(strings.NewReader(`
+slices:
+  test:
+    - c: PlaceOrder
+    - e: OrderPlaced
+`))
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+
+	gen := New()
+	gen.Theme = "colorblind"
+	gen.CSSOverrides = map[string]string{"--event-color": "#ffffff"}
+
+	html, err := gen.Generate(doc)
+	if err != nil {
+		t.Fatalf("generate error: %v", err)
+	}
+
+	out := string(html)
+
+	assertContains(t, out, "--command-color: #b3d4ea;")
+	assertContains(t, out, "--command-border: 2px solid #0072b2;")
+	assertContains(t, out, "--event-border: 2px dashed #e69f00;")
+	assertContains(t, out, "--exception-border: 2px dotted #d55e00;")
+	// User overrides win over the theme
+	assertContains(t, out, "--event-color: #ffffff;")
+	if strings.Contains(out, "--event-color: #f5d899;") {
+		t.Error("expected the override to replace the theme's event color")
+	}
+
+	gen.Theme = "sepia"
+	if _, err := gen.Generate(doc); err == nil {
+		t.Error("expected an error for an unknown theme")
+	}
+}
+
+func TestOmitCommonCSS(t *testing.T) {
+	input := `
+slices:
+  test:
+    - c: PlaceOrder
+    - e: OrderPlaced
+`
+	doc, err := parser.Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+
+	gen := New()
+	gen.OmitCommonCSS = true
+	gen.CSSOverrides = map[string]string{"--command-color": "#ff0000"}
+
+	html, err := gen.Generate(doc)
+	if err != nil {
+		t.Fatalf("generate error: %v", err)
+	}
+
+	out := string(html)
+
+	if strings.Contains(out, ".emlang-documents {") {
+		t.Error("expected no common CSS")
+	}
+	assertContains(t, out, fmt.Sprintf("#emlang-document-%s-0 {", testHash(input)))
+	assertContains(t, out, "grid-template-columns: repeat(2, auto);")
+
+	css := CommonCSS(gen.CSSOverrides)
+	assertContains(t, css, ".emlang-documents {")
+	assertContains(t, css, "--command-color: #ff0000;")
+	if strings.Contains(css, "<style>") {
+		t.Error("expected bare CSS without a style element")
+	}
+}
+
+func TestTimeline(t *testing.T) {
+	input := `
+slices:
+  place-order:
+    - c: PlaceOrder
+    - e: OrderPlaced
+  ship-order:
+    - t: OrderPlaced
+    - c: ShipOrder
+    - e: OrderShipped
+  unrelated:
+    - c: Archive
+    - e: Archived
+`
+	doc, err := parser.Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+
+	gen := New()
+	html, err := gen.Generate(doc)
+	if err != nil {
+		t.Fatalf("generate error: %v", err)
+	}
+	if strings.Contains(string(html), `class="emlang-row emlang-row-timeline"`) {
+		t.Error("expected no timeline row without Timeline")
+	}
+
+	gen.Timeline = true
+	html, err = gen.Generate(doc)
+	if err != nil {
+		t.Fatalf("generate error: %v", err)
+	}
+	out := string(html)
+
+	assertContains(t, out, `<div class="emlang-row emlang-row-timeline" role="group" aria-label="Timeline">`)
+	assertContains(t, out, `<span class="emlang-connector" title="OrderPlaced: place-order → ship-order">OrderPlaced</span>`)
+	if n := strings.Count(out, `class="emlang-connector"`); n != 1 {
+		t.Errorf("expected 1 connector, got %d", n)
+	}
+
+	// From OrderPlaced (column 2) to the OrderPlaced trigger (column 3)
+	assertContains(t, out, `.emlang-row-timeline {
+            & > div:nth-child(1) {
+                grid-column: 2 / span 2;`)
+}
+
+func TestExplain(t *testing.T) {
+	input := `
+slices:
+  register:
+    steps:
+      - t: Customer/Form
+      - c: Register
+      - e: Accounts/Registered
+    tests:
+      registers:
+        when:
+          - c: Register
+        then:
+          - e: Registered
+  pay:
+    - c: Pay
+`
+	doc, err := parser.Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+
+	out := New().Explain(doc)
+
+	for _, want := range []string{
+		"columns: 5 (1 swimlane label column, because elements use swimlanes)",
+		"register: columns 2-4\n",
+		"pay: column 5\n",
+		`Triggers: Customer: triggers in swimlane "Customer"`,
+		"Commands and views: commands and views share one row",
+		`Events: Accounts: events and exceptions in swimlane "Accounts"`,
+		"Tests: tests of 1 slice",
+		"projections: no projections",
+	} {
+		assertContains(t, out, want)
+	}
+	if strings.Contains(out, "triggers: no triggers") {
+		t.Error("triggers row should not be reported as omitted")
+	}
+}
+
+func TestTabbedLayout(t *testing.T) {
+	input := `---
+title: Registration
+slices:
+  UserRegistration:
+    - c: RegisterUser
+    - e: UserRegistered
+---
+slices:
+  UserDeletion:
+    - c: DeleteUser
+    - e: UserDeleted
+`
+	doc, err := parser.Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+
+	gen := New()
+	gen.Layout = LayoutTabbed
+
+	html, err := gen.Generate(doc)
+	if err != nil {
+		t.Fatalf("generate error: %v", err)
+	}
+
+	out := string(html)
+
+	ids := DocumentIDs(doc)
+	assertContains(t, out, `<div class="emlang-documents emlang-tabbed">`)
+	assertContains(t, out, `<nav class="emlang-tabs" aria-label="Documents">`)
+	assertContains(t, out, `<a href="#`+ids[0]+`">Registration</a>`)
+	assertContains(t, out, `<a href="#`+ids[1]+`">Document 2</a>`)
+
+	// Stacked, the default, has no tabs
+	html, err = New().Generate(doc)
+	if err != nil {
+		t.Fatalf("generate error: %v", err)
+	}
+	if strings.Contains(string(html), `class="emlang-tabs"`) {
+		t.Error("expected no tabs in the stacked layout")
+	}
+}
+
+func TestGenerateSlice(t *testing.T) {
+	input := `
+slices:
+  place-order:
+    - c: PlaceOrder
+    - e: OrderPlaced
+  ship-order:
+    - c: ShipOrder
+    - e: OrderShipped
+`
+	doc, err := parser.Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+
+	gen := New()
+	gen.OmitCommonCSS = true
+
+	ids := map[string]string{}
+	for _, name := range []string{"place-order", "ship-order"} {
+		html, err := gen.GenerateSlice(doc, name)
+		if err != nil {
+			t.Fatalf("generate error: %v", err)
+		}
+		out := string(html)
+		m := regexp.MustCompile(`<div id="(emlang-document-[^"]+)"`).FindStringSubmatch(out)
+		if m == nil {
+			t.Fatalf("expected a document id in the %s snippet", name)
+		}
+		ids[name] = m[1]
+		if strings.Contains(out, "--font-family-normal") {
+			t.Errorf("expected no common CSS in the %s snippet", name)
+		}
+	}
+	if ids["place-order"] == ids["ship-order"] {
+		t.Errorf("expected distinct ids for the two snippets, got %s", ids["place-order"])
+	}
+	if full := DocumentIDs(doc)[0]; full == ids["place-order"] || full == ids["ship-order"] {
+		t.Errorf("expected snippet ids to differ from the full document id %s", full)
+	}
+
+	html, err := gen.GenerateSlice(doc, "place-order")
+	if err != nil {
+		t.Fatalf("generate error: %v", err)
+	}
+	if strings.Contains(string(html), "ShipOrder") {
+		t.Error("expected only the requested slice in the snippet")
+	}
+
+	if _, err := gen.GenerateSlice(doc, "missing"); !errors.Is(err, ErrSliceNotFound) || !strings.Contains(err.Error(), `"missing"`) {
+		t.Errorf("expected a not-found error, got %v", err)
+	}
+}
+
+func TestCombinedEventLayout(t *testing.T) {
+	input := `
+slices:
+  order:
+    - c: Place
+    - e: Sales/Placed
+    - e: Billing/Invoiced
+    - x: Sales/OutOfStock
 `
 	doc, err := parser.Parse(strings.NewReader(input))
 	if err != nil {
 		t.Fatalf("parse error: %v", err)
 	}
 
-	gen := New()
+	const eventRow = `class="emlang-row emlang-row-events"`
 
-	html, err := gen.Generate(doc)
+	html, err := New().Generate(doc)
 	if err != nil {
 		t.Fatalf("generate error: %v", err)
 	}
+	perLane := string(html)
+	if n := strings.Count(perLane, eventRow); n != 2 {
+		t.Errorf("expected one event row per swimlane by default, got %d", n)
+	}
 
+	g := New()
+	g.EventLayout = EventsCombined
+	html, err = g.Generate(doc)
+	if err != nil {
+		t.Fatalf("generate error: %v", err)
+	}
 	out := string(html)
+	if n := strings.Count(out, eventRow); n != 1 {
+		t.Errorf("expected a single combined event row, got %d", n)
+	}
 
-	hash := testHash(input)
-
-	// Two documents with content-hash IDs
-	assertContains(t, out, fmt.Sprintf(`id="emlang-document-%s-0"`, hash))
-	assertContains(t, out, fmt.Sprintf(`id="emlang-document-%s-1"`, hash))
-
-	// Both slice names present
-	assertContains(t, out, `>UserRegistration</span>`)
-	assertContains(t, out, `>UserDeletion</span>`)
-
-	// Two separate CSS blocks for grid columns
-	assertContains(t, out, fmt.Sprintf(`#emlang-document-%s-0`, hash))
-	assertContains(t, out, fmt.Sprintf(`#emlang-document-%s-1`, hash))
+	want := `<div class="emlang-row emlang-row-events" role="group" aria-label="Events">
+<div></div>
+<div>
+<div id="emlang-document-70c9a8eadd33-0-1-event-2" class="emlang-event" style="grid-column: 2" role="img" aria-label="Event: Placed">
+<span>Placed</span>
+<span class="emlang-lane" title="Swimlane">Sales</span>
+</div>
+<div id="emlang-document-70c9a8eadd33-0-1-event-3" class="emlang-event" style="grid-column: 3" role="img" aria-label="Event: Invoiced">
+<span>Invoiced</span>
+<span class="emlang-lane" title="Swimlane">Billing</span>
+</div>
+<div id="emlang-document-70c9a8eadd33-0-1-exception-4" class="emlang-exception" style="grid-column: 4" role="img" aria-label="Exception: OutOfStock">
+<span>OutOfStock</span>
+<span class="emlang-lane" title="Swimlane">Sales</span>
+</div>
+</div>
+</div>`
+	assertContains(t, out, want)
+
+	if strings.Contains(perLane, `class="emlang-lane"`) {
+		t.Error("per-lane rows should not label elements with their swimlane")
+	}
 }
 
-func TestSwimlanes(t *testing.T) {
+func TestSliceProps(t *testing.T) {
 	input := `
 slices:
   checkout:
-    - t: Customer/ClickCheckout
-    - c: PlaceOrder
-    - e: Warehouse/OrderReady
-    - e: Billing/InvoiceSent
+    props:
+      ticket: SHOP-142
+    steps:
+      - c: PlaceOrder
 `
 	doc, err := parser.Parse(strings.NewReader(input))
 	if err != nil {
 		t.Fatalf("parse error: %v", err)
 	}
 
-	gen := New()
+	html, err := New().Generate(doc)
+	if err != nil {
+		t.Fatalf("generate error: %v", err)
+	}
+	if strings.Contains(string(html), `class="emlang-props emlang-slice-props"`) {
+		t.Error("slice props should be hidden by default")
+	}
 
-	html, err := gen.Generate(doc)
+	g := New()
+	g.SliceProps = true
+	html, err = g.Generate(doc)
 	if err != nil {
 		t.Fatalf("generate error: %v", err)
 	}
+	assertContains(t, string(html), `<span class="emlang-slicename">checkout</span>
+<dl class="emlang-props emlang-slice-props">
+<dt>ticket</dt>
+<dd>SHOP-142</dd>
+</dl>`)
+}
 
-	out := string(html)
+func TestOutline(t *testing.T) {
+	input := `
+slices:
+  register:
+    steps:
+      - t: Customer/Form
+      - c: Register
+      - e: Registered
+    tests:
+      registers:
+        when:
+          - c: Register
+        then:
+          - e: Registered
+  pay:
+    - c: Pay
+    - x: PaymentDeclined
+`
+	doc, err := parser.Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
 
-	// Trigger swimlane
-	assertContains(t, out, `class="emlang-swimlane">Customer</span>`)
+	out, err := Outline(doc)
+	if err != nil {
+		t.Fatalf("outline error: %v", err)
+	}
 
-	// Event swimlanes
-	assertContains(t, out, `class="emlang-swimlane">Warehouse</span>`)
-	assertContains(t, out, `class="emlang-swimlane">Billing</span>`)
+	assertContains(t, string(out), `<details open>
+<summary>Document 1</summary>
+<details open>
+<summary>register</summary>
+<ul>
+<li>Trigger: Form (Customer)</li>
+<li>Command: Register</li>
+<li>Event: Registered</li>
+</ul>
+<details>
+<summary>registers</summary>
+<ul>
+<li>when Command: Register</li>
+<li>then Event: Registered</li>
+</ul>
+</details>
+</details>
+<details open>
+<summary>pay</summary>
+<ul>
+<li>Command: Pay</li>
+<li>Exception: PaymentDeclined</li>
+</ul>
+</details>
+</details>`)
+}
 
-	// Multiple trigger rows and event rows
-	count := strings.Count(out, `emlang-row-triggers`)
-	if count < 1 {
-		t.Errorf("expected at least 1 trigger row, got %d", count)
+func TestColProp(t *testing.T) {
+	input := `
+slices:
+  checkout:
+    - c: PlaceOrder
+    - v: Cart
+    - e: OrderPlaced
+      props:
+        col: 1
+    - x: OutOfStock
+      props:
+        col: 9
+`
+	doc, err := parser.Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
 	}
 
-	eventRowCount := strings.Count(out, `emlang-row-events`)
-	if eventRowCount < 2 {
-		t.Errorf("expected at least 2 event rows (for 2 swimlanes), got %d", eventRowCount)
+	result, err := New().GenerateResult(doc)
+	if err != nil {
+		t.Fatalf("generate error: %v", err)
+	}
+	out := string(result.HTML)
+
+	assertContains(t, out, `class="emlang-event" style="grid-column: 1" role="img" aria-label="Event: OrderPlaced"`)
+	assertContains(t, out, `class="emlang-exception" style="grid-column: 4" role="img" aria-label="Exception: OutOfStock"`)
+	if strings.Contains(out, "<dt>col</dt>") {
+		t.Error("col is reserved and should not be listed as a prop")
+	}
+
+	if len(result.Warnings) != 1 || !strings.Contains(result.Warnings[0].Message, "col 9") || result.Warnings[0].Line != 9 {
+		t.Errorf("expected one warning for col 9 at line 9, got %v", result.Warnings)
 	}
 }
 
-func TestProps(t *testing.T) {
+func TestColPropOverlapWarning(t *testing.T) {
 	input := `
 slices:
   checkout:
     - c: PlaceOrder
-      props:
-        customer_id: string
-        total: number
     - e: OrderPlaced
+    - x: OutOfStock
+      props:
+        col: 2
+    - e: Billing/Invoiced
+      props:
+        col: 2
 `
 	doc, err := parser.Parse(strings.NewReader(input))
 	if err != nil {
 		t.Fatalf("parse error: %v", err)
 	}
 
+	result, err := New().GenerateResult(doc)
+	if err != nil {
+		t.Fatalf("generate error: %v", err)
+	}
+	if len(result.Warnings) != 1 || result.Warnings[0].Line != 6 {
+		t.Fatalf("expected one overlap warning at line 6, got %v", result.Warnings)
+	}
+	assertContains(t, result.Warnings[0].Message, `exception "OutOfStock" is in the same cell as event "OrderPlaced"`)
+
 	gen := New()
+	gen.SeparateExceptionRow = true
+	result, err = gen.GenerateResult(doc)
+	if err != nil {
+		t.Fatalf("generate error: %v", err)
+	}
+	if len(result.Warnings) != 0 {
+		t.Errorf("expected no warning with a separate exception row, got %v", result.Warnings)
+	}
+}
 
-	html, err := gen.Generate(doc)
+func TestNestedProps(t *testing.T) {
+	input := `
+slices:
+  current:
+    - c: A
+      props:
+        address:
+          street: <Main>
+          city: Paris
+        tags: [a, {b: c}]
+`
+	doc, err := parser.Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+
+	result, err := New().GenerateResult(doc)
 	if err != nil {
 		t.Fatalf("generate error: %v", err)
 	}
+	if len(result.Warnings) != 0 {
+		t.Errorf("expected no warnings in the grid style, got %v", result.Warnings)
+	}
+	html := string(result.HTML)
+	assertContains(t, html, "<dt>address</dt>\n<dd>\n<dl class=\"emlang-props\">\n<dt>city</dt>\n<dd>Paris</dd>\n<dt>street</dt>\n<dd>&lt;Main&gt;</dd>\n</dl></dd>")
+	assertContains(t, html, "<dt>tags</dt>\n<dd>\n<ul class=\"emlang-props-list\">\n<li>a</li>\n<li>\n<dl class=\"emlang-props\">\n<dt>b</dt>\n<dd>c</dd>\n</dl></li>\n</ul></dd>")
 
-	out := string(html)
+	gen := New()
+	gen.PropsStyle = PropsInline
+	out, err := gen.Generate(doc)
+	if err != nil {
+		t.Fatalf("generate error: %v", err)
+	}
+	assertContains(t, string(out), "address={city: Paris, street: &lt;Main&gt;}, tags=[a, {b: c}]")
+}
 
-	assertContains(t, out, `class="emlang-props"`)
-	assertContains(t, out, `<dt>customer_id</dt>`)
-	assertContains(t, out, `<dd>string</dd>`)
-	assertContains(t, out, `<dt>total</dt>`)
+func TestDeprecatedElement(t *testing.T) {
+	input := `
+slices:
+  register:
+    - c: Register
+      props:
+        deprecated: true
+    - e: Registered
+`
+	doc, err := parser.Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+
+	out, err := New().Generate(doc)
+	if err != nil {
+		t.Fatalf("generate error: %v", err)
+	}
+	html := string(out)
+	assertContains(t, html, `class="emlang-command emlang-deprecated" style="grid-column: 1" role="img" aria-label="Command: Register (deprecated)"`)
+	assertContains(t, html, `class="emlang-event" style="grid-column: 2" role="img" aria-label="Event: Registered"`)
+	if strings.Contains(html, "<dt>deprecated</dt>") {
+		t.Error("deprecated is reserved and should not be listed as a prop")
+	}
 }
 
 func TestGridColumnLayout(t *testing.T) {
@@ -286,6 +1543,101 @@ slices:
 	assertContains(t, out, `class="emlang-row emlang-row-main"`)
 }
 
+func TestGroups(t *testing.T) {
+	input := `
+groups:
+  Onboarding: [Register, Verify]
+slices:
+  Register:
+    - c: Register
+    - e: Registered
+  Pay:
+    - c: Pay
+  Verify:
+    - c: Verify
+`
+	doc, err := parser.Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+
+	html, err := New().Generate(doc)
+	if err != nil {
+		t.Fatalf("generate error: %v", err)
+	}
+
+	out := string(html)
+
+	assertContains(t, out, `class="emlang-row emlang-row-groups"`)
+	assertContains(t, out, `<span class="emlang-groupname">Onboarding</span>`)
+
+	// Grouped slices are rendered together, ahead of the ungrouped one
+	register := strings.Index(out, `class="emlang-slicename">Register<`)
+	verify := strings.Index(out, `class="emlang-slicename">Verify<`)
+	pay := strings.Index(out, `class="emlang-slicename">Pay<`)
+	if !(register < verify && verify < pay) {
+		t.Errorf("expected slice order Register < Verify < Pay, got %d, %d, %d", register, verify, pay)
+	}
+
+	// The group spans Register (2 columns) and Verify (1 column)
+	assertContains(t, out, `.emlang-row-groups {
+            & > div:nth-child(1) {
+                grid-column: 1 / span 3;`)
+}
+
+func TestNoGroupsRow(t *testing.T) {
+	doc, err := parser.Parse(strings.NewReader("slices:\n  a:\n    - c: A\n"))
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+
+	html, err := New().Generate(doc)
+	if err != nil {
+		t.Fatalf("generate error: %v", err)
+	}
+
+	if strings.Contains(string(html), "emlang-row-groups") {
+		t.Error("expected no group row without groups")
+	}
+}
+
+func TestProjectionRow(t *testing.T) {
+	input := `
+slices:
+  summary:
+    - c: PlaceOrder
+    - e: OrderPlaced
+    - p: OrderSummaryProjector
+    - v: OrderSummary
+`
+	doc, err := parser.Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+
+	gen := New()
+
+	html, err := gen.Generate(doc)
+	if err != nil {
+		t.Fatalf("generate error: %v", err)
+	}
+
+	out := string(html)
+
+	assertContains(t, out, `class="emlang-projection"`)
+	assertContains(t, out, `>OrderSummaryProjector</span>`)
+	assertContains(t, out, `--projection-color:`)
+	assertContains(t, out, `class="emlang-row emlang-row-projections"`)
+
+	// Projection row sits between the main row and the events row
+	main := strings.Index(out, `emlang-row-main"`)
+	proj := strings.Index(out, `emlang-row-projections"`)
+	events := strings.Index(out, `emlang-row-events"`)
+	if !(main < proj && proj < events) {
+		t.Errorf("expected row order main < projections < events, got %d, %d, %d", main, proj, events)
+	}
+}
+
 func TestExceptionInEventRow(t *testing.T) {
 	input := `
 slices:
@@ -399,6 +1751,116 @@ slices:
 	assertContains(t, out2, fmt.Sprintf(`id="emlang-document-%s-0"`, hash2))
 }
 
+func TestExternalElementStyling(t *testing.T) {
+	input := `
+slices:
+  checkout:
+    - c: PlaceOrder
+    - e: OrderPlaced
+    - c: ChargeCard
+      props:
+        external: true
+        gateway: stripe
+    - e: CardCharged
+      props:
+        external: false
+`
+	doc, err := parser.Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+
+	gen := New()
+	html, err := gen.Generate(doc)
+	if err != nil {
+		t.Fatalf("generate error: %v", err)
+	}
+
+	out := string(html)
+
+	if count := strings.Count(out, `class="emlang-command emlang-external"`); count != 1 {
+		t.Errorf("expected 1 external command, got %d", count)
+	}
+	assertContains(t, out, `class="emlang-event"`)
+	if strings.Contains(out, `class="emlang-event emlang-external"`) {
+		t.Error("expected external: false to render without the external class")
+	}
+	if strings.Contains(out, `<dt>external</dt>`) {
+		t.Error("expected reserved prop external to be omitted from props")
+	}
+	assertContains(t, out, `<dt>gateway</dt>`)
+
+	gen.NoExternalStyling = true
+	html, err = gen.Generate(doc)
+	if err != nil {
+		t.Fatalf("generate error: %v", err)
+	}
+	if strings.Contains(string(html), `emlang-command emlang-external`) {
+		t.Error("expected NoExternalStyling to drop the external class")
+	}
+}
+
+func TestAccessibilityAttributes(t *testing.T) {
+	input := `
+slices:
+  checkout:
+    - t: Customer/ClickCheckout
+    - c: PlaceOrder
+    - e: Order "Placed" <now>
+  refunds:
+    steps:
+      - c: RefundOrder
+      - e: OrderRefunded
+    tests:
+      CanRefund:
+        when:
+          - c: RefundOrder
+        then:
+          - e: OrderRefunded
+`
+	doc, err := parser.Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+
+	html, err := New().Generate(doc)
+	if err != nil {
+		t.Fatalf("generate error: %v", err)
+	}
+
+	out := string(html)
+
+	assertContains(t, out, `role="figure" aria-label="Event model: checkout, refunds"`)
+	assertContains(t, out, `role="img" aria-label="Trigger: ClickCheckout"`)
+	assertContains(t, out, `role="img" aria-label="Command: PlaceOrder"`)
+	assertContains(t, out, `role="img" aria-label="Event: Order &#34;Placed&#34; &lt;now&gt;"`)
+	assertContains(t, out, `role="group" aria-label="Slices"`)
+	assertContains(t, out, `role="group" aria-label="Triggers: Customer"`)
+	assertContains(t, out, `role="group" aria-label="Commands and views"`)
+	assertContains(t, out, `role="group" aria-label="Events"`)
+	assertContains(t, out, `role="group" aria-label="Tests"`)
+	assertContains(t, out, `role="group" aria-label="Test: CanRefund"`)
+	assertContains(t, out, `role="img" aria-label="Command: RefundOrder"`)
+}
+
+func TestGenerateContextCancelled(t *testing.T) {
+	doc, err := parser.Parse(strings.NewReader("slices:\n  a:\n    - c: A\n"))
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	html, err := New().GenerateContext(ctx, doc)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	if html != nil {
+		t.Error("expected no output when cancelled")
+	}
+}
+
 func testHash(input string) string {
 	h := sha1.Sum([]byte(input))
 	return fmt.Sprintf("%x", h)[:12]
@@ -410,3 +1872,24 @@ func assertContains(t *testing.T, haystack, needle string) {
 		t.Errorf("expected output to contain %q", needle)
 	}
 }
+
+func BenchmarkGenerateWideSlice(b *testing.B) {
+	var sb strings.Builder
+	sb.WriteString("slices:\n  wide:\n")
+	for i := 0; i < 500; i++ {
+		fmt.Fprintf(&sb, "    - c: Command%d\n    - e: Event%d\n", i, i)
+	}
+	doc, err := parser.Parse(strings.NewReader(sb.String()))
+	if err != nil {
+		b.Fatalf("parse error: %v", err)
+	}
+	gen := New()
+	gen.MaxColumns = 0
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := gen.Generate(doc); err != nil {
+			b.Fatalf("generate error: %v", err)
+		}
+	}
+}
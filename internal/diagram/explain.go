@@ -0,0 +1,134 @@
+package diagram
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/emlang-project/emlang/internal/ast"
+)
+
+// Explain describes, instead of rendering it, the layout Generate would
+// produce for doc: for each document, its columns, the rows it renders
+// and why, and the rows it leaves out and why.
+func (g *Generator) Explain(doc *ast.Document) string {
+	hash := contentHash(doc.RawSource)
+
+	var b strings.Builder
+	for i, sd := range doc.SubDocs {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		l := computeLayout(sd, g.SeparateExceptionRow, g.TestsOnly)
+		data := g.buildDocumentData(hash, i, sd)
+
+		fmt.Fprintf(&b, "document %d (%s)\n", i+1, data.ID)
+
+		if l.hasSwimlanes {
+			fmt.Fprintf(&b, "  columns: %d (1 swimlane label column, because elements use swimlanes)\n", l.totalColumns)
+		} else {
+			fmt.Fprintf(&b, "  columns: %d\n", l.totalColumns)
+		}
+
+		b.WriteString("  slices:\n")
+		for _, name := range l.sliceOrder {
+			start, width := l.sliceStartCol[name], l.sliceWidths[name]
+			fmt.Fprintf(&b, "    %s: %s\n", name, columnRange(start, width))
+		}
+
+		if len(l.sections) > 0 {
+			b.WriteString("  group row: the document defines groups\n")
+		}
+
+		b.WriteString("  rows:\n")
+		rendered := map[string]bool{}
+		for _, row := range data.Rows {
+			rendered[row.Class] = true
+			fmt.Fprintf(&b, "    %s: %s\n", row.Label, g.rowReason(row, sd))
+		}
+
+		if omitted := g.omittedRows(l, sd, rendered); len(omitted) > 0 {
+			b.WriteString("  omitted:\n")
+			for _, o := range omitted {
+				fmt.Fprintf(&b, "    %s\n", o)
+			}
+		}
+	}
+	return b.String()
+}
+
+// columnRange formats the grid columns a slice spans, e.g. "columns 2-4".
+func columnRange(start, width int) string {
+	if width == 1 {
+		return fmt.Sprintf("column %d", start)
+	}
+	return fmt.Sprintf("columns %d-%d", start, start+width-1)
+}
+
+// rowReason explains why a rendered row is present.
+func (g *Generator) rowReason(row rowData, sd *ast.SubDoc) string {
+	lane := "without a swimlane"
+	if row.Swimlane != "" {
+		lane = fmt.Sprintf("in swimlane %q", row.Swimlane)
+	}
+	if g.EventLayout == EventsCombined && (row.Class == "emlang-row-events" || row.Class == "emlang-row-exceptions") {
+		lane = "of all swimlanes, combined in one row"
+	}
+	switch row.Class {
+	case "emlang-row-triggers":
+		return "triggers " + lane
+	case "emlang-row-main":
+		return "commands and views share one row"
+	case "emlang-row-projections":
+		return "projections"
+	case "emlang-row-events":
+		if g.SeparateExceptionRow {
+			return "events " + lane
+		}
+		return "events and exceptions " + lane
+	case "emlang-row-exceptions":
+		return "exceptions " + lane + ", separated from events"
+	case "emlang-row-timeline":
+		return plural(len(row.Connectors), "connector") + " between adjacent slices"
+	case "emlang-row-tests":
+		n := 0
+		for _, slice := range sd.Slices {
+			if len(slice.Tests) > 0 {
+				n++
+			}
+		}
+		return fmt.Sprintf("tests of %s", plural(n, "slice"))
+	}
+	return ""
+}
+
+// omittedRows explains why rows that could appear are left out.
+func (g *Generator) omittedRows(l *layout, sd *ast.SubDoc, rendered map[string]bool) []string {
+	if g.TestsOnly {
+		omitted := []string{"element rows: tests-only mode"}
+		if !rendered["emlang-row-tests"] {
+			omitted = append(omitted, "tests: no slice has tests")
+		}
+		return omitted
+	}
+
+	var omitted []string
+	if len(l.triggerLanes) == 0 {
+		omitted = append(omitted, "triggers: no triggers")
+	}
+	if !l.hasMainRow {
+		omitted = append(omitted, "commands and views: no commands or views")
+	}
+	if !l.hasProjRow {
+		omitted = append(omitted, "projections: no projections")
+	}
+	if len(l.eventLanes) == 0 {
+		omitted = append(omitted, "events: no events")
+	}
+	if g.Timeline && !rendered["emlang-row-timeline"] {
+		omitted = append(omitted, "timeline: no adjacent slices share an event name")
+	}
+	if !rendered["emlang-row-tests"] {
+		omitted = append(omitted, "tests: no slice has tests")
+	}
+	return omitted
+}
@@ -0,0 +1,73 @@
+package diagram
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/emlang-project/emlang/internal/ast"
+)
+
+type outlineDocument struct {
+	Title  string
+	Slices []*outlineSlice
+}
+
+type outlineSlice struct {
+	Name  string
+	Steps []string
+	Tests []*outlineTest
+}
+
+type outlineTest struct {
+	Name     string
+	Elements []string // e.g. "when Command: PlaceOrder"
+}
+
+// Outline renders doc as nested <details> elements, documents containing
+// slices containing their steps and tests, for scanning the structure of
+// large files in a browser. Like Generate, it returns an HTML fragment.
+func Outline(doc *ast.Document) ([]byte, error) {
+	var docs []*outlineDocument
+	var slice *outlineSlice
+	var test *outlineTest
+
+	ast.Walk(doc, ast.Visitor{
+		SubDoc: func(sd *ast.SubDoc) {
+			title := sd.Title
+			if title == "" {
+				title = fmt.Sprintf("Document %d", len(docs)+1)
+			}
+			docs = append(docs, &outlineDocument{Title: title})
+		},
+		Slice: func(s *ast.Slice) {
+			name := s.Name
+			if name == "" {
+				name = "(anonymous)"
+			}
+			slice = &outlineSlice{Name: name}
+			d := docs[len(docs)-1]
+			d.Slices = append(d.Slices, slice)
+		},
+		Test: func(_ *ast.Slice, t *ast.Test) {
+			test = &outlineTest{Name: t.Name}
+			slice.Tests = append(slice.Tests, test)
+		},
+		Element: func(elem *ast.Element, at ast.ElementContext) {
+			label := elementLabel(elem)
+			if elem.Swimlane != "" {
+				label += " (" + elem.Swimlane + ")"
+			}
+			if at.Test == nil {
+				slice.Steps = append(slice.Steps, label)
+				return
+			}
+			test.Elements = append(test.Elements, at.Section+" "+label)
+		},
+	})
+
+	var buf bytes.Buffer
+	if err := tmpl.ExecuteTemplate(&buf, "outline", docs); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,62 @@
+package diagram
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"os"
+)
+
+// Page renders generated diagrams as complete HTML pages from a custom
+// template, for sites that embed diagrams in their own layout.
+// A nil *Page renders the built-in page, see Standalone.
+type Page struct {
+	tmpl *template.Template
+}
+
+// PageData is the data a page template is executed with.
+type PageData struct {
+	Title      string        // page title, e.g. the source file name
+	Body       template.HTML // the generated diagram
+	PollScript template.HTML // live-reload script when served, empty otherwise
+}
+
+// ParsePage parses text as an html/template executed with PageData.
+func ParsePage(text string) (*Page, error) {
+	tmpl, err := template.New("page").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	return &Page{tmpl: tmpl}, nil
+}
+
+// ReadPage parses the page template in the file at path.
+func ReadPage(path string) (*Page, error) {
+	text, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading page template: %w", err)
+	}
+	page, err := ParsePage(string(text))
+	if err != nil {
+		return nil, fmt.Errorf("parsing page template %s: %w", path, err)
+	}
+	return page, nil
+}
+
+// Render wraps a generated diagram in a page with the given title and
+// live-reload script, which may be empty.
+func (p *Page) Render(fragment []byte, title, pollScript string) ([]byte, error) {
+	if p == nil {
+		return Standalone(fragment, title, pollScript), nil
+	}
+	var buf bytes.Buffer
+	err := p.tmpl.Execute(&buf, PageData{
+		Title:      title,
+		Body:       template.HTML(fragment),
+		PollScript: template.HTML(pollScript),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("page template: %w", err)
+	}
+	return buf.Bytes(), nil
+}
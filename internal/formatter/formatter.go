@@ -4,32 +4,40 @@ import (
 	"bytes"
 	"fmt"
 	"sort"
+	"strings"
 
 	"github.com/emlang-project/emlang/internal/ast"
+	"gopkg.in/yaml.v3"
 )
 
 // Options controls formatting behaviour.
 type Options struct {
-	KeyStyle string // "short" or "long" (default "short")
+	KeyStyle        string // "short", "long" or "keep" (default "short")
+	PreserveAliases bool   // re-emit YAML anchors/aliases recorded on elements
+	SortSlices      bool   // emit slices alphabetically within each sub-document
+	SortProps       bool   // emit each element's props sorted by key
+
+	// NormalizeSwimlanes emits swimlane paths canonically: spaces around
+	// slashes trimmed and repeated slashes collapsed, so "A / B" and "A//B"
+	// both become "A/B".
+	NormalizeSwimlanes bool
+
+	// DirectForm emits slices without tests in direct form even when they
+	// were written in extended form (steps: only).
+	DirectForm bool
 }
 
-// typeKey returns the YAML key for an element type based on key style.
-func typeKey(t ast.ElementType, style string) string {
-	if style == "short" {
-		switch t {
-		case ast.ElementTrigger:
-			return "t"
-		case ast.ElementCommand:
-			return "c"
-		case ast.ElementEvent:
-			return "e"
-		case ast.ElementException:
-			return "x"
-		case ast.ElementView:
-			return "v"
-		}
+// typeKey returns the YAML key for an element based on key style.
+// The "keep" style reuses the key the element was written with, falling
+// back to the long form for elements built without a source.
+func typeKey(elem *ast.Element, style string) string {
+	switch {
+	case style == "short":
+		return elem.Type.Short()
+	case style == "keep" && elem.RawKey != "":
+		return elem.RawKey
 	}
-	return t.String()
+	return elem.Type.String()
 }
 
 // Format renders the AST document as canonical YAML.
@@ -39,21 +47,45 @@ func Format(doc *ast.Document, opts Options) []byte {
 	}
 
 	var buf bytes.Buffer
-	w := &writer{buf: &buf, style: opts.KeyStyle}
+	w := &writer{
+		buf:             &buf,
+		style:           opts.KeyStyle,
+		preserveAliases: opts.PreserveAliases,
+		sortSlices:      opts.SortSlices,
+		sortProps:       opts.SortProps,
+		normalizeLanes:  opts.NormalizeSwimlanes,
+		directForm:      opts.DirectForm,
+	}
 
 	for i, sd := range doc.SubDocs {
 		if i > 0 {
 			w.raw("---\n")
 		}
+		// Anchors are scoped to their YAML document.
+		w.anchors = map[string]bool{}
 		w.writeSubDoc(sd)
 	}
 
 	return buf.Bytes()
 }
 
+// Canonical renders doc in a fully normalized form suitable for golden
+// files: long keys, aliases expanded, swimlanes normalized, slices
+// without tests in direct form, and slices and props sorted, so that two
+// semantically equal documents render identically.
+func Canonical(doc *ast.Document) []byte {
+	return Format(doc, Options{KeyStyle: "long", SortSlices: true, SortProps: true, NormalizeSwimlanes: true, DirectForm: true})
+}
+
 type writer struct {
-	buf   *bytes.Buffer
-	style string
+	buf             *bytes.Buffer
+	style           string
+	preserveAliases bool
+	sortSlices      bool
+	sortProps       bool
+	normalizeLanes  bool
+	directForm      bool
+	anchors         map[string]bool // anchors already emitted
 }
 
 func (w *writer) raw(s string) {
@@ -73,30 +105,91 @@ func (w *writer) line(level int, s string) {
 }
 
 func (w *writer) writeSubDoc(sd *ast.SubDoc) {
+	if sd.Title != "" {
+		w.raw(fmt.Sprintf("title: %s\n", formatScalar(sd.Title)))
+	}
 	w.raw("slices:\n")
 
-	for _, name := range sd.SliceOrder {
+	order := sd.SliceOrder
+	if w.sortSlices {
+		order = append([]string(nil), order...)
+		sort.Strings(order)
+	}
+
+	for _, name := range order {
 		slice := sd.Slices[name]
 		w.writeSlice(name, slice)
 	}
+
+	if len(sd.Groups) > 0 {
+		w.raw("groups:\n")
+		for _, group := range sd.Groups {
+			w.line(1, fmt.Sprintf("%s:", group.Name))
+			for _, name := range group.Slices {
+				w.line(2, "- "+name)
+			}
+		}
+	}
+
+	if len(sd.GivenTemplates) > 0 {
+		w.raw("given_templates:\n")
+		for _, t := range sd.GivenTemplates {
+			w.line(1, fmt.Sprintf("%s:", t.Name))
+			w.writeElementList(2, t.Elements)
+		}
+	}
+
+	if len(sd.CSS) > 0 {
+		w.raw("css:\n")
+		for _, v := range sd.CSS {
+			w.line(1, fmt.Sprintf("%s: %q", v.Name, v.Value))
+		}
+	}
 }
 
 func (w *writer) writeSlice(name string, slice *ast.Slice) {
 	w.line(1, fmt.Sprintf("%s:", name))
 
 	hasTests := len(slice.Tests) > 0
+	extended := (slice.Extended && !w.directForm) || len(slice.Props) > 0
 
-	if hasTests {
-		// Extended form: steps + tests
-		if len(slice.Elements) > 0 {
+	if hasTests || extended {
+		// Extended form: props + steps + tests
+		if len(slice.Props) > 0 {
+			w.line(2, "props:")
+			w.writeProps(3, slice.Props)
+		}
+		if len(slice.Elements) > 0 || extended {
 			w.line(2, "steps:")
-			w.writeElementList(3, slice.Elements)
+			w.writeSteps(3, slice.Elements)
+		}
+		if hasTests {
+			w.line(2, "tests:")
+			w.writeTests(slice.Tests)
 		}
-		w.line(2, "tests:")
-		w.writeTests(slice.Tests)
 	} else {
 		// Direct form: list of elements
-		w.writeElementList(2, slice.Elements)
+		w.writeSteps(2, slice.Elements)
+	}
+}
+
+// writeSteps writes slice steps, regrouping the elements of each alt
+// construct under its branches.
+func (w *writer) writeSteps(level int, elems []*ast.Element) {
+	for i := 0; i < len(elems); i++ {
+		alt := elems[i].Alt
+		if alt == nil {
+			w.writeElement(level, elems[i])
+			continue
+		}
+
+		w.line(level, "- alt:")
+		for _, branch := range alt.Branches {
+			w.line(level+2, fmt.Sprintf("%s:", branch.Name))
+			w.writeElementList(level+3, branch.Elements)
+			i += len(branch.Elements)
+		}
+		i--
 	}
 }
 
@@ -111,29 +204,112 @@ func (w *writer) writeElement(level int, elem *ast.Element) {
 	if elem.Swimlane != "" {
 		name = elem.Swimlane + "/" + name
 	}
+	if w.normalizeLanes {
+		name = normalizeSwimlane(name)
+	}
 
-	key := typeKey(elem.Type, w.style)
+	key := typeKey(elem, w.style)
 
-	if len(elem.Props) == 0 {
+	if anchor := w.anchorFor(elem); anchor != "" {
+		// The first occurrence in output order defines the anchor,
+		// later ones refer to it, whether they are the original or an
+		// alias.
+		if w.anchors[anchor] {
+			w.line(level, "- *"+anchor)
+			return
+		}
+		w.anchors[anchor] = true
+		w.line(level, "- &"+anchor)
+		w.line(level+1, fmt.Sprintf("%s: %s", key, name))
+	} else {
 		w.indent(level)
 		w.raw(fmt.Sprintf("- %s: %s\n", key, name))
+	}
+
+	if len(elem.Props) == 0 {
 		return
 	}
 
-	w.indent(level)
-	w.raw(fmt.Sprintf("- %s: %s\n", key, name))
 	w.indent(level + 1)
 	w.raw("props:\n")
 	w.writeProps(level+2, elem.Props)
 }
 
+// anchorFor returns the anchor name to preserve for an element, if any.
+func (w *writer) anchorFor(elem *ast.Element) string {
+	if !w.preserveAliases {
+		return ""
+	}
+	if elem.Alias != "" {
+		return elem.Alias
+	}
+	return elem.Anchor
+}
+
 func (w *writer) writeProps(level int, props []ast.PropEntry) {
+	if w.sortProps {
+		props = append([]ast.PropEntry(nil), props...)
+		sort.SliceStable(props, func(i, j int) bool { return props[i].Key < props[j].Key })
+	}
 	for _, p := range props {
+		if s, ok := p.Value.(string); ok && strings.Contains(s, "\n") {
+			w.writeBlockScalar(level, p.Key, s)
+			continue
+		}
 		w.indent(level)
 		w.raw(fmt.Sprintf("%s: %s\n", p.Key, formatValue(p.Value)))
 	}
 }
 
+// writeBlockScalar writes a multi-line string value as a literal block scalar.
+// The chomping indicator keeps the trailing newlines as they are: strip
+// (|-) for none, clip (|) for one and keep (|+) for more. When the first
+// non-empty line starts with a space, an indentation indicator gives the
+// content indentation, which YAML would otherwise take from that line.
+func (w *writer) writeBlockScalar(level int, key, value string) {
+	indicator := "|"
+	if strings.HasPrefix(strings.TrimLeft(value, "\n"), " ") {
+		indicator += "2"
+	}
+	switch {
+	case strings.HasSuffix(value, "\n\n"):
+		indicator += "+"
+	case !strings.HasSuffix(value, "\n"):
+		indicator += "-"
+	}
+	value = strings.TrimSuffix(value, "\n")
+	w.line(level, fmt.Sprintf("%s: %s", key, indicator))
+	for _, l := range strings.Split(value, "\n") {
+		if l == "" {
+			w.raw("\n")
+			continue
+		}
+		w.line(level+1, l)
+	}
+}
+
+// normalizeSwimlane trims the segments of a slash-separated element name
+// and drops empty ones, e.g. "A / B" and "A//B" become "A/B".
+func normalizeSwimlane(name string) string {
+	var parts []string
+	for _, part := range strings.Split(name, "/") {
+		if part = strings.TrimSpace(part); part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+// formatScalar renders s as a YAML scalar, plain unless YAML needs it
+// quoted, e.g. "a: b" or "true".
+func formatScalar(s string) string {
+	out, err := yaml.Marshal(s)
+	if err != nil {
+		return fmt.Sprintf("%q", s)
+	}
+	return strings.TrimSuffix(string(out), "\n")
+}
+
 func formatValue(v interface{}) string {
 	switch val := v.(type) {
 	case string:
@@ -172,8 +348,16 @@ func (w *writer) writeTests(tests map[string]*ast.Test) {
 func (w *writer) writeTest(name string, test *ast.Test) {
 	w.line(3, fmt.Sprintf("%s:", name))
 
+	if len(test.Props) > 0 {
+		w.line(4, "props:")
+		w.writeProps(5, test.Props)
+	}
+
 	if test.HasGiven {
-		if len(test.Given) == 0 {
+		if test.GivenTemplate != "" {
+			w.line(4, "given:")
+			w.line(5, "use: "+test.GivenTemplate)
+		} else if len(test.Given) == 0 {
 			w.line(4, "given:")
 		} else {
 			w.line(4, "given:")
@@ -198,4 +382,9 @@ func (w *writer) writeTest(name string, test *ast.Test) {
 			w.writeElementList(5, test.Then)
 		}
 	}
+
+	if test.HasThenNot {
+		w.line(4, "then-not:")
+		w.writeElementList(5, test.ThenNot)
+	}
 }
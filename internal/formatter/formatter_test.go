@@ -1,6 +1,7 @@
 package formatter
 
 import (
+	"strconv"
 	"strings"
 	"testing"
 
@@ -296,3 +297,681 @@ func TestMediumAliases_NormalizedToLong(t *testing.T) {
 		t.Errorf("medium alias normalization:\ngot:\n%s\nwant:\n%s", out, expected)
 	}
 }
+
+func TestPreserveAliases_Roundtrip(t *testing.T) {
+	input := `slices:
+  Payment:
+    steps:
+      - &charge
+        command: ChargeCard
+        props:
+          amount: number
+      - event: CardCharged
+    tests:
+      charges-card:
+        when:
+          - *charge
+        then:
+          - event: CardCharged
+      retries-card:
+        when:
+          - *charge
+        then:
+          - exception: CardDeclined
+`
+
+	doc, err := parser.Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+
+	out := string(Format(doc, Options{KeyStyle: "long", PreserveAliases: true}))
+
+	if out != input {
+		t.Errorf("preserve aliases:\ngot:\n%s\nwant:\n%s", out, input)
+	}
+
+	// Without the option aliases are expanded inline.
+	expanded := string(Format(doc, Options{KeyStyle: "long"}))
+	if strings.Contains(expanded, "*charge") || strings.Contains(expanded, "&charge") {
+		t.Errorf("expected aliases to be expanded, got:\n%s", expanded)
+	}
+	if strings.Count(expanded, "command: ChargeCard") != 3 {
+		t.Errorf("expected 3 expanded commands, got:\n%s", expanded)
+	}
+}
+
+func TestPreserveAliases_AliasBeforeAnchorInOutput(t *testing.T) {
+	// Tests are emitted sorted by name, so the alias in "a-test" is
+	// written before the anchored element in "b-test".
+	input := `slices:
+  s:
+    steps:
+      - command: Foo
+    tests:
+      b-test:
+        given:
+          - &seed
+            event: Seeded
+      a-test:
+        given:
+          - *seed
+`
+
+	doc, err := parser.Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+
+	out := string(Format(doc, Options{KeyStyle: "long", PreserveAliases: true}))
+
+	if strings.Index(out, "&seed") > strings.Index(out, "*seed") && strings.Contains(out, "*seed") {
+		t.Fatalf("alias emitted before anchor:\n%s", out)
+	}
+	doc2, err := parser.Parse(strings.NewReader(out))
+	if err != nil {
+		t.Fatalf("re-parse: %v\n%s", err, out)
+	}
+	out2 := string(Format(doc2, Options{KeyStyle: "long", PreserveAliases: true}))
+	if out != out2 {
+		t.Errorf("roundtrip mismatch:\nfirst:\n%s\nsecond:\n%s", out, out2)
+	}
+}
+
+func TestPreserveAliases_SortedSlicesAliasFirst(t *testing.T) {
+	// Sorting puts the alias in slice "a" before its anchor in slice "b".
+	input := `slices:
+  b:
+    - &pay
+      command: Pay
+      props:
+        amount: number
+    - event: Paid
+  a:
+    - *pay
+    - event: Paid
+`
+
+	doc, err := parser.Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+
+	opts := Options{KeyStyle: "long", PreserveAliases: true, SortSlices: true}
+	out := string(Format(doc, opts))
+
+	expected := `slices:
+  a:
+    - &pay
+      command: Pay
+      props:
+        amount: number
+    - event: Paid
+  b:
+    - *pay
+    - event: Paid
+`
+	if out != expected {
+		t.Fatalf("sorted aliases:\ngot:\n%s\nwant:\n%s", out, expected)
+	}
+
+	doc2, err := parser.Parse(strings.NewReader(out))
+	if err != nil {
+		t.Fatalf("re-parse: %v\n%s", err, out)
+	}
+	if out2 := string(Format(doc2, opts)); out2 != out {
+		t.Errorf("roundtrip mismatch:\nfirst:\n%s\nsecond:\n%s", out, out2)
+	}
+}
+
+func TestPreserveAliases_AnchorPerDocument(t *testing.T) {
+	input := `slices:
+  orders:
+    - &x
+      e: Placed
+    - *x
+---
+slices:
+  shipping:
+    - &x
+      e: Shipped
+    - *x
+`
+
+	doc, err := parser.Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+
+	opts := Options{PreserveAliases: true}
+	out := string(Format(doc, opts))
+
+	expected := `slices:
+  orders:
+    - &x
+      e: Placed
+    - *x
+---
+slices:
+  shipping:
+    - &x
+      e: Shipped
+    - *x
+`
+	if out != expected {
+		t.Fatalf("anchors per document:\ngot:\n%s\nwant:\n%s", out, expected)
+	}
+
+	doc2, err := parser.Parse(strings.NewReader(out))
+	if err != nil {
+		t.Fatalf("re-parse: %v\n%s", err, out)
+	}
+	if name := doc2.SubDocs[1].Slices["shipping"].Elements[1].Name; name != "Shipped" {
+		t.Errorf("expected the second document's alias to stay Shipped, got %q", name)
+	}
+}
+
+func TestProjectionKeys(t *testing.T) {
+	input := `slices:
+  s:
+    - proj: Projector
+`
+
+	doc, err := parser.Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+
+	if out := string(Format(doc, Options{KeyStyle: "long"})); !strings.Contains(out, "- projection: Projector") {
+		t.Errorf("expected long projection key, got:\n%s", out)
+	}
+	if out := string(Format(doc, Options{KeyStyle: "short"})); !strings.Contains(out, "- p: Projector") {
+		t.Errorf("expected short projection key, got:\n%s", out)
+	}
+}
+
+func TestRoundtrip_ThenNot(t *testing.T) {
+	input := `slices:
+  Payment:
+    steps:
+      - command: ProcessPayment
+      - event: PaymentProcessed
+    tests:
+      no-double-charge:
+        given:
+          - event: PaymentProcessed
+        when:
+          - command: ProcessPayment
+        then-not:
+          - event: PaymentProcessed
+`
+
+	doc, err := parser.Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+
+	out := string(Format(doc, Options{KeyStyle: "long"}))
+	if out != input {
+		t.Errorf("then-not roundtrip:\ngot:\n%s\nwant:\n%s", out, input)
+	}
+}
+
+func TestRoundtrip_Groups(t *testing.T) {
+	input := `slices:
+  Register:
+    - command: Register
+  Pay:
+    - command: Pay
+groups:
+  Onboarding:
+    - Register
+  Billing:
+    - Pay
+`
+
+	doc, err := parser.Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+
+	out := string(Format(doc, Options{KeyStyle: "long"}))
+	if out != input {
+		t.Errorf("groups roundtrip:\ngot:\n%s\nwant:\n%s", out, input)
+	}
+}
+
+func TestRoundtrip_MultilineProp(t *testing.T) {
+	input := `slices:
+  Checkout:
+    - command: PlaceOrder
+      props:
+        label: |-
+          Place the
+
+          order
+        note: |
+          trailing newline
+        total: number
+`
+
+	doc, err := parser.Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+
+	out := string(Format(doc, Options{KeyStyle: "long"}))
+	if out != input {
+		t.Errorf("multi-line prop roundtrip:\ngot:\n%s\nwant:\n%s", out, input)
+	}
+}
+
+func TestRoundtrip_MultilinePropIndicators(t *testing.T) {
+	tests := []struct {
+		name   string
+		value  string
+		header string
+	}{
+		{"trailing blank lines", "two trailing\nnewlines\n\n", "note: |+"},
+		{"leading spaces", "  indented first\nline", "note: |2-"},
+		{"leading spaces after blank line", "\n  indented\n", "note: |2"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			input := "slices:\n  s:\n    - c: A\n      props:\n        note: " + strconv.Quote(tc.value) + "\n"
+			doc, err := parser.Parse(strings.NewReader(input))
+			if err != nil {
+				t.Fatalf("parse: %v", err)
+			}
+
+			out := string(Format(doc, Options{}))
+			if !strings.Contains(out, tc.header+"\n") {
+				t.Errorf("expected %q, got:\n%s", tc.header, out)
+			}
+
+			reparsed, err := parser.Parse(strings.NewReader(out))
+			if err != nil {
+				t.Fatalf("re-parse: %v\n%s", err, out)
+			}
+			got := reparsed.SubDocs[0].Slices["s"].Elements[0].Props[0].Value
+			if got != tc.value {
+				t.Errorf("value changed by roundtrip: got %q, want %q\n%s", got, tc.value, out)
+			}
+			if again := string(Format(reparsed, Options{})); again != out {
+				t.Errorf("not idempotent:\nfirst:\n%s\nsecond:\n%s", out, again)
+			}
+		})
+	}
+}
+
+func TestRoundtrip_Alt(t *testing.T) {
+	input := `slices:
+  Checkout:
+    steps:
+      - command: PlaceOrder
+      - alt:
+          accepted:
+            - event: OrderPlaced
+          rejected:
+            - exception: OrderRejected
+            - event: CustomerNotified
+      - view: Orders
+    tests:
+      placed:
+        when:
+          - command: PlaceOrder
+        then:
+          - event: OrderPlaced
+`
+
+	doc, err := parser.Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+
+	out := string(Format(doc, Options{KeyStyle: "long"}))
+	if out != input {
+		t.Errorf("alt roundtrip:\ngot:\n%s\nwant:\n%s", out, input)
+	}
+}
+
+func TestRoundtrip_DocumentCSS(t *testing.T) {
+	input := `slices:
+  Current:
+    - command: PlaceOrder
+---
+slices:
+  Proposed:
+    - command: PlaceOrder
+css:
+  --command-color: "#ff0000"
+  --item-border-radius: "0"
+`
+
+	doc, err := parser.Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+
+	out := string(Format(doc, Options{KeyStyle: "long"}))
+	if out != input {
+		t.Errorf("css roundtrip:\ngot:\n%s\nwant:\n%s", out, input)
+	}
+}
+
+func TestRoundtrip_DocumentTitle(t *testing.T) {
+	input := `title: 'Current: v1'
+slices:
+  Current:
+    - command: PlaceOrder
+---
+title: Proposed
+slices:
+  Proposed:
+    - command: PlaceOrder
+---
+title: Café ☕
+slices:
+  Later:
+    - command: PlaceOrder
+`
+
+	doc, err := parser.Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+
+	out := string(Format(doc, Options{KeyStyle: "long"}))
+	if out != input {
+		t.Errorf("title roundtrip:\ngot:\n%s\nwant:\n%s", out, input)
+	}
+}
+
+func TestRoundtrip_SliceAndTestProps(t *testing.T) {
+	input := `slices:
+  checkout:
+    props:
+      ticket: SHOP-142
+      team: payments
+    steps:
+      - command: PlaceOrder
+      - event: OrderPlaced
+    tests:
+      places-order:
+        props:
+          ticket: SHOP-150
+        when:
+          - command: PlaceOrder
+        then:
+          - event: OrderPlaced
+`
+
+	doc, err := parser.Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+
+	out := string(Format(doc, Options{KeyStyle: "long"}))
+	if out != input {
+		t.Errorf("props roundtrip:\ngot:\n%s\nwant:\n%s", out, input)
+	}
+
+	// Direct form cannot carry slice props, so the slice stays extended
+	canonical := string(Canonical(doc))
+	if !strings.Contains(canonical, "  checkout:\n    props:\n      team: payments\n      ticket: SHOP-142\n    steps:\n") {
+		t.Errorf("expected sorted slice props in canonical form, got:\n%s", canonical)
+	}
+}
+
+func TestRoundtrip_GivenTemplates(t *testing.T) {
+	input := `slices:
+  Checkout:
+    steps:
+      - command: PlaceOrder
+      - event: OrderPlaced
+    tests:
+      places-order:
+        given:
+          use: CartWithItem
+        when:
+          - command: PlaceOrder
+        then:
+          - event: OrderPlaced
+given_templates:
+  CartWithItem:
+    - event: CartCreated
+    - event: ItemAdded
+`
+
+	doc, err := parser.Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+
+	out := string(Format(doc, Options{KeyStyle: "long"}))
+	if out != input {
+		t.Errorf("given templates roundtrip:\ngot:\n%s\nwant:\n%s", out, input)
+	}
+}
+
+func TestInlinePropsFormatNested(t *testing.T) {
+	input := `slices:
+  signup:
+    - c: "CreateUser {email: string, age: number}"
+`
+	doc, err := parser.Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+
+	want := `slices:
+  signup:
+    - c: CreateUser
+      props:
+        email: string
+        age: number
+`
+	if got := string(Format(doc, Options{KeyStyle: "short"})); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestCanonical(t *testing.T) {
+	a := `slices:
+  shipping:
+    - c: ShipOrder
+      props:
+        priority: high
+        carrier: ups
+    - e: Shipped
+  checkout:
+    - c: PlaceOrder
+    - e: OrderPlaced
+`
+	b := `slices:
+  checkout:
+    - command: PlaceOrder
+    - event: OrderPlaced
+  shipping:
+    - cmd: ShipOrder
+      props:
+        carrier: ups
+        priority: high
+    - evt: Shipped
+`
+	docA, err := parser.Parse(strings.NewReader(a))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	docB, err := parser.Parse(strings.NewReader(b))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+
+	out := Canonical(docA)
+	if string(out) != string(Canonical(docB)) {
+		t.Errorf("expected equal canonical forms:\n%s\n%s", out, Canonical(docB))
+	}
+
+	want := `slices:
+  checkout:
+    - command: PlaceOrder
+    - event: OrderPlaced
+  shipping:
+    - command: ShipOrder
+      props:
+        carrier: ups
+        priority: high
+    - event: Shipped
+`
+	if string(out) != want {
+		t.Errorf("got:\n%s\nwant:\n%s", out, want)
+	}
+
+	doc2, err := parser.Parse(strings.NewReader(string(out)))
+	if err != nil {
+		t.Fatalf("re-parse: %v", err)
+	}
+	if out2 := Canonical(doc2); string(out2) != string(out) {
+		t.Errorf("canonical form is not idempotent:\nfirst:\n%s\nsecond:\n%s", out, out2)
+	}
+}
+
+func TestNormalizeSwimlanes(t *testing.T) {
+	input := `slices:
+  a:
+    - t: User//ClickRegister
+    - c: User / Register
+    - e: Accounts/Registered
+`
+	doc, err := parser.Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+
+	want := `slices:
+  a:
+    - t: User/ClickRegister
+    - c: User/Register
+    - e: Accounts/Registered
+`
+	out := Format(doc, Options{KeyStyle: "short", NormalizeSwimlanes: true})
+	if string(out) != want {
+		t.Errorf("got:\n%s\nwant:\n%s", out, want)
+	}
+
+	plain := Format(doc, Options{KeyStyle: "short"})
+	if !strings.Contains(string(plain), "- t: User//ClickRegister") {
+		t.Errorf("expected names unchanged without the option, got:\n%s", plain)
+	}
+}
+
+func TestFormatKeepKeys(t *testing.T) {
+	input := `slices:
+  register:
+    steps:
+      - t: Customer/Form
+      - command: Register
+        props:
+          email: string
+      - evt: Registered
+    tests:
+      registers:
+        given:
+          - view: NoCustomers
+        when:
+          - c: Register
+        then:
+          - event: Registered
+`
+	doc, err := parser.Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	out := string(Format(doc, Options{KeyStyle: "keep"}))
+	if out != input {
+		t.Errorf("expected mixed key styles to round-trip:\ngot:\n%s\nwant:\n%s", out, input)
+	}
+}
+
+func TestFormatKeepsExtendedFormWithoutTests(t *testing.T) {
+	input := `slices:
+  SliceWithSteps:
+    steps:
+      - command: DoSomething
+      - event: SomethingDone
+  Direct:
+    - command: DoOther
+`
+	doc, err := parser.Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	out := string(Format(doc, Options{KeyStyle: "long"}))
+	if out != input {
+		t.Errorf("expected slice forms to round-trip:\ngot:\n%s\nwant:\n%s", out, input)
+	}
+
+	if strings.Contains(string(Canonical(doc)), "steps:") {
+		t.Error("expected canonical output to use direct form for slices without tests")
+	}
+}
+
+func TestSortSlices(t *testing.T) {
+	input := `slices:
+  Shipping:
+    - command: ShipOrder
+  Billing:
+    - command: ChargeCard
+  Checkout:
+    - command: PlaceOrder
+---
+slices:
+  Zeta:
+    - event: Z
+  Alpha:
+    - event: A
+`
+
+	doc, err := parser.Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+
+	out := string(Format(doc, Options{KeyStyle: "long", SortSlices: true}))
+
+	expected := `slices:
+  Billing:
+    - command: ChargeCard
+  Checkout:
+    - command: PlaceOrder
+  Shipping:
+    - command: ShipOrder
+---
+slices:
+  Alpha:
+    - event: A
+  Zeta:
+    - event: Z
+`
+	if out != expected {
+		t.Errorf("sorted slices:\ngot:\n%s\nwant:\n%s", out, expected)
+	}
+
+	// Sorting is idempotent
+	doc2, err := parser.Parse(strings.NewReader(out))
+	if err != nil {
+		t.Fatalf("re-parse: %v", err)
+	}
+	if out2 := string(Format(doc2, Options{KeyStyle: "long", SortSlices: true})); out2 != out {
+		t.Errorf("roundtrip mismatch:\nfirst:\n%s\nsecond:\n%s", out, out2)
+	}
+
+	// Authored order is kept by default and the AST is not mutated
+	if unsorted := string(Format(doc, Options{KeyStyle: "long"})); !strings.HasPrefix(unsorted, "slices:\n  Shipping:") {
+		t.Errorf("expected authored order by default, got:\n%s", unsorted)
+	}
+}
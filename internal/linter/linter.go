@@ -1,7 +1,10 @@
 package linter
 
 import (
+	"context"
+	"crypto/sha256"
 	"fmt"
+	"strings"
 
 	"github.com/emlang-project/emlang/internal/ast"
 )
@@ -25,6 +28,51 @@ func (s Severity) String() string {
 	}
 }
 
+// ParseSeverity returns the severity named s, "warning" or "error".
+func ParseSeverity(s string) (Severity, bool) {
+	switch s {
+	case "warning":
+		return SeverityWarning, true
+	case "error":
+		return SeverityError, true
+	}
+	return SeverityWarning, false
+}
+
+// Rules lists the identifiers of all rules the linter can report.
+var Rules = []string{
+	"command-without-event",
+	"orphan-exception",
+	"slice-missing-event",
+	"missing-required-prop",
+	"duplicate-slice-content",
+	"dangling-ref",
+	"test-missing-when",
+	"empty-slice-placeholder",
+	"when-command-not-in-steps",
+	"untested-exception",
+	"near-duplicate-swimlane",
+	"redundant-test",
+	"deprecated-element-referenced",
+}
+
+// OptInRules lists the rules that only report when enabled
+// through Linter.EnableRules.
+var OptInRules = map[string]bool{
+	"empty-slice-placeholder": true,
+	"redundant-test":          true,
+}
+
+// IsRule reports whether id is a known rule identifier.
+func IsRule(id string) bool {
+	for _, r := range Rules {
+		if r == id {
+			return true
+		}
+	}
+	return false
+}
+
 // Issue represents a linting issue found in the code.
 type Issue struct {
 	Rule     string
@@ -42,6 +90,10 @@ func (i Issue) String() string {
 type Linter struct {
 	issues      []Issue
 	IgnoreRules map[string]bool
+	EnableRules map[string]bool              // opt-in rules to report, see OptInRules
+	Severities  map[string]Severity          // per-rule severity overriding the rule's own
+	PropSchema  map[ast.ElementType][]string // required prop keys per element type
+	RefProps    []string                     // prop keys referencing elements, besides *_ref
 }
 
 // New creates a new Linter.
@@ -49,26 +101,215 @@ func New() *Linter {
 	return &Linter{
 		issues:      []Issue{},
 		IgnoreRules: map[string]bool{},
+		EnableRules: map[string]bool{},
+		Severities:  map[string]Severity{},
+		PropSchema:  map[ast.ElementType][]string{},
 	}
 }
 
 // Lint analyzes the given document and returns any issues found.
 func (l *Linter) Lint(doc *ast.Document) []Issue {
+	issues, _ := l.LintContext(context.Background(), doc)
+	return issues
+}
+
+// LintContext is like Lint but stops with the context's error once ctx
+// is done. Cancellation is checked between slices.
+func (l *Linter) LintContext(ctx context.Context, doc *ast.Document) ([]Issue, error) {
 	l.issues = []Issue{}
 
-	for _, sd := range doc.SubDocs {
-		for _, name := range sd.SliceOrder {
-			l.lintSlice(name, sd.Slices[name])
+	for _, slice := range doc.AllSlicesInOrder() {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		l.lintSlice(slice.Name, slice)
+		l.lintTests(slice)
+	}
+
+	l.checkDuplicateSlices(doc)
+	l.checkRefs(doc)
+	l.checkUntestedExceptions(doc)
+	l.checkSwimlaneSpelling(doc)
+	l.checkDeprecatedRefs(doc)
+
+	return l.issues, nil
+}
+
+// checkDuplicateSlices reports slices whose element sequence (types and
+// names) is identical to an earlier slice, typically a copy-paste leftover.
+func (l *Linter) checkDuplicateSlices(doc *ast.Document) {
+	seen := map[[sha256.Size]byte]string{}
+	for _, slice := range doc.AllSlicesInOrder() {
+		if len(slice.Elements) == 0 {
+			continue
+		}
+		hash := sliceContentHash(slice)
+		first, ok := seen[hash]
+		if !ok {
+			seen[hash] = slice.Name
+			continue
 		}
+		elem := slice.Elements[0]
+		l.addIssue("duplicate-slice-content",
+			fmt.Sprintf("slice %q has the same elements as slice %q", slice.Name, first),
+			elem.Line, elem.Column, SeverityWarning)
+	}
+}
+
+// sliceContentHash hashes the type and full name of each element of a slice.
+func sliceContentHash(slice *ast.Slice) [sha256.Size]byte {
+	h := sha256.New()
+	for _, elem := range slice.Elements {
+		name := elem.Name
+		if elem.Swimlane != "" {
+			name = elem.Swimlane + "/" + name
+		}
+		fmt.Fprintf(h, "%s\x00%s\x00", elem.Type, name)
+	}
+	var sum [sha256.Size]byte
+	copy(sum[:], h.Sum(nil))
+	return sum
+}
+
+// checkRefs reports reference props (see ast.IsRefProp) whose value does
+// not name any element of the document.
+func (l *Linter) checkRefs(doc *ast.Document) {
+	var elems []*ast.Element
+	for _, slice := range doc.AllSlicesInOrder() {
+		elems = append(elems, slice.Elements...)
+		for _, name := range slice.TestOrder {
+			test := slice.Tests[name]
+			elems = append(elems, test.Given...)
+			elems = append(elems, test.When...)
+			elems = append(elems, test.Then...)
+			elems = append(elems, test.ThenNot...)
+		}
+	}
+
+	exists := func(name string) bool {
+		for _, e := range elems {
+			if e.HasName(name) {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, elem := range elems {
+		for _, p := range elem.Props {
+			if !ast.IsRefProp(p.Key, l.RefProps) {
+				continue
+			}
+			target := fmt.Sprintf("%v", p.Value)
+			if !exists(target) {
+				l.addIssue("dangling-ref",
+					fmt.Sprintf("prop %q of %s %q references unknown element %q", p.Key, elem.Type, elem.Name, target),
+					p.Line, p.Column, SeverityWarning)
+			}
+		}
+	}
+}
+
+// checkUntestedExceptions reports exceptions in slice steps that no test
+// of the same slice expects in its then section. As with hasStep, an
+// unqualified name in a test matches the exception in any swimlane.
+func (l *Linter) checkUntestedExceptions(doc *ast.Document) {
+	for _, slice := range doc.AllSlicesInOrder() {
+		var tested []string
+		for _, name := range slice.TestOrder {
+			for _, elem := range slice.Tests[name].Then {
+				if elem.Type == ast.ElementException {
+					tested = append(tested, elem.QualifiedName())
+				}
+			}
+		}
+
+		for _, elem := range slice.Elements {
+			if elem.Type == ast.ElementException && !isTested(elem, tested) {
+				l.addIssue("untested-exception",
+					fmt.Sprintf("exception %q is not expected by any test of slice %q", elem.QualifiedName(), slice.Name),
+					elem.Line, elem.Column, SeverityWarning)
+			}
+		}
+	}
+}
+
+// isTested reports whether one of the names designates elem.
+func isTested(elem *ast.Element, names []string) bool {
+	for _, name := range names {
+		if elem.HasName(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkSwimlaneSpelling reports swimlanes spelled differently from an
+// earlier swimlane only by case, e.g. "customer" after "Customer", which
+// would otherwise render as two lanes. Whitespace differences are already
+// merged by ast.Element.ParseSwimlane.
+func (l *Linter) checkSwimlaneSpelling(doc *ast.Document) {
+	first := map[string]string{}
+	reported := map[string]bool{}
+	ast.Walk(doc, ast.Visitor{
+		Element: func(elem *ast.Element, _ ast.ElementContext) {
+			if elem.Swimlane == "" {
+				return
+			}
+			key := strings.ToLower(elem.Swimlane)
+			lane, ok := first[key]
+			if !ok {
+				first[key] = elem.Swimlane
+				return
+			}
+			if lane == elem.Swimlane || reported[elem.Swimlane] {
+				return
+			}
+			reported[elem.Swimlane] = true
+			l.addIssue("near-duplicate-swimlane",
+				fmt.Sprintf("swimlane %q differs from swimlane %q only by case", elem.Swimlane, lane),
+				elem.Line, elem.Column, SeverityWarning)
+		},
+	})
+}
+
+// checkDeprecatedRefs reports elements of the given, when and then
+// sections of tests that name an element marked deprecated anywhere in the
+// document, unless the test is itself deprecated.
+func (l *Linter) checkDeprecatedRefs(doc *ast.Document) {
+	type key struct {
+		typ  ast.ElementType
+		name string
 	}
+	deprecated := map[key]bool{}
+	var refs []*ast.Element
+	ast.Walk(doc, ast.Visitor{
+		Element: func(elem *ast.Element, at ast.ElementContext) {
+			if elem.Deprecated {
+				deprecated[key{elem.Type, elem.Name}] = true
+			}
+			if at.Test != nil && !at.Test.Deprecated && at.Section != "then-not" {
+				refs = append(refs, elem)
+			}
+		},
+	})
 
-	return l.issues
+	for _, elem := range refs {
+		if deprecated[key{elem.Type, elem.Name}] {
+			l.addIssue("deprecated-element-referenced",
+				fmt.Sprintf("test references deprecated %s %q", elem.Type, elem.Name),
+				elem.Line, elem.Column, SeverityWarning)
+		}
+	}
 }
 
 func (l *Linter) addIssue(rule, message string, line, column int, severity Severity) {
-	if l.IgnoreRules[rule] {
+	if l.IgnoreRules[rule] || (OptInRules[rule] && !l.EnableRules[rule]) {
 		return
 	}
+	if s, ok := l.Severities[rule]; ok {
+		severity = s
+	}
 	l.issues = append(l.issues, Issue{
 		Rule:     rule,
 		Message:  message,
@@ -81,12 +322,14 @@ func (l *Linter) addIssue(rule, message string, line, column int, severity Sever
 func (l *Linter) lintSlice(name string, slice *ast.Slice) {
 	// Empty slice is valid (placeholder)
 	if len(slice.Elements) == 0 {
+		l.addIssue("empty-slice-placeholder",
+			fmt.Sprintf("slice %q is an empty placeholder", name),
+			slice.Line, slice.Column, SeverityWarning)
 		return
 	}
 
 	// Check slice structure
 	hasEvent := false
-	hasCommandInSeq := false
 
 	for i, elem := range slice.Elements {
 		if elem.Type == ast.ElementEvent {
@@ -94,7 +337,6 @@ func (l *Linter) lintSlice(name string, slice *ast.Slice) {
 		}
 
 		if elem.Type == ast.ElementCommand {
-			hasCommandInSeq = true
 			if !l.isFollowedByEventOrException(slice.Elements, i) {
 				l.addIssue("command-without-event",
 					"command should be followed by an event or exception",
@@ -103,12 +345,14 @@ func (l *Linter) lintSlice(name string, slice *ast.Slice) {
 		}
 
 		if elem.Type == ast.ElementException {
-			if !hasCommandInSeq {
+			if !l.isPrecededByCommand(slice.Elements, i) {
 				l.addIssue("orphan-exception",
 					"exception without preceding command",
 					elem.Line, elem.Column, SeverityWarning)
 			}
 		}
+
+		l.checkRequiredProps(elem)
 	}
 
 	if !hasEvent {
@@ -119,8 +363,89 @@ func (l *Linter) lintSlice(name string, slice *ast.Slice) {
 
 }
 
+// lintTests checks the tests attached to a slice, in source order.
+func (l *Linter) lintTests(slice *ast.Slice) {
+	for _, name := range slice.TestOrder {
+		test := slice.Tests[name]
+		if (test.HasGiven || test.HasThen || test.HasThenNot) && !test.HasWhen {
+			l.addIssue("test-missing-when",
+				fmt.Sprintf("test %q has no when: nothing triggers its outcome", test.Name),
+				test.Line, test.Column, SeverityWarning)
+		}
+		for _, elem := range test.When {
+			if elem.Type == ast.ElementCommand && !hasStep(slice, elem) {
+				l.addIssue("when-command-not-in-steps",
+					fmt.Sprintf("test %q runs command %q, which is not in the slice's steps", test.Name, elem.Name),
+					elem.Line, elem.Column, SeverityWarning)
+			}
+		}
+		if isRedundantTest(slice, test) {
+			l.addIssue("redundant-test",
+				fmt.Sprintf("test %q only restates the steps of slice %q", test.Name, slice.Name),
+				test.Line, test.Column, SeverityWarning)
+		}
+	}
+}
+
+// isRedundantTest reports whether test adds nothing to the slice's steps:
+// it has no given and no then-not, runs only step commands and expects
+// only step events.
+func isRedundantTest(slice *ast.Slice, test *ast.Test) bool {
+	if len(test.Given) > 0 || len(test.ThenNot) > 0 || len(test.When) == 0 || len(test.Then) == 0 {
+		return false
+	}
+	for _, elem := range test.When {
+		if elem.Type != ast.ElementCommand || !hasStep(slice, elem) {
+			return false
+		}
+	}
+	for _, elem := range test.Then {
+		if elem.Type != ast.ElementEvent || !hasStep(slice, elem) {
+			return false
+		}
+	}
+	return true
+}
+
+// hasStep reports whether the slice's steps contain an element of the
+// type and name of elem. An element written without a swimlane matches
+// any lane.
+func hasStep(slice *ast.Slice, elem *ast.Element) bool {
+	name := elem.Name
+	if elem.Swimlane != "" {
+		name = elem.Swimlane + "/" + elem.Name
+	}
+	for _, step := range slice.Elements {
+		if step.Type == elem.Type && step.HasName(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkRequiredProps reports props required by PropSchema that the element lacks.
+func (l *Linter) checkRequiredProps(elem *ast.Element) {
+	for _, key := range l.PropSchema[elem.Type] {
+		found := false
+		for _, p := range elem.Props {
+			if p.Key == key {
+				found = true
+				break
+			}
+		}
+		if !found {
+			l.addIssue("missing-required-prop",
+				fmt.Sprintf("%s %q is missing required prop %q", elem.Type, elem.Name, key),
+				elem.Line, elem.Column, SeverityWarning)
+		}
+	}
+}
+
 func (l *Linter) isFollowedByEventOrException(elements []*ast.Element, index int) bool {
 	for i := index + 1; i < len(elements); i++ {
+		if inOtherBranch(elements[index], elements[i]) {
+			continue
+		}
 		switch elements[i].Type {
 		case ast.ElementEvent, ast.ElementException:
 			return true
@@ -130,3 +455,21 @@ func (l *Linter) isFollowedByEventOrException(elements []*ast.Element, index int
 	}
 	return false
 }
+
+// isPrecededByCommand reports whether a command comes before the element at
+// index in the same flow.
+func (l *Linter) isPrecededByCommand(elements []*ast.Element, index int) bool {
+	for i := 0; i < index; i++ {
+		if elements[i].Type == ast.ElementCommand && !inOtherBranch(elements[index], elements[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+// inOtherBranch reports whether a and b sit in different branches of the
+// same alt. Branches are flattened into the slice one after the other, but
+// they are alternative flows: the end of one does not lead into the next.
+func inOtherBranch(a, b *ast.Element) bool {
+	return a.Alt != nil && a.Alt == b.Alt && a.Branch != b.Branch
+}
@@ -1,6 +1,8 @@
 package linter
 
 import (
+	"context"
+	"errors"
 	"strings"
 	"testing"
 
@@ -270,6 +272,83 @@ slices:
 	}
 }
 
+func TestLintAltBranchesAreNotAdjacent(t *testing.T) {
+	input := `
+slices:
+  checkout:
+    - alt:
+        card:
+          - c: PayByCard
+        invoice:
+          - e: InvoiceRequested
+          - c: SendInvoice
+    - e: PaymentRecorded
+`
+	doc := mustParse(t, input)
+
+	linter := New()
+	issues := linter.Lint(doc)
+
+	for _, issue := range issues {
+		if issue.Rule == "command-without-event" || issue.Rule == "orphan-exception" {
+			t.Errorf("unexpected issue: %s", issue.Message)
+		}
+	}
+
+	input = `
+slices:
+  checkout:
+    - alt:
+        card:
+          - c: PayByCard
+        invoice:
+          - x: InvoiceRejected
+          - c: SendInvoice
+`
+	doc = mustParse(t, input)
+
+	issues = linter.Lint(doc)
+
+	var commands, orphans int
+	for _, issue := range issues {
+		switch issue.Rule {
+		case "command-without-event":
+			commands++
+		case "orphan-exception":
+			orphans++
+		}
+	}
+	if commands != 2 {
+		t.Errorf("expected 2 command-without-event issues, got %d", commands)
+	}
+	if orphans != 1 {
+		t.Errorf("expected 1 orphan-exception issue, got %d", orphans)
+	}
+}
+
+func TestLintUsesNameNotLabel(t *testing.T) {
+	input := `
+slices:
+  checkout:
+    - c: PlaceOrder
+      props:
+        label: Place the order
+    - e: OrderPlaced
+`
+	doc := mustParse(t, input)
+
+	linter := New()
+	linter.PropSchema[ast.ElementCommand] = []string{"customer_id"}
+	issues := linter.Lint(doc)
+
+	if len(issues) != 1 || issues[0].Rule != "missing-required-prop" {
+		t.Fatalf("expected one missing-required-prop issue, got %v", issues)
+	}
+	if !strings.Contains(issues[0].Message, `"PlaceOrder"`) {
+		t.Errorf("expected message to use the element name, got %q", issues[0].Message)
+	}
+}
+
 func TestLintOrphanException(t *testing.T) {
 	input := `
 slices:
@@ -537,3 +616,504 @@ slices:
 		}
 	}
 }
+
+func TestLintMissingRequiredProp(t *testing.T) {
+	input := `
+slices:
+  checkout:
+    - c: PlaceOrder
+    - e: OrderPlaced
+      props:
+        order_id: string
+    - e: OrderConfirmed
+      props:
+        occurred_at: timestamp
+`
+	doc := mustParse(t, input)
+
+	linter := New()
+	linter.PropSchema[ast.ElementEvent] = []string{"occurred_at"}
+	issues := linter.Lint(doc)
+
+	var found []Issue
+	for _, issue := range issues {
+		if issue.Rule == "missing-required-prop" {
+			found = append(found, issue)
+		}
+	}
+
+	if len(found) != 1 {
+		t.Fatalf("expected 1 'missing-required-prop' issue, got %d: %v", len(found), found)
+	}
+	if found[0].Line != 5 {
+		t.Errorf("expected issue at line 5, got %d", found[0].Line)
+	}
+	if !strings.Contains(found[0].Message, "OrderPlaced") || !strings.Contains(found[0].Message, "occurred_at") {
+		t.Errorf("unexpected message: %s", found[0].Message)
+	}
+}
+
+func TestLintRequiredPropWithoutSchema(t *testing.T) {
+	input := `
+slices:
+  checkout:
+    - c: PlaceOrder
+    - e: OrderPlaced
+`
+	doc := mustParse(t, input)
+
+	issues := New().Lint(doc)
+
+	for _, issue := range issues {
+		if issue.Rule == "missing-required-prop" {
+			t.Errorf("unexpected issue without schema: %s", issue)
+		}
+	}
+}
+
+func TestRulesAreKnown(t *testing.T) {
+	for _, rule := range []string{"command-without-event", "orphan-exception", "slice-missing-event", "missing-required-prop", "duplicate-slice-content", "dangling-ref", "test-missing-when", "empty-slice-placeholder", "when-command-not-in-steps", "untested-exception", "near-duplicate-swimlane", "redundant-test", "deprecated-element-referenced"} {
+		if !IsRule(rule) {
+			t.Errorf("expected %q to be a known rule", rule)
+		}
+	}
+	if IsRule("no-such-rule") {
+		t.Error("expected 'no-such-rule' to be unknown")
+	}
+}
+
+func TestLintContextCancelled(t *testing.T) {
+	doc := mustParse(t, `
+slices:
+  a:
+    - c: A
+`)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := New().LintContext(ctx, doc)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestLintDuplicateSliceContent(t *testing.T) {
+	input := `
+slices:
+  checkout:
+    - c: PlaceOrder
+    - e: OrderPlaced
+  checkout-copy:
+    - c: PlaceOrder
+    - e: OrderPlaced
+  shipping:
+    - c: ShipOrder
+    - e: OrderShipped
+`
+	doc := mustParse(t, input)
+
+	var dups []Issue
+	for _, issue := range New().Lint(doc) {
+		if issue.Rule == "duplicate-slice-content" {
+			dups = append(dups, issue)
+		}
+	}
+
+	if len(dups) != 1 {
+		t.Fatalf("expected 1 duplicate-slice-content issue, got %v", dups)
+	}
+	if !strings.Contains(dups[0].Message, `"checkout-copy"`) || !strings.Contains(dups[0].Message, `"checkout"`) {
+		t.Errorf("expected message to name both slices, got %q", dups[0].Message)
+	}
+	if dups[0].Line != 7 {
+		t.Errorf("expected issue on line 7, got %d", dups[0].Line)
+	}
+}
+
+func TestLintDuplicateSliceContentIgnored(t *testing.T) {
+	input := `
+slices:
+  a:
+    - c: PlaceOrder
+    - e: OrderPlaced
+  b:
+    - c: PlaceOrder
+    - e: OrderPlaced
+`
+	doc := mustParse(t, input)
+
+	linter := New()
+	linter.IgnoreRules["duplicate-slice-content"] = true
+
+	for _, issue := range linter.Lint(doc) {
+		if issue.Rule == "duplicate-slice-content" {
+			t.Error("expected 'duplicate-slice-content' to be suppressed")
+		}
+	}
+}
+
+func TestLintDanglingRef(t *testing.T) {
+	input := `
+slices:
+  checkout:
+    - c: PlaceOrder
+    - e: Sales/OrderPlaced
+  shipping:
+    - c: ShipOrder
+      props:
+        source_event_ref: OrderPlaced
+        trigger: Sales/OrderPlaced
+        policy_ref: ShippingPolicy
+    - e: OrderShipped
+`
+	doc := mustParse(t, input)
+
+	l := New()
+	l.RefProps = []string{"trigger"}
+	var refs []Issue
+	for _, issue := range l.Lint(doc) {
+		if issue.Rule == "dangling-ref" {
+			refs = append(refs, issue)
+		}
+	}
+
+	if len(refs) != 1 {
+		t.Fatalf("expected 1 dangling-ref issue, got %v", refs)
+	}
+	if !strings.Contains(refs[0].Message, `"ShippingPolicy"`) {
+		t.Errorf("expected message to name the missing element, got %q", refs[0].Message)
+	}
+	if refs[0].Line != 11 {
+		t.Errorf("expected issue on line 11, got %d", refs[0].Line)
+	}
+}
+
+func TestLintTestMissingWhen(t *testing.T) {
+	input := `
+slices:
+  checkout:
+    steps:
+      - c: PlaceOrder
+      - e: OrderPlaced
+    tests:
+      invariant:
+        given:
+          - e: OrderPlaced
+        then:
+          - e: OrderPlaced
+      complete:
+        when:
+          - c: PlaceOrder
+        then:
+          - e: OrderPlaced
+      placeholder:
+`
+	doc := mustParse(t, input)
+
+	var missing []Issue
+	for _, issue := range New().Lint(doc) {
+		if issue.Rule == "test-missing-when" {
+			missing = append(missing, issue)
+		}
+	}
+
+	if len(missing) != 1 {
+		t.Fatalf("expected 1 test-missing-when issue, got %v", missing)
+	}
+	if !strings.Contains(missing[0].Message, `"invariant"`) {
+		t.Errorf("expected message to name the test, got %q", missing[0].Message)
+	}
+	if missing[0].Line != 8 || missing[0].Column != 7 {
+		t.Errorf("expected issue at 8:7, got %d:%d", missing[0].Line, missing[0].Column)
+	}
+
+	l := New()
+	l.IgnoreRules["test-missing-when"] = true
+	for _, issue := range l.Lint(doc) {
+		if issue.Rule == "test-missing-when" {
+			t.Errorf("expected rule to be ignorable, got %v", issue)
+		}
+	}
+}
+
+func TestLintEmptySlicePlaceholder(t *testing.T) {
+	input := `
+slices:
+  checkout:
+    - c: PlaceOrder
+    - e: OrderPlaced
+  refunds:
+  shipping:
+`
+	doc := mustParse(t, input)
+
+	placeholders := func(l *Linter) []Issue {
+		var found []Issue
+		for _, issue := range l.Lint(doc) {
+			if issue.Rule == "empty-slice-placeholder" {
+				found = append(found, issue)
+			}
+		}
+		return found
+	}
+
+	if got := placeholders(New()); len(got) != 0 {
+		t.Errorf("expected rule to be off by default, got %v", got)
+	}
+
+	l := New()
+	l.EnableRules["empty-slice-placeholder"] = true
+	got := placeholders(l)
+	if len(got) != 2 {
+		t.Fatalf("expected 2 placeholders, got %v", got)
+	}
+	if !strings.Contains(got[0].Message, `"refunds"`) || got[0].Line != 6 {
+		t.Errorf("expected refunds on line 6, got %q at line %d", got[0].Message, got[0].Line)
+	}
+	if !strings.Contains(got[1].Message, `"shipping"`) || got[1].Line != 7 {
+		t.Errorf("expected shipping on line 7, got %q at line %d", got[1].Message, got[1].Line)
+	}
+
+	l.IgnoreRules["empty-slice-placeholder"] = true
+	if got := placeholders(l); len(got) != 0 {
+		t.Errorf("expected rule to be ignorable, got %v", got)
+	}
+}
+
+func TestWhenCommandNotInSteps(t *testing.T) {
+	input := `
+slices:
+  checkout:
+    steps:
+      - c: Shop/PlaceOrder
+      - e: OrderPlaced
+    tests:
+      matching:
+        when:
+          - c: PlaceOrder
+        then:
+          - e: OrderPlaced
+      mistyped:
+        when:
+          - c: PlaceOdrer
+        then:
+          - e: OrderPlaced
+`
+	doc := mustParse(t, input)
+
+	var found []Issue
+	for _, issue := range New().Lint(doc) {
+		if issue.Rule == "when-command-not-in-steps" {
+			found = append(found, issue)
+		}
+	}
+
+	if len(found) != 1 {
+		t.Fatalf("expected 1 issue, got %v", found)
+	}
+	if !strings.Contains(found[0].Message, `"PlaceOdrer"`) || found[0].Line != 15 || found[0].Column != 13 {
+		t.Errorf("expected PlaceOdrer at 15:13, got %q at %d:%d", found[0].Message, found[0].Line, found[0].Column)
+	}
+	if found[0].Severity != SeverityWarning {
+		t.Errorf("expected warning, got %v", found[0].Severity)
+	}
+}
+
+func TestUntestedException(t *testing.T) {
+	input := `
+slices:
+  pay:
+    steps:
+      - c: Pay
+      - x: Payments/PaymentDeclined
+      - x: CardExpired
+    tests:
+      declined:
+        when:
+          - c: Pay
+        then:
+          - x: PaymentDeclined
+      not-expired:
+        when:
+          - c: Pay
+        then-not:
+          - x: CardExpired
+`
+	doc := mustParse(t, input)
+
+	var found []Issue
+	for _, issue := range New().Lint(doc) {
+		if issue.Rule == "untested-exception" {
+			found = append(found, issue)
+		}
+	}
+
+	// PaymentDeclined is covered by a then in any swimlane; a then-not
+	// does not cover CardExpired
+	if len(found) != 1 {
+		t.Fatalf("expected 1 issue, got %v", found)
+	}
+	if !strings.Contains(found[0].Message, `"CardExpired"`) || found[0].Line != 7 || found[0].Column != 9 {
+		t.Errorf("expected CardExpired at 7:9, got %q at %d:%d", found[0].Message, found[0].Line, found[0].Column)
+	}
+	if found[0].Severity != SeverityWarning {
+		t.Errorf("expected warning, got %v", found[0].Severity)
+	}
+}
+
+func TestNearDuplicateSwimlane(t *testing.T) {
+	input := `
+slices:
+  register:
+    - t: Customer/OpenForm
+    - c: Customer /Register
+    - e: customer/Registered
+    - e: customer/Welcomed
+`
+	doc := mustParse(t, input)
+
+	var found []Issue
+	for _, issue := range New().Lint(doc) {
+		if issue.Rule == "near-duplicate-swimlane" {
+			found = append(found, issue)
+		}
+	}
+
+	// "Customer " merges with "Customer"; "customer" is reported once
+	if len(found) != 1 {
+		t.Fatalf("expected 1 issue, got %v", found)
+	}
+	if !strings.Contains(found[0].Message, `"customer"`) || found[0].Line != 6 {
+		t.Errorf("expected customer at line 6, got %q at line %d", found[0].Message, found[0].Line)
+	}
+}
+
+func TestRedundantTest(t *testing.T) {
+	input := `
+slices:
+  register:
+    steps:
+      - c: Register
+      - e: Accounts/Registered
+    tests:
+      registers:
+        when:
+          - c: Register
+        then:
+          - e: Registered
+      registers-once:
+        given:
+          - e: Registered
+        when:
+          - c: Register
+        then:
+          - e: Registered
+`
+	doc := mustParse(t, input)
+
+	redundant := func(l *Linter) []Issue {
+		var found []Issue
+		for _, issue := range l.Lint(doc) {
+			if issue.Rule == "redundant-test" {
+				found = append(found, issue)
+			}
+		}
+		return found
+	}
+
+	if got := redundant(New()); len(got) != 0 {
+		t.Errorf("expected rule to be off by default, got %v", got)
+	}
+
+	// registers-once adds a precondition, so only registers is reported
+	l := New()
+	l.EnableRules["redundant-test"] = true
+	got := redundant(l)
+	if len(got) != 1 {
+		t.Fatalf("expected 1 issue, got %v", got)
+	}
+	if !strings.Contains(got[0].Message, `"registers"`) || got[0].Line != 8 {
+		t.Errorf("expected registers at line 8, got %q at line %d", got[0].Message, got[0].Line)
+	}
+}
+
+func TestDeprecatedElementReferenced(t *testing.T) {
+	input := `
+slices:
+  register:
+    steps:
+      - c: Register
+        props:
+          deprecated: true
+      - e: Registered
+      - e: Welcomed
+    tests:
+      registers:
+        when:
+          - c: Register
+        then:
+          - e: Registered
+      legacy:
+        props:
+          deprecated: true
+        when:
+          - c: Register
+        then:
+          - e: Registered
+`
+	doc := mustParse(t, input)
+
+	var found []Issue
+	for _, issue := range New().Lint(doc) {
+		if issue.Rule == "deprecated-element-referenced" {
+			found = append(found, issue)
+		}
+	}
+	// the deprecated legacy test is not reported
+	if len(found) != 1 {
+		t.Fatalf("expected 1 issue, got %v", found)
+	}
+	if !strings.Contains(found[0].Message, `command "Register"`) || found[0].Line != 13 {
+		t.Errorf("expected Register at line 13, got %q at line %d", found[0].Message, found[0].Line)
+	}
+}
+
+func TestUntestedExceptionOtherSlice(t *testing.T) {
+	input := `
+slices:
+  pay:
+    - c: Pay
+    - x: Payments/PaymentDeclined
+  refund:
+    steps:
+      - c: Refund
+      - x: PaymentDeclined
+      - x: Payments/CardExpired
+    tests:
+      declined:
+        when:
+          - c: Refund
+        then:
+          - x: PaymentDeclined
+          - x: Billing/CardExpired
+`
+	doc := mustParse(t, input)
+
+	var found []Issue
+	for _, issue := range New().Lint(doc) {
+		if issue.Rule == "untested-exception" {
+			found = append(found, issue)
+		}
+	}
+
+	// the refund test covers neither the pay slice nor another lane
+	if len(found) != 2 {
+		t.Fatalf("expected 2 issues, got %v", found)
+	}
+	if !strings.Contains(found[0].Message, `slice "pay"`) || found[0].Line != 5 {
+		t.Errorf("expected pay at line 5, got %q at line %d", found[0].Message, found[0].Line)
+	}
+	if !strings.Contains(found[1].Message, `"Payments/CardExpired"`) || found[1].Line != 10 {
+		t.Errorf("expected Payments/CardExpired at line 10, got %q at line %d", found[1].Message, found[1].Line)
+	}
+}
@@ -2,44 +2,128 @@ package parser
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/emlang-project/emlang/internal/ast"
 	"gopkg.in/yaml.v3"
 )
 
 // elementPrefixes maps YAML keys to element types.
-var elementPrefixes = map[string]ast.ElementType{
-	"t":         ast.ElementTrigger,
-	"trg":       ast.ElementTrigger,
-	"trigger":   ast.ElementTrigger,
-	"c":         ast.ElementCommand,
-	"cmd":       ast.ElementCommand,
-	"command":   ast.ElementCommand,
-	"e":         ast.ElementEvent,
-	"evt":       ast.ElementEvent,
-	"event":     ast.ElementEvent,
-	"x":         ast.ElementException,
-	"err":       ast.ElementException,
-	"exception": ast.ElementException,
-	"v":         ast.ElementView,
-	"view":      ast.ElementView,
-}
+var elementPrefixes = func() map[string]ast.ElementType {
+	prefixes := make(map[string]ast.ElementType)
+	for _, info := range ast.ElementTypes {
+		for _, alias := range info.Aliases {
+			prefixes[alias] = info.Type
+		}
+	}
+	return prefixes
+}()
+
+// ErrInvalid matches, with errors.Is, the errors Parse returns for input
+// that is not a valid Emlang document, as opposed to errors reading the
+// input or a done context.
+var ErrInvalid = errors.New("invalid document")
+
+// invalidError marks a syntax or structure error as ErrInvalid while
+// keeping its message, which already locates the problem.
+type invalidError struct{ err error }
+
+func (e invalidError) Error() string        { return e.err.Error() }
+func (e invalidError) Unwrap() error        { return e.err }
+func (e invalidError) Is(target error) bool { return target == ErrInvalid }
 
 // isNullNode returns true if the node represents a YAML null value.
 func isNullNode(node *yaml.Node) bool {
 	return node.Kind == yaml.ScalarNode && node.Tag == "!!null"
 }
 
+// Options adjusts the grammar accepted by ParseWithOptions.
+// The zero value is the grammar of Parse.
+type Options struct {
+	// AllowInTests adds element types to those allowed in a test section,
+	// keyed by section: "given", "when" or "then" (which also covers
+	// then-not), e.g. views in when.
+	AllowInTests map[string][]ast.ElementType
+
+	// Strict rejects constructs the grammar otherwise tolerates, for
+	// teams that want their files kept to a cleaner subset: anonymous
+	// names and names with stray whitespace, extended slices without
+	// tests or props, slices without events, and mixed key styles.
+	Strict bool
+}
+
+// TestSections lists the test sections Options.AllowInTests may extend.
+var TestSections = []string{"given", "when", "then"}
+
+// testTypes holds the element types allowed in each test section.
+type testTypes struct {
+	given, when, then map[ast.ElementType]bool
+}
+
+// strictTestTypes are the element types the specification allows.
+var strictTestTypes = &testTypes{
+	given: map[ast.ElementType]bool{ast.ElementEvent: true, ast.ElementView: true, ast.ElementProjection: true},
+	when:  map[ast.ElementType]bool{ast.ElementCommand: true},
+	then:  map[ast.ElementType]bool{ast.ElementEvent: true, ast.ElementView: true, ast.ElementProjection: true, ast.ElementException: true},
+}
+
+// testTypes returns the element types allowed in each test section:
+// the strict sets, plus those added by AllowInTests.
+func (o Options) testTypes() *testTypes {
+	if len(o.AllowInTests) == 0 {
+		return strictTestTypes
+	}
+	extend := func(base map[ast.ElementType]bool, extra []ast.ElementType) map[ast.ElementType]bool {
+		m := make(map[ast.ElementType]bool, len(base)+len(extra))
+		for t := range base {
+			m[t] = true
+		}
+		for _, t := range extra {
+			m[t] = true
+		}
+		return m
+	}
+	return &testTypes{
+		given: extend(strictTestTypes.given, o.AllowInTests["given"]),
+		when:  extend(strictTestTypes.when, o.AllowInTests["when"]),
+		then:  extend(strictTestTypes.then, o.AllowInTests["then"]),
+	}
+}
+
 // Parse parses an Emlang YAML file from the reader.
 // Supports multiple YAML documents separated by ---.
 func Parse(r io.Reader) (*ast.Document, error) {
+	return parse(context.Background(), r, Options{})
+}
+
+// ParseWithOptions is like Parse but accepts the relaxed grammar
+// described by opts.
+func ParseWithOptions(r io.Reader, opts Options) (*ast.Document, error) {
+	return parse(context.Background(), r, opts)
+}
+
+// ParseContext is like Parse but stops with the context's error once ctx
+// is done. Cancellation is checked between YAML documents.
+func ParseContext(ctx context.Context, r io.Reader) (*ast.Document, error) {
+	return parse(ctx, r, Options{})
+}
+
+func parse(ctx context.Context, r io.Reader, opts Options) (*ast.Document, error) {
+	types := opts.testTypes()
+
 	raw, err := io.ReadAll(r)
 	if err != nil {
 		return nil, fmt.Errorf("reading input: %w", err)
 	}
+	raw = normalizeInput(raw)
+	if err := checkTabIndentation(raw); err != nil {
+		return nil, invalidError{err}
+	}
 
 	decoder := yaml.NewDecoder(bytes.NewReader(raw))
 
@@ -49,31 +133,135 @@ func Parse(r io.Reader) (*ast.Document, error) {
 	}
 
 	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
 		var root yaml.Node
 		err := decoder.Decode(&root)
 		if err == io.EOF {
 			break
 		}
 		if err != nil {
-			return nil, fmt.Errorf("yaml parse error: %w", err)
+			return nil, invalidError{fmt.Errorf("yaml parse error: %w", err)}
 		}
 
 		subDoc := &ast.SubDoc{
 			Slices: make(map[string]*ast.Slice),
 		}
 
-		if err := parseDocument(&root, doc, subDoc); err != nil {
-			return nil, err
+		if err := parseDocument(&root, doc, subDoc, types); err != nil {
+			return nil, invalidError{err}
 		}
 
 		doc.SubDocs = append(doc.SubDocs, subDoc)
 	}
 
+	setOffsets(doc)
+
+	if opts.Strict {
+		if err := checkStrict(doc); err != nil {
+			return nil, invalidError{fmt.Errorf("strict: %w", err)}
+		}
+	}
+
 	return doc, nil
 }
 
+// normalizeInput strips a leading UTF-8 byte order mark and converts CRLF
+// line endings to LF, so that files saved on Windows parse exactly like
+// their Unix counterparts. Line and column numbers are unaffected.
+func normalizeInput(raw []byte) []byte {
+	raw = bytes.TrimPrefix(raw, []byte("\xef\xbb\xbf"))
+	return bytes.ReplaceAll(raw, []byte("\r\n"), []byte("\n"))
+}
+
+// checkTabIndentation reports the first line indented with a tab, which
+// YAML forbids but yaml.v3 only rejects with a cryptic token error.
+// Lines inside block scalars (| and >) are skipped, since tabs are
+// legitimate content there.
+func checkTabIndentation(raw []byte) error {
+	blockIndent := -1 // indentation of the line opening a block scalar
+	for i, line := range strings.Split(string(raw), "\n") {
+		content := strings.TrimLeft(line, " \t")
+		indent := len(line) - len(content)
+		if blockIndent >= 0 {
+			if content == "" || indent > blockIndent {
+				continue
+			}
+			blockIndent = -1
+		}
+		if content == "" || content[0] == '#' {
+			continue
+		}
+		if strings.Contains(line[:indent], "\t") {
+			return fmt.Errorf("tabs are not allowed for indentation at line %d; use spaces", i+1)
+		}
+		if opensBlockScalar(content) {
+			blockIndent = indent
+		}
+	}
+	return nil
+}
+
+// opensBlockScalar reports whether a line ends with a block scalar
+// header such as "|", "|-" or ">+", ignoring a trailing comment.
+func opensBlockScalar(content string) bool {
+	if i := strings.Index(content, " #"); i >= 0 {
+		content = content[:i]
+	}
+	content = strings.TrimRight(content, " ")
+	header := strings.TrimRight(content, "+-0123456789")
+	for _, indicator := range []string{"|", ">"} {
+		if header == indicator || strings.HasSuffix(header, " "+indicator) {
+			return true
+		}
+	}
+	return false
+}
+
+// setOffsets fills in the byte offset of every element from its line and column.
+func setOffsets(doc *ast.Document) {
+	lineStarts := []int{0}
+	for i, b := range doc.RawSource {
+		if b == '\n' {
+			lineStarts = append(lineStarts, i+1)
+		}
+	}
+
+	offset := func(elem *ast.Element) {
+		if elem.Line < 1 || elem.Line > len(lineStarts) {
+			return
+		}
+		// Columns count characters, not bytes.
+		off := lineStarts[elem.Line-1]
+		for col := 1; col < elem.Column && off < len(doc.RawSource); col++ {
+			_, size := utf8.DecodeRune(doc.RawSource[off:])
+			off += size
+		}
+		elem.Offset = off
+	}
+
+	for _, sd := range doc.SubDocs {
+		for _, name := range sd.SliceOrder {
+			slice := sd.Slices[name]
+			for _, elem := range slice.Elements {
+				offset(elem)
+			}
+			for _, tn := range slice.TestOrder {
+				test := slice.Tests[tn]
+				for _, elems := range [][]*ast.Element{test.Given, test.When, test.Then, test.ThenNot} {
+					for _, elem := range elems {
+						offset(elem)
+					}
+				}
+			}
+		}
+	}
+}
+
 // parseDocument parses a single YAML document node and merges slices into doc.
-func parseDocument(root *yaml.Node, doc *ast.Document, subDoc *ast.SubDoc) error {
+func parseDocument(root *yaml.Node, doc *ast.Document, subDoc *ast.SubDoc, types *testTypes) error {
 	if root.Kind != yaml.DocumentNode || len(root.Content) == 0 {
 		return nil
 	}
@@ -89,7 +277,7 @@ func parseDocument(root *yaml.Node, doc *ast.Document, subDoc *ast.SubDoc) error
 
 		switch keyNode.Value {
 		case "slices":
-			slices, sliceOrder, err := parseSlices(valueNode)
+			slices, sliceOrder, err := parseSlices(valueNode, types)
 			if err != nil {
 				return err
 			}
@@ -100,16 +288,208 @@ func parseDocument(root *yaml.Node, doc *ast.Document, subDoc *ast.SubDoc) error
 			}
 			subDoc.SliceOrder = sliceOrder
 
+		case "groups":
+			groups, err := parseGroups(valueNode)
+			if err != nil {
+				return err
+			}
+			subDoc.Groups = groups
+
+		case "title":
+			if valueNode.Kind != yaml.ScalarNode || valueNode.Tag != "!!str" {
+				return fmt.Errorf("title must be a string at line %d", valueNode.Line)
+			}
+			subDoc.Title = valueNode.Value
+
+		case "css":
+			css, err := parseCSS(valueNode)
+			if err != nil {
+				return err
+			}
+			subDoc.CSS = css
+
+		case "given_templates":
+			templates, err := parseGivenTemplates(valueNode, types)
+			if err != nil {
+				return err
+			}
+			subDoc.GivenTemplates = templates
+
 		default:
 			return fmt.Errorf("unknown top-level key %q at line %d", keyNode.Value, keyNode.Line)
 		}
 	}
 
+	if err := validateGroups(subDoc); err != nil {
+		return err
+	}
+	return resolveGivenTemplates(subDoc)
+}
+
+// parseGivenTemplates parses the given_templates section: a mapping of
+// template names to lists of given elements.
+func parseGivenTemplates(node *yaml.Node, types *testTypes) ([]*ast.GivenTemplate, error) {
+	if isNullNode(node) {
+		return nil, nil
+	}
+
+	if node.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("given_templates must be a mapping at line %d", node.Line)
+	}
+
+	var templates []*ast.GivenTemplate
+	for i := 0; i < len(node.Content); i += 2 {
+		keyNode := node.Content[i]
+		valueNode := node.Content[i+1]
+
+		elements, err := parseTestSection("given", valueNode, types.given)
+		if err != nil {
+			return nil, fmt.Errorf("given template %q: %w", keyNode.Value, err)
+		}
+		templates = append(templates, &ast.GivenTemplate{Name: keyNode.Value, Elements: elements})
+	}
+
+	return templates, nil
+}
+
+// resolveGivenTemplates expands given: {use: Name} references in the tests
+// of a document with copies of the named template's elements.
+func resolveGivenTemplates(subDoc *ast.SubDoc) error {
+	byName := make(map[string]*ast.GivenTemplate)
+	var names []string
+	for _, t := range subDoc.GivenTemplates {
+		byName[t.Name] = t
+		names = append(names, t.Name)
+	}
+
+	for _, sliceName := range subDoc.SliceOrder {
+		slice := subDoc.Slices[sliceName]
+		for _, testName := range slice.TestOrder {
+			test := slice.Tests[testName]
+			if test.GivenTemplate == "" {
+				continue
+			}
+			tmpl, ok := byName[test.GivenTemplate]
+			if !ok {
+				msg := fmt.Sprintf("slice %q: test %q: unknown given template %q", sliceName, testName, test.GivenTemplate)
+				if s := suggest(test.GivenTemplate, names); s != "" {
+					msg += fmt.Sprintf(" (did you mean %q?)", s)
+				}
+				return errors.New(msg)
+			}
+			test.Given = nil
+			for _, elem := range tmpl.Elements {
+				copied := *elem
+				test.Given = append(test.Given, &copied)
+			}
+		}
+	}
+
+	return nil
+}
+
+// parseGroups parses the groups section: a mapping of group names to slice names.
+func parseGroups(node *yaml.Node) ([]*ast.Group, error) {
+	if isNullNode(node) {
+		return nil, nil
+	}
+
+	if node.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("groups must be a mapping at line %d", node.Line)
+	}
+
+	var groups []*ast.Group
+	for i := 0; i < len(node.Content); i += 2 {
+		keyNode := node.Content[i]
+		valueNode := node.Content[i+1]
+
+		group := &ast.Group{Name: keyNode.Value, Line: keyNode.Line}
+		if valueNode.Kind != yaml.SequenceNode {
+			return nil, fmt.Errorf("group %q: expected a list of slice names at line %d", group.Name, valueNode.Line)
+		}
+		for _, item := range valueNode.Content {
+			if item.Kind != yaml.ScalarNode {
+				return nil, fmt.Errorf("group %q: expected a slice name at line %d", group.Name, item.Line)
+			}
+			group.Slices = append(group.Slices, item.Value)
+		}
+		groups = append(groups, group)
+	}
+
+	return groups, nil
+}
+
+// parseCSS parses the per-document css section: a mapping of CSS
+// variable names (starting with --) to values.
+func parseCSS(node *yaml.Node) ([]ast.CSSVar, error) {
+	if isNullNode(node) {
+		return nil, nil
+	}
+
+	if node.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("css must be a mapping at line %d", node.Line)
+	}
+
+	var vars []ast.CSSVar
+	for i := 0; i < len(node.Content); i += 2 {
+		keyNode := node.Content[i]
+		valueNode := node.Content[i+1]
+
+		if !strings.HasPrefix(keyNode.Value, "--") {
+			return nil, fmt.Errorf("css variable %q must start with -- at line %d", keyNode.Value, keyNode.Line)
+		}
+		if !isCSSName(keyNode.Value[2:]) {
+			return nil, fmt.Errorf("css variable %q may only contain letters, digits, - and _ at line %d", keyNode.Value, keyNode.Line)
+		}
+		if valueNode.Kind != yaml.ScalarNode {
+			return nil, fmt.Errorf("css variable %q must have a scalar value at line %d", keyNode.Value, valueNode.Line)
+		}
+		// Values are inserted verbatim into a <style> element.
+		if strings.ContainsAny(valueNode.Value, "<>{};\n") {
+			return nil, fmt.Errorf("css variable %q: value must not contain <, >, {, }, ; or a newline at line %d", keyNode.Value, valueNode.Line)
+		}
+		vars = append(vars, ast.CSSVar{Name: keyNode.Value, Value: valueNode.Value})
+	}
+
+	return vars, nil
+}
+
+// isCSSName reports whether s, a custom property name without its --
+// prefix, is non-empty and made of ASCII letters, digits, - and _.
+func isCSSName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// validateGroups checks that groups reference existing slices of the same
+// document, and that no slice belongs to more than one group.
+func validateGroups(subDoc *ast.SubDoc) error {
+	owner := make(map[string]string)
+	for _, group := range subDoc.Groups {
+		for _, name := range group.Slices {
+			if _, ok := subDoc.Slices[name]; !ok {
+				return fmt.Errorf("group %q: unknown slice %q at line %d", group.Name, name, group.Line)
+			}
+			if prev, ok := owner[name]; ok {
+				return fmt.Errorf("group %q: slice %q is already in group %q at line %d", group.Name, name, prev, group.Line)
+			}
+			owner[name] = group.Name
+		}
+	}
 	return nil
 }
 
 // parseSlices parses the slices section.
-func parseSlices(node *yaml.Node) (map[string]*ast.Slice, []string, error) {
+func parseSlices(node *yaml.Node, types *testTypes) (map[string]*ast.Slice, []string, error) {
 	slices := make(map[string]*ast.Slice)
 	var order []string
 
@@ -126,10 +506,12 @@ func parseSlices(node *yaml.Node) (map[string]*ast.Slice, []string, error) {
 		valueNode := node.Content[i+1]
 
 		sliceName := keyNode.Value
-		slice, err := parseSlice(sliceName, valueNode)
+		slice, err := parseSlice(sliceName, valueNode, types)
 		if err != nil {
 			return nil, nil, fmt.Errorf("slice %q: %w", sliceName, err)
 		}
+		slice.Line = keyNode.Line
+		slice.Column = keyNode.Column
 		slices[sliceName] = slice
 		order = append(order, sliceName)
 	}
@@ -138,7 +520,7 @@ func parseSlices(node *yaml.Node) (map[string]*ast.Slice, []string, error) {
 }
 
 // parseSlice parses a single slice in direct or extended form.
-func parseSlice(name string, node *yaml.Node) (*ast.Slice, error) {
+func parseSlice(name string, node *yaml.Node, types *testTypes) (*ast.Slice, error) {
 	// Empty slice (null value): placeholder
 	if isNullNode(node) {
 		return &ast.Slice{Name: name}, nil
@@ -146,7 +528,7 @@ func parseSlice(name string, node *yaml.Node) (*ast.Slice, error) {
 
 	switch node.Kind {
 	case yaml.SequenceNode:
-		elements, err := parseElementList(node)
+		elements, err := parseSteps(node)
 		if err != nil {
 			return nil, err
 		}
@@ -160,8 +542,9 @@ func parseSlice(name string, node *yaml.Node) (*ast.Slice, error) {
 
 	case yaml.MappingNode:
 		slice := &ast.Slice{
-			Name:  name,
-			Tests: make(map[string]*ast.Test),
+			Name:     name,
+			Tests:    make(map[string]*ast.Test),
+			Extended: true,
 		}
 
 		for i := 0; i < len(node.Content); i += 2 {
@@ -173,7 +556,7 @@ func parseSlice(name string, node *yaml.Node) (*ast.Slice, error) {
 				if isNullNode(valueNode) {
 					slice.Elements = []*ast.Element{}
 				} else {
-					elements, err := parseElementList(valueNode)
+					elements, err := parseSteps(valueNode)
 					if err != nil {
 						return nil, fmt.Errorf("steps: %w", err)
 					}
@@ -184,13 +567,20 @@ func parseSlice(name string, node *yaml.Node) (*ast.Slice, error) {
 				}
 
 			case "tests":
-				tests, testOrder, err := parseTests(valueNode)
+				tests, testOrder, err := parseTests(valueNode, types)
 				if err != nil {
 					return nil, fmt.Errorf("tests: %w", err)
 				}
 				slice.Tests = tests
 				slice.TestOrder = testOrder
 
+			case "props":
+				props, err := parseProps(valueNode)
+				if err != nil {
+					return nil, fmt.Errorf("props at line %d: %w", valueNode.Line, err)
+				}
+				slice.Props = props
+
 			default:
 				return nil, fmt.Errorf("unknown slice key %q at line %d", keyNode.Value, keyNode.Line)
 			}
@@ -208,7 +598,7 @@ func parseSlice(name string, node *yaml.Node) (*ast.Slice, error) {
 }
 
 // parseTests parses tests attached to a slice.
-func parseTests(node *yaml.Node) (map[string]*ast.Test, []string, error) {
+func parseTests(node *yaml.Node, types *testTypes) (map[string]*ast.Test, []string, error) {
 	tests := make(map[string]*ast.Test)
 
 	if isNullNode(node) {
@@ -225,10 +615,12 @@ func parseTests(node *yaml.Node) (map[string]*ast.Test, []string, error) {
 		valueNode := node.Content[i+1]
 
 		testName := keyNode.Value
-		test, err := parseTest(testName, valueNode)
+		test, err := parseTest(testName, valueNode, types)
 		if err != nil {
 			return nil, nil, fmt.Errorf("test %q: %w", testName, err)
 		}
+		test.Line = keyNode.Line
+		test.Column = keyNode.Column
 
 		tests[testName] = test
 		order = append(order, testName)
@@ -237,8 +629,9 @@ func parseTests(node *yaml.Node) (map[string]*ast.Test, []string, error) {
 	return tests, order, nil
 }
 
-// parseTest parses a single test definition.
-func parseTest(name string, node *yaml.Node) (*ast.Test, error) {
+// parseTest parses a single test definition, allowing in each section
+// the element types of types.
+func parseTest(name string, node *yaml.Node, types *testTypes) (*ast.Test, error) {
 	// A test MAY be empty (null node).
 	if isNullNode(node) {
 		return &ast.Test{Name: name}, nil
@@ -250,10 +643,6 @@ func parseTest(name string, node *yaml.Node) (*ast.Test, error) {
 
 	test := &ast.Test{Name: name}
 
-	allowedGiven := map[ast.ElementType]bool{ast.ElementEvent: true, ast.ElementView: true}
-	allowedWhen := map[ast.ElementType]bool{ast.ElementCommand: true}
-	allowedThen := map[ast.ElementType]bool{ast.ElementEvent: true, ast.ElementView: true, ast.ElementException: true}
-
 	for i := 0; i < len(node.Content); i += 2 {
 		keyNode := node.Content[i]
 		valueNode := node.Content[i+1]
@@ -261,7 +650,15 @@ func parseTest(name string, node *yaml.Node) (*ast.Test, error) {
 		switch keyNode.Value {
 		case "given":
 			test.HasGiven = true
-			elems, err := parseTestSection(keyNode.Value, valueNode, allowedGiven)
+			if valueNode.Kind == yaml.MappingNode {
+				tmpl, err := parseGivenUse(valueNode)
+				if err != nil {
+					return nil, err
+				}
+				test.GivenTemplate = tmpl
+				continue
+			}
+			elems, err := parseTestSection(keyNode.Value, valueNode, types.given)
 			if err != nil {
 				return nil, err
 			}
@@ -269,7 +666,7 @@ func parseTest(name string, node *yaml.Node) (*ast.Test, error) {
 
 		case "when":
 			test.HasWhen = true
-			elems, err := parseTestSection(keyNode.Value, valueNode, allowedWhen)
+			elems, err := parseTestSection(keyNode.Value, valueNode, types.when)
 			if err != nil {
 				return nil, err
 			}
@@ -277,12 +674,37 @@ func parseTest(name string, node *yaml.Node) (*ast.Test, error) {
 
 		case "then":
 			test.HasThen = true
-			elems, err := parseTestSection(keyNode.Value, valueNode, allowedThen)
+			elems, err := parseTestSection(keyNode.Value, valueNode, types.then)
 			if err != nil {
 				return nil, err
 			}
 			test.Then = elems
 
+		case "then-not":
+			test.HasThenNot = true
+			elems, err := parseTestSection(keyNode.Value, valueNode, types.then)
+			if err != nil {
+				return nil, err
+			}
+			test.ThenNot = elems
+
+		case "props":
+			props, err := parseProps(valueNode)
+			if err != nil {
+				return nil, fmt.Errorf("props at line %d: %w", valueNode.Line, err)
+			}
+			test.Props = props
+			for _, p := range props {
+				if p.Key != "deprecated" {
+					continue
+				}
+				b, err := boolProp(p)
+				if err != nil {
+					return nil, err
+				}
+				test.Deprecated = b
+			}
+
 		default:
 			return nil, fmt.Errorf("unknown test key %q at line %d", keyNode.Value, keyNode.Line)
 		}
@@ -291,6 +713,14 @@ func parseTest(name string, node *yaml.Node) (*ast.Test, error) {
 	return test, nil
 }
 
+// parseGivenUse parses a given: {use: Name} template reference.
+func parseGivenUse(node *yaml.Node) (string, error) {
+	if len(node.Content) != 2 || node.Content[0].Value != "use" || node.Content[1].Kind != yaml.ScalarNode {
+		return "", fmt.Errorf("given must be a list of elements or {use: template} at line %d", node.Line)
+	}
+	return node.Content[1].Value, nil
+}
+
 // parseTestSection parses a given/when/then section, validating element types.
 func parseTestSection(section string, node *yaml.Node, allowed map[ast.ElementType]bool) ([]*ast.Element, error) {
 	if isNullNode(node) {
@@ -308,6 +738,75 @@ func parseTestSection(section string, node *yaml.Node, allowed map[ast.ElementTy
 	return elements, nil
 }
 
+// parseSteps parses the steps of a slice: a sequence of elements
+// which may contain alt constructs. Branch elements are flattened in order.
+func parseSteps(node *yaml.Node) ([]*ast.Element, error) {
+	if node.Kind != yaml.SequenceNode {
+		return nil, fmt.Errorf("expected sequence at line %d", node.Line)
+	}
+
+	var elements []*ast.Element
+	for _, itemNode := range node.Content {
+		if isAltNode(itemNode) {
+			alt, err := parseAlt(itemNode)
+			if err != nil {
+				return nil, err
+			}
+			for _, branch := range alt.Branches {
+				elements = append(elements, branch.Elements...)
+			}
+			continue
+		}
+
+		elem, err := parseElement(itemNode)
+		if err != nil {
+			return nil, err
+		}
+		elements = append(elements, elem)
+	}
+
+	return elements, nil
+}
+
+// isAltNode reports whether node is a step of the form {alt: ...}.
+func isAltNode(node *yaml.Node) bool {
+	return node.Kind == yaml.MappingNode && len(node.Content) == 2 && node.Content[0].Value == "alt"
+}
+
+// parseAlt parses an alt step: a mapping of branch names to element lists.
+func parseAlt(node *yaml.Node) (*ast.Alt, error) {
+	branchesNode := node.Content[1]
+	if branchesNode.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("alt must be a mapping of branches at line %d", branchesNode.Line)
+	}
+	if len(branchesNode.Content) < 4 {
+		return nil, fmt.Errorf("alt must have at least two branches at line %d", node.Line)
+	}
+
+	alt := &ast.Alt{Line: node.Line, Column: node.Column}
+	for i := 0; i < len(branchesNode.Content); i += 2 {
+		keyNode := branchesNode.Content[i]
+		valueNode := branchesNode.Content[i+1]
+
+		branch := &ast.Branch{Name: keyNode.Value}
+		elements, err := parseElementList(valueNode)
+		if err != nil {
+			return nil, fmt.Errorf("alt branch %q: %w", branch.Name, err)
+		}
+		if len(elements) == 0 {
+			return nil, fmt.Errorf("alt branch %q must have at least one element at line %d", branch.Name, keyNode.Line)
+		}
+		for _, elem := range elements {
+			elem.Alt = alt
+			elem.Branch = branch.Name
+		}
+		branch.Elements = elements
+		alt.Branches = append(alt.Branches, branch)
+	}
+
+	return alt, nil
+}
+
 // parseElementList parses a sequence of elements.
 func parseElementList(node *yaml.Node) ([]*ast.Element, error) {
 	if node.Kind != yaml.SequenceNode {
@@ -328,7 +827,9 @@ func parseElementList(node *yaml.Node) ([]*ast.Element, error) {
 
 // parseElement parses a single element.
 func parseElement(node *yaml.Node) (*ast.Element, error) {
+	alias := ""
 	if node.Kind == yaml.AliasNode {
+		alias = node.Value
 		node = node.Alias
 	}
 	if node.Kind != yaml.MappingNode {
@@ -338,9 +839,14 @@ func parseElement(node *yaml.Node) (*ast.Element, error) {
 	elem := &ast.Element{
 		Line:   node.Line,
 		Column: node.Column,
+		Alias:  alias,
+	}
+	if alias == "" {
+		elem.Anchor = node.Anchor
 	}
 
 	var foundType bool
+	var inlineProps []ast.PropEntry
 	for i := 0; i < len(node.Content); i += 2 {
 		keyNode := node.Content[i]
 		valueNode := node.Content[i+1]
@@ -353,6 +859,9 @@ func parseElement(node *yaml.Node) (*ast.Element, error) {
 				return nil, fmt.Errorf("props at line %d: %w", valueNode.Line, err)
 			}
 			elem.Props = props
+			if err := applyReservedProps(elem); err != nil {
+				return nil, err
+			}
 			continue
 		}
 
@@ -363,7 +872,13 @@ func parseElement(node *yaml.Node) (*ast.Element, error) {
 			}
 			foundType = true
 			elem.Type = elemType
-			elem.Name = strings.TrimSpace(valueNode.Value)
+			elem.RawKey = key
+			name, props, err := splitInlineProps(valueNode)
+			if err != nil {
+				return nil, err
+			}
+			elem.Name = name
+			inlineProps = props
 			if elem.Name == "" {
 				return nil, fmt.Errorf("element %s has no name at line %d", elemType, keyNode.Line)
 			}
@@ -371,8 +886,6 @@ func parseElement(node *yaml.Node) (*ast.Element, error) {
 				return nil, fmt.Errorf("element name must not end with '/' at line %d", keyNode.Line)
 			}
 			elem.ParseSwimlane()
-			elem.Swimlane = strings.TrimSpace(elem.Swimlane)
-			elem.Name = strings.TrimSpace(elem.Name)
 			if elem.Swimlane != "" && elem.Name == "" {
 				return nil, fmt.Errorf("element %s has empty name after swimlane at line %d", elemType, keyNode.Line)
 			}
@@ -385,9 +898,93 @@ func parseElement(node *yaml.Node) (*ast.Element, error) {
 		return nil, fmt.Errorf("element missing type at line %d", node.Line)
 	}
 
+	if inlineProps != nil {
+		if elem.Props != nil {
+			return nil, fmt.Errorf("element has both inline props and a props block at line %d", node.Line)
+		}
+		elem.Props = inlineProps
+		if err := applyReservedProps(elem); err != nil {
+			return nil, err
+		}
+	}
+
 	return elem, nil
 }
 
+// applyReservedProps sets element fields from reserved prop keys.
+func applyReservedProps(elem *ast.Element) error {
+	for _, p := range elem.Props {
+		switch p.Key {
+		case "external":
+			b, err := boolProp(p)
+			if err != nil {
+				return err
+			}
+			elem.External = b
+		case "label":
+			s, ok := p.Value.(string)
+			if !ok {
+				return fmt.Errorf("prop %q must be a string at line %d", p.Key, p.Line)
+			}
+			elem.Label = s
+		case "owner":
+			s, ok := p.Value.(string)
+			if !ok {
+				return fmt.Errorf("prop %q must be a string at line %d", p.Key, p.Line)
+			}
+			elem.Owner = s
+		case "col":
+			n, ok := p.Value.(int)
+			if !ok || n < 1 {
+				return fmt.Errorf("prop %q must be a positive integer at line %d", p.Key, p.Line)
+			}
+			elem.Col = n
+		case "deprecated":
+			b, err := boolProp(p)
+			if err != nil {
+				return err
+			}
+			elem.Deprecated = b
+		}
+	}
+	return nil
+}
+
+// boolProp returns the value of a reserved boolean prop.
+func boolProp(p ast.PropEntry) (bool, error) {
+	b, ok := p.Value.(bool)
+	if !ok {
+		return false, fmt.Errorf("prop %q must be a boolean at line %d", p.Key, p.Line)
+	}
+	return b, nil
+}
+
+// splitInlineProps splits an element value into its name and the props of
+// a trailing flow mapping, e.g. "CreateUser {email: string}". Such a value
+// must be quoted in YAML since a plain scalar cannot contain ": ".
+// Inline props are positioned at the value node.
+func splitInlineProps(node *yaml.Node) (string, []ast.PropEntry, error) {
+	value := strings.TrimSpace(node.Value)
+	start := strings.Index(value, "{")
+	if start < 0 || !strings.HasSuffix(value, "}") {
+		return value, nil, nil
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte(value[start:]), &doc); err != nil {
+		return "", nil, fmt.Errorf("inline props at line %d: %w", node.Line, err)
+	}
+	props, err := parseProps(doc.Content[0])
+	if err != nil {
+		return "", nil, fmt.Errorf("inline props at line %d: %w", node.Line, err)
+	}
+	for i := range props {
+		props[i].Line = node.Line
+		props[i].Column = node.Column
+	}
+	return strings.TrimSpace(value[:start]), props, nil
+}
+
 // parseProps parses the props field, preserving source order.
 func parseProps(node *yaml.Node) ([]ast.PropEntry, error) {
 	if node.Kind != yaml.MappingNode {
@@ -401,7 +998,12 @@ func parseProps(node *yaml.Node) ([]ast.PropEntry, error) {
 		if err := valNode.Decode(&val); err != nil {
 			return nil, err
 		}
-		props = append(props, ast.PropEntry{Key: keyNode.Value, Value: val})
+		props = append(props, ast.PropEntry{
+			Key:    keyNode.Value,
+			Value:  val,
+			Line:   keyNode.Line,
+			Column: keyNode.Column,
+		})
 	}
 	return props, nil
 }
@@ -1,6 +1,9 @@
 package parser
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"strings"
 	"testing"
 
@@ -110,6 +113,218 @@ slices:
 	}
 }
 
+func TestParsePropPositions(t *testing.T) {
+	input := `
+slices:
+  checkout:
+    - c: PlaceOrder
+      props:
+        customer_id: string
+        total: number
+`
+	doc, err := Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	props := doc.Slices["checkout"].Elements[0].Props
+	if props[0].Line != 6 || props[0].Column != 9 {
+		t.Errorf("expected customer_id at 6:9, got %d:%d", props[0].Line, props[0].Column)
+	}
+	if props[1].Line != 7 || props[1].Column != 9 {
+		t.Errorf("expected total at 7:9, got %d:%d", props[1].Line, props[1].Column)
+	}
+}
+
+func TestParseInlineProps(t *testing.T) {
+	input := `
+slices:
+  signup:
+    - c: "Accounts/CreateUser {email: string, age: 42, external: true}"
+    - e: UserCreated {}
+`
+	doc, err := Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	cmd := doc.Slices["signup"].Elements[0]
+	if cmd.Swimlane != "Accounts" || cmd.Name != "CreateUser" {
+		t.Errorf("expected Accounts/CreateUser, got %s/%s", cmd.Swimlane, cmd.Name)
+	}
+	if len(cmd.Props) != 3 {
+		t.Fatalf("expected 3 props, got %v", cmd.Props)
+	}
+	if cmd.Props[0].Key != "email" || cmd.Props[0].Value != "string" {
+		t.Errorf("expected email: string, got %v: %v", cmd.Props[0].Key, cmd.Props[0].Value)
+	}
+	if cmd.Props[1].Key != "age" || cmd.Props[1].Value != 42 {
+		t.Errorf("expected age: 42, got %v: %v", cmd.Props[1].Key, cmd.Props[1].Value)
+	}
+	if !cmd.External {
+		t.Error("expected inline external prop to be applied")
+	}
+	if cmd.Props[0].Line != 4 {
+		t.Errorf("expected inline props on line 4, got %d", cmd.Props[0].Line)
+	}
+
+	evt := doc.Slices["signup"].Elements[1]
+	if evt.Name != "UserCreated" || len(evt.Props) != 0 {
+		t.Errorf("expected UserCreated without props, got %q %v", evt.Name, evt.Props)
+	}
+}
+
+func TestParseInlinePropsWithPropsBlock(t *testing.T) {
+	input := `
+slices:
+  signup:
+    - c: "CreateUser {email: string}"
+      props:
+        age: number
+`
+	_, err := Parse(strings.NewReader(input))
+	if err == nil || !strings.Contains(err.Error(), "both inline props and a props block") {
+		t.Errorf("expected conflict error, got %v", err)
+	}
+}
+
+func TestParseExternalProp(t *testing.T) {
+	input := `
+slices:
+  checkout:
+    - c: ChargeCard
+      props:
+        external: true
+    - e: CardCharged
+`
+	doc, err := Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	slice := doc.Slices["checkout"]
+	if !slice.Elements[0].External {
+		t.Error("expected command to be external")
+	}
+	if slice.Elements[1].External {
+		t.Error("expected event not to be external")
+	}
+	if len(slice.Elements[0].Props) != 1 {
+		t.Errorf("expected external prop to be kept in props, got %v", slice.Elements[0].Props)
+	}
+}
+
+func TestParseExternalPropMustBeBoolean(t *testing.T) {
+	input := `
+slices:
+  checkout:
+    - c: ChargeCard
+      props:
+        external: stripe
+`
+	_, err := Parse(strings.NewReader(input))
+	if err == nil {
+		t.Fatal("expected error for non-boolean external prop")
+	}
+	if !strings.Contains(err.Error(), "line 6") {
+		t.Errorf("expected error to point at the prop's line, got %v", err)
+	}
+}
+
+func TestParseLabelProp(t *testing.T) {
+	input := `
+slices:
+  checkout:
+    - c: PlaceOrder
+      props:
+        label: Place the order
+`
+	doc, err := Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	elem := doc.Slices["checkout"].Elements[0]
+	if elem.Name != "PlaceOrder" {
+		t.Errorf("expected name 'PlaceOrder', got %q", elem.Name)
+	}
+	if elem.Label != "Place the order" {
+		t.Errorf("expected label 'Place the order', got %q", elem.Label)
+	}
+}
+
+func TestParseLabelPropMustBeString(t *testing.T) {
+	input := `
+slices:
+  checkout:
+    - c: PlaceOrder
+      props:
+        label: [a, b]
+`
+	_, err := Parse(strings.NewReader(input))
+	if err == nil {
+		t.Fatal("expected error for non-string label prop")
+	}
+}
+
+func TestParseOwnerPropMustBeString(t *testing.T) {
+	input := `
+slices:
+  checkout:
+    - c: PlaceOrder
+      props:
+        owner: 42
+`
+	_, err := Parse(strings.NewReader(input))
+	if err == nil {
+		t.Fatal("expected error for non-string owner prop")
+	}
+}
+
+func TestParseColPropMustBePositiveInteger(t *testing.T) {
+	for _, value := range []string{"0", "two", "1.5"} {
+		input := `
+slices:
+  checkout:
+    - c: PlaceOrder
+      props:
+        col: ` + value + `
+`
+		if _, err := Parse(strings.NewReader(input)); err == nil {
+			t.Errorf("expected error for col: %s", value)
+		}
+	}
+}
+
+func TestParseRecordsAnchorsAndAliases(t *testing.T) {
+	input := `
+slices:
+  checkout:
+    - &place
+      c: PlaceOrder
+    - e: OrderPlaced
+    - *place
+`
+	doc, err := Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	elems := doc.Slices["checkout"].Elements
+	if elems[0].Anchor != "place" || elems[0].Alias != "" {
+		t.Errorf("expected anchor 'place' on first element, got anchor=%q alias=%q", elems[0].Anchor, elems[0].Alias)
+	}
+	if elems[1].Anchor != "" || elems[1].Alias != "" {
+		t.Errorf("expected no anchor/alias on event, got anchor=%q alias=%q", elems[1].Anchor, elems[1].Alias)
+	}
+	if elems[2].Alias != "place" || elems[2].Anchor != "" {
+		t.Errorf("expected alias 'place' on last element, got anchor=%q alias=%q", elems[2].Anchor, elems[2].Alias)
+	}
+	if elems[2].Name != "PlaceOrder" {
+		t.Errorf("expected alias to resolve to PlaceOrder, got %q", elems[2].Name)
+	}
+}
+
 func TestParseAllPrefixes(t *testing.T) {
 	tests := []struct {
 		prefix   string
@@ -129,6 +344,9 @@ func TestParseAllPrefixes(t *testing.T) {
 		{"exception", ast.ElementException},
 		{"v", ast.ElementView},
 		{"view", ast.ElementView},
+		{"p", ast.ElementProjection},
+		{"proj", ast.ElementProjection},
+		{"projection", ast.ElementProjection},
 	}
 
 	for _, tc := range tests {
@@ -412,6 +630,28 @@ slices:
 	}
 }
 
+func TestParseSwimlaneWhitespace(t *testing.T) {
+	input := `
+slices:
+  test:
+    - t: Customer/ClickButton
+    - c: " Customer  /Submit"
+    - e: Customer   Support /Ticket Opened
+`
+	doc, err := Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	elems := doc.Slices["test"].Elements
+	if elems[1].Swimlane != "Customer" || elems[1].Name != "Submit" {
+		t.Errorf("expected Customer/Submit, got %q/%q", elems[1].Swimlane, elems[1].Name)
+	}
+	if elems[2].Swimlane != "Customer Support" || elems[2].Name != "Ticket Opened" {
+		t.Errorf("expected Customer Support/Ticket Opened, got %q/%q", elems[2].Swimlane, elems[2].Name)
+	}
+}
+
 func TestParseMultipleSlices(t *testing.T) {
 	input := `
 slices:
@@ -582,6 +822,54 @@ slices:
 	}
 }
 
+func TestParseSliceAndTestProps(t *testing.T) {
+	input := `
+slices:
+  checkout:
+    props:
+      ticket: SHOP-142
+      team: payments
+    steps:
+      - c: PlaceOrder
+      - e: OrderPlaced
+    tests:
+      places-order:
+        props:
+          ticket: SHOP-150
+        when:
+          - c: PlaceOrder
+        then:
+          - e: OrderPlaced
+`
+	doc, err := Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	slice := doc.Slices["checkout"]
+	if len(slice.Props) != 2 || slice.Props[0].Key != "ticket" || slice.Props[0].Value != "SHOP-142" || slice.Props[1].Key != "team" {
+		t.Errorf("expected slice props ticket and team, got %+v", slice.Props)
+	}
+	if slice.Props[0].Line != 5 {
+		t.Errorf("expected ticket prop at line 5, got %d", slice.Props[0].Line)
+	}
+	test := slice.Tests["places-order"]
+	if len(test.Props) != 1 || test.Props[0].Value != "SHOP-150" {
+		t.Errorf("expected test prop ticket, got %+v", test.Props)
+	}
+
+	_, err = Parse(strings.NewReader(`
+slices:
+  checkout:
+    props: SHOP-142
+    steps:
+      - c: PlaceOrder
+`))
+	if err == nil || !strings.Contains(err.Error(), "props must be a mapping") {
+		t.Errorf("expected props mapping error, got %v", err)
+	}
+}
+
 func TestParseError_ExtendedSliceMissingSteps(t *testing.T) {
 	input := `
 slices:
@@ -1017,3 +1305,729 @@ slices:
 		t.Errorf("expected exception in then, got %s", test.Then[0].Type)
 	}
 }
+
+func TestParseElementOffsets(t *testing.T) {
+	input := "slices:\n  café:\n    - t: Client/Clic\n    - c: Commander\n---\nslices:\n  other:\n    - e: Done\n"
+	doc, err := Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	elems := []*ast.Element{
+		doc.Slices["café"].Elements[0],
+		doc.Slices["café"].Elements[1],
+		doc.Slices["other"].Elements[0],
+	}
+	keys := []string{"t: Client/Clic", "c: Commander", "e: Done"}
+	for i, elem := range elems {
+		if !strings.HasPrefix(input[elem.Offset:], keys[i]) {
+			t.Errorf("element %d: expected offset %d to point at %q, got %q", i, elem.Offset, keys[i], input[elem.Offset:])
+		}
+	}
+}
+
+func TestParseProjectionInTests(t *testing.T) {
+	input := `
+slices:
+  OrderSummary:
+    steps:
+      - e: OrderPlaced
+      - p: OrderSummaryProjector
+      - v: OrderSummary
+    tests:
+      projects-order:
+        given:
+          - e: OrderPlaced
+        then:
+          - p: OrderSummaryProjector
+`
+	doc, err := Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	slice := doc.Slices["OrderSummary"]
+	if slice.Elements[1].Type != ast.ElementProjection {
+		t.Errorf("expected projection, got %s", slice.Elements[1].Type)
+	}
+	if slice.Elements[1].Type.String() != "projection" {
+		t.Errorf("expected 'projection', got %q", slice.Elements[1].Type.String())
+	}
+	if then := slice.Tests["projects-order"].Then; len(then) != 1 || then[0].Type != ast.ElementProjection {
+		t.Errorf("expected projection in then, got %v", then)
+	}
+}
+
+func TestParseProjectionInWhenRejected(t *testing.T) {
+	input := `
+slices:
+  OrderSummary:
+    steps:
+      - p: OrderSummaryProjector
+    tests:
+      bad:
+        when:
+          - p: OrderSummaryProjector
+`
+	_, err := Parse(strings.NewReader(input))
+	if err == nil {
+		t.Fatal("expected error for projection in when")
+	}
+}
+
+func TestParseThenNot(t *testing.T) {
+	input := `
+slices:
+  Payment:
+    steps:
+      - c: ProcessPayment
+      - e: PaymentProcessed
+    tests:
+      no-double-charge:
+        given:
+          - e: PaymentProcessed
+        when:
+          - c: ProcessPayment
+        then-not:
+          - e: PaymentProcessed
+          - x: PaymentFailed
+`
+	doc, err := Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	test := doc.Slices["Payment"].Tests["no-double-charge"]
+	if !test.HasThenNot {
+		t.Error("expected HasThenNot to be true")
+	}
+	if test.HasThen {
+		t.Error("expected HasThen to be false")
+	}
+	if len(test.ThenNot) != 2 {
+		t.Fatalf("expected 2 then-not elements, got %d", len(test.ThenNot))
+	}
+	if test.ThenNot[1].Type != ast.ElementException {
+		t.Errorf("expected exception, got %s", test.ThenNot[1].Type)
+	}
+}
+
+func TestParseThenNotInvalidType(t *testing.T) {
+	input := `
+slices:
+  Payment:
+    steps:
+      - c: ProcessPayment
+    tests:
+      bad:
+        then-not:
+          - c: ProcessPayment
+`
+	_, err := Parse(strings.NewReader(input))
+	if err == nil {
+		t.Fatal("expected error for command in then-not")
+	}
+}
+
+func TestParseGroups(t *testing.T) {
+	input := `
+groups:
+  Onboarding:
+    - Register
+    - Verify
+slices:
+  Register:
+    - c: Register
+  Verify:
+    - c: Verify
+  Pay:
+    - c: Pay
+`
+	doc, err := Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	groups := doc.SubDocs[0].Groups
+	if len(groups) != 1 {
+		t.Fatalf("expected 1 group, got %d", len(groups))
+	}
+	if groups[0].Name != "Onboarding" {
+		t.Errorf("expected group 'Onboarding', got %q", groups[0].Name)
+	}
+	if strings.Join(groups[0].Slices, ",") != "Register,Verify" {
+		t.Errorf("expected slices [Register Verify], got %v", groups[0].Slices)
+	}
+}
+
+func TestParseGroupsErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"unknown slice", `
+groups:
+  Billing: [Pay]
+slices:
+  Register:
+    - c: Register
+`, `unknown slice "Pay"`},
+		{"slice in two groups", `
+groups:
+  A: [Register]
+  B: [Register]
+slices:
+  Register:
+    - c: Register
+`, `already in group "A"`},
+		{"not a list", `
+groups:
+  A: Register
+slices:
+  Register:
+    - c: Register
+`, "expected a list of slice names"},
+		{"slice from another document", `
+slices:
+  Register:
+    - c: Register
+---
+groups:
+  A: [Register]
+slices:
+  Pay:
+    - c: Pay
+`, `unknown slice "Register"`},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := Parse(strings.NewReader(tc.input))
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			if !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("expected error containing %q, got %v", tc.want, err)
+			}
+		})
+	}
+}
+
+func TestParseContextCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := ParseContext(ctx, strings.NewReader("slices:\n  a:\n    - c: A\n"))
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	if errors.Is(err, ErrInvalid) {
+		t.Errorf("a cancelled parse should not report an invalid document, got %v", err)
+	}
+}
+
+func TestParseErrorsAreInvalid(t *testing.T) {
+	for _, input := range []string{
+		"slices: [unclosed\n",
+		"slices:\n\ta:\n",
+		"slices:\n  a:\n    - c: A\nunknown: true\n",
+	} {
+		_, err := Parse(strings.NewReader(input))
+		if !errors.Is(err, ErrInvalid) {
+			t.Errorf("expected ErrInvalid for %q, got %v", input, err)
+		}
+	}
+
+	_, err := Parse(strings.NewReader("slices:\n  a:\n    - c: A\nunknown: true\n"))
+	if err == nil || err.Error() != `unknown top-level key "unknown" at line 4` {
+		t.Errorf("expected the original message to be kept, got %v", err)
+	}
+}
+
+func TestAllSlicesInOrder(t *testing.T) {
+	input := `
+slices:
+  zeta:
+    - c: Z
+  alpha:
+    - c: A
+  mid:
+    - c: M
+---
+slices:
+  beta:
+    - c: B
+  alpha:
+    - c: A2
+`
+	want := "zeta,alpha,mid,beta,alpha"
+
+	// Map iteration order is random; repeat to catch accidental reliance on it.
+	for run := 0; run < 20; run++ {
+		doc, err := Parse(strings.NewReader(input))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		var names []string
+		for _, slice := range doc.AllSlicesInOrder() {
+			names = append(names, slice.Name)
+		}
+		if got := strings.Join(names, ","); got != want {
+			t.Fatalf("run %d: expected %s, got %s", run, want, got)
+		}
+	}
+}
+
+func TestParseAlt(t *testing.T) {
+	input := `
+slices:
+  checkout:
+    - c: PlaceOrder
+    - alt:
+        accepted:
+          - e: OrderPlaced
+        rejected:
+          - x: OrderRejected
+          - e: CustomerNotified
+`
+	doc, err := Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	elems := doc.Slices["checkout"].Elements
+	if len(elems) != 4 {
+		t.Fatalf("expected 4 flattened elements, got %d", len(elems))
+	}
+	if elems[0].Alt != nil {
+		t.Error("expected PlaceOrder outside the alt")
+	}
+
+	alt := elems[1].Alt
+	if alt == nil {
+		t.Fatal("expected OrderPlaced inside an alt")
+	}
+	if alt.Line != 5 {
+		t.Errorf("expected alt at line 5, got %d", alt.Line)
+	}
+	if len(alt.Branches) != 2 {
+		t.Fatalf("expected 2 branches, got %d", len(alt.Branches))
+	}
+	if alt.Branches[0].Name != "accepted" || len(alt.Branches[0].Elements) != 1 {
+		t.Errorf("unexpected first branch: %+v", alt.Branches[0])
+	}
+	if alt.Branches[1].Name != "rejected" || len(alt.Branches[1].Elements) != 2 {
+		t.Errorf("unexpected second branch: %+v", alt.Branches[1])
+	}
+	for i, want := range []string{"accepted", "rejected", "rejected"} {
+		if elems[i+1].Alt != alt || elems[i+1].Branch != want {
+			t.Errorf("element %d: expected branch %q of the alt, got %q", i+1, want, elems[i+1].Branch)
+		}
+	}
+}
+
+func TestParseAltErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"single branch", `
+slices:
+  s:
+    - alt:
+        only:
+          - e: A
+`},
+		{"empty branch", `
+slices:
+  s:
+    - alt:
+        a:
+          - e: A
+        b: []
+`},
+		{"not a mapping", `
+slices:
+  s:
+    - alt: [a, b]
+`},
+		{"nested alt", `
+slices:
+  s:
+    - alt:
+        a:
+          - alt:
+              x:
+                - e: X
+              y:
+                - e: Y
+        b:
+          - e: B
+`},
+		{"alt in test", `
+slices:
+  s:
+    steps:
+      - c: A
+    tests:
+      t:
+        then:
+          - alt:
+              a:
+                - e: A
+              b:
+                - e: B
+`},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := Parse(strings.NewReader(tc.input)); err == nil {
+				t.Fatal("expected error")
+			}
+		})
+	}
+}
+
+func TestParseDocumentCSS(t *testing.T) {
+	input := `
+slices:
+  a:
+    - c: A
+---
+css:
+  --command-color: "#ff0000"
+  --item-border-radius: 0
+slices:
+  b:
+    - c: B
+`
+	doc, err := Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(doc.SubDocs[0].CSS) != 0 {
+		t.Errorf("expected no css on the first document, got %v", doc.SubDocs[0].CSS)
+	}
+	css := doc.SubDocs[1].CSS
+	if len(css) != 2 {
+		t.Fatalf("expected 2 css variables, got %v", css)
+	}
+	if css[0] != (ast.CSSVar{Name: "--command-color", Value: "#ff0000"}) {
+		t.Errorf("unexpected first variable: %v", css[0])
+	}
+	if css[1] != (ast.CSSVar{Name: "--item-border-radius", Value: "0"}) {
+		t.Errorf("unexpected second variable: %v", css[1])
+	}
+}
+
+func TestParseDocumentCSSInvalidName(t *testing.T) {
+	input := `
+css:
+  command-color: red
+slices:
+  a:
+    - c: A
+`
+	_, err := Parse(strings.NewReader(input))
+	if err == nil || !strings.Contains(err.Error(), "must start with --") {
+		t.Errorf("expected error for css variable without --, got %v", err)
+	}
+}
+
+func TestParseDocumentCSSRejectsMarkup(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"value", "css:\n  --command-color: \"red;}</style><script>alert(1)</script><style>\"\n", "must not contain"},
+		{"name", "css:\n  \"--x</style>\": red\n", "may only contain"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := Parse(strings.NewReader(tc.input + "slices:\n  a:\n    - c: A\n"))
+			if err == nil || !strings.Contains(err.Error(), tc.want) || !strings.Contains(err.Error(), "line 2") {
+				t.Errorf("expected %q error at line 2, got %v", tc.want, err)
+			}
+		})
+	}
+}
+
+func TestParseDocumentTitle(t *testing.T) {
+	input := `
+title: Current process
+slices:
+  a:
+    - c: A
+---
+slices:
+  b:
+    - c: B
+`
+	doc, err := Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if doc.SubDocs[0].Title != "Current process" || doc.SubDocs[1].Title != "" {
+		t.Errorf("unexpected titles %q and %q", doc.SubDocs[0].Title, doc.SubDocs[1].Title)
+	}
+
+	_, err = Parse(strings.NewReader("title: [a, b]\nslices:\n  a:\n    - c: A\n"))
+	if err == nil || !strings.Contains(err.Error(), "title must be a string") {
+		t.Errorf("expected error for non-string title, got %v", err)
+	}
+}
+
+func TestParseGivenTemplates(t *testing.T) {
+	input := `
+given_templates:
+  CartWithItem:
+    - e: CartCreated
+    - e: ItemAdded
+slices:
+  checkout:
+    steps:
+      - c: PlaceOrder
+      - e: OrderPlaced
+    tests:
+      places-order:
+        given:
+          use: CartWithItem
+        when:
+          - c: PlaceOrder
+        then:
+          - e: OrderPlaced
+      places-order-again:
+        given: {use: CartWithItem}
+        when:
+          - c: PlaceOrder
+        then:
+          - e: OrderPlaced
+`
+	doc, err := Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	slice := doc.Slices["checkout"]
+	first := slice.Tests["places-order"]
+	second := slice.Tests["places-order-again"]
+
+	for _, test := range []*ast.Test{first, second} {
+		if test.GivenTemplate != "CartWithItem" {
+			t.Errorf("%s: expected template 'CartWithItem', got %q", test.Name, test.GivenTemplate)
+		}
+		if len(test.Given) != 2 || test.Given[0].Name != "CartCreated" || test.Given[1].Name != "ItemAdded" {
+			t.Errorf("%s: expected given expanded from the template, got %v", test.Name, test.Given)
+		}
+	}
+	if first.Given[0] == second.Given[0] {
+		t.Error("expected each test to get its own copy of the template elements")
+	}
+}
+
+func TestParseGivenTemplateUnknown(t *testing.T) {
+	input := `
+given_templates:
+  CartWithItem:
+    - e: CartCreated
+slices:
+  checkout:
+    steps:
+      - c: PlaceOrder
+    tests:
+      t:
+        given:
+          use: CartWithItems
+`
+	_, err := Parse(strings.NewReader(input))
+	if err == nil {
+		t.Fatal("expected error for unknown given template")
+	}
+	if !strings.Contains(err.Error(), `unknown given template "CartWithItems" (did you mean "CartWithItem"?)`) {
+		t.Errorf("expected suggestion in error, got %v", err)
+	}
+}
+
+func TestParseGivenTemplateInvalidType(t *testing.T) {
+	input := `
+given_templates:
+  Setup:
+    - c: CreateCart
+slices:
+  checkout:
+    - c: PlaceOrder
+`
+	if _, err := Parse(strings.NewReader(input)); err == nil {
+		t.Fatal("expected error for command in given template")
+	}
+}
+
+func TestParseBOMAndCRLF(t *testing.T) {
+	clean := "slices:\n  register:\n    - c: Register\n      props:\n        label: |\n          Register\n          customer\n    - e: Registered\n"
+
+	summarize := func(input string) string {
+		t.Helper()
+		doc, err := Parse(strings.NewReader(input))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		var b strings.Builder
+		for _, elem := range doc.Slices["register"].Elements {
+			fmt.Fprintf(&b, "%s %q %q %d:%d@%d\n", elem.Type, elem.Name, elem.Label, elem.Line, elem.Column, elem.Offset)
+		}
+		return b.String()
+	}
+
+	want := summarize(clean)
+	inputs := map[string]string{
+		"bom":      "\ufeff" + clean,
+		"crlf":     strings.ReplaceAll(clean, "\n", "\r\n"),
+		"bom+crlf": "\ufeff" + strings.ReplaceAll(clean, "\n", "\r\n"),
+	}
+	for name, input := range inputs {
+		if got := summarize(input); got != want {
+			t.Errorf("%s: parsed differently:\ngot:\n%s\nwant:\n%s", name, got, want)
+		}
+	}
+}
+
+func TestParseTabIndentation(t *testing.T) {
+	input := "slices:\n  register:\n\t- c: Register\n"
+	_, err := Parse(strings.NewReader(input))
+	if err == nil {
+		t.Fatal("expected error for tab indentation")
+	}
+	if err.Error() != "tabs are not allowed for indentation at line 3; use spaces" {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// Tabs are content inside block scalars.
+	input = "slices:\n  register:\n    - c: Register\n      props:\n        example: |\n          if ok {\n          \treturn\n          }\n"
+	if _, err := Parse(strings.NewReader(input)); err != nil {
+		t.Errorf("unexpected error for tab in block scalar: %v", err)
+	}
+}
+
+func TestParseWithOptionsAllowInTests(t *testing.T) {
+	input := `
+slices:
+  dashboard:
+    steps:
+      - v: Dashboard
+    tests:
+      opens:
+        when:
+          - v: Dashboard
+        then:
+          - v: Dashboard
+`
+	if _, err := Parse(strings.NewReader(input)); err == nil || !strings.Contains(err.Error(), "when: view not allowed") {
+		t.Fatalf("expected strict mode to reject a view in when, got %v", err)
+	}
+
+	opts := Options{AllowInTests: map[string][]ast.ElementType{"when": {ast.ElementView}}}
+	doc, err := ParseWithOptions(strings.NewReader(input), opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	when := doc.Slices["dashboard"].Tests["opens"].When
+	if len(when) != 1 || when[0].Type != ast.ElementView {
+		t.Errorf("expected a view in when, got %v", when)
+	}
+
+	// Relaxing one section leaves the others strict.
+	strictGiven := strings.Replace(input, "when:\n          - v: Dashboard", "given:\n          - c: Open\n        when:\n          - v: Dashboard", 1)
+	if _, err := ParseWithOptions(strings.NewReader(strictGiven), opts); err == nil {
+		t.Error("expected a command in given to remain rejected")
+	}
+}
+
+func TestParseStrict(t *testing.T) {
+	valid := `
+slices:
+  register:
+    - c: Register
+    - e: Registered
+  pay:
+    steps:
+      - c: Pay
+      - e: Paid
+    tests:
+      pays:
+        when:
+          - c: Pay
+        then:
+          - e: Paid
+`
+	if _, err := ParseWithOptions(strings.NewReader(valid), Options{Strict: true}); err != nil {
+		t.Fatalf("unexpected error for a clean file: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"anonymous slice", `
+slices:
+  "":
+    - c: A
+    - e: B
+`, "anonymous slice at line 3"},
+		{"slice name whitespace", `
+slices:
+  "pay  now":
+    - c: A
+    - e: B
+`, `slice name "pay  now" has stray whitespace at line 3`},
+		{"test name whitespace", `
+slices:
+  pay:
+    steps:
+      - c: A
+      - e: B
+    tests:
+      " pays":
+        when:
+          - c: A
+`, `test name " pays" has stray whitespace at line 8`},
+		{"extended without tests", `
+slices:
+  pay:
+    steps:
+      - c: A
+      - e: B
+`, `slice "pay" uses the extended form without tests or props at line 3`},
+		{"missing event", `
+slices:
+  pay:
+    - c: A
+    - x: Failed
+`, `slice "pay" has no event at line 3`},
+		{"mixed key styles", `
+slices:
+  pay:
+    - c: A
+    - event: B
+`, `long key "event" at line 5 mixes key styles with short key "c" at line 4`},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := Parse(strings.NewReader(tc.input)); err != nil {
+				t.Fatalf("expected the lenient grammar to accept it, got %v", err)
+			}
+			_, err := ParseWithOptions(strings.NewReader(tc.input), Options{Strict: true})
+			if err == nil {
+				t.Fatal("expected a strict error")
+			}
+			if !errors.Is(err, ErrInvalid) || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("expected invalid document error containing %q, got %v", tc.want, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/emlang-project/emlang/internal/ast"
+)
+
+// checkStrict reports the first construct of doc that the lenient grammar
+// tolerates but Options.Strict rejects: anonymous names or names with
+// stray whitespace, extended slices that could be written directly,
+// slices without events, and element keys of mixed styles.
+func checkStrict(doc *ast.Document) error {
+	for _, slice := range doc.AllSlicesInOrder() {
+		if err := checkStrictName("slice", slice.Name, slice.Line); err != nil {
+			return err
+		}
+		if slice.Extended && len(slice.Tests) == 0 && len(slice.Props) == 0 {
+			return fmt.Errorf("slice %q uses the extended form without tests or props at line %d; list its steps directly", slice.Name, slice.Line)
+		}
+		if len(slice.Elements) > 0 && !hasEvent(slice.Elements) {
+			return fmt.Errorf("slice %q has no event at line %d", slice.Name, slice.Line)
+		}
+		for _, name := range slice.TestOrder {
+			if err := checkStrictName("test", name, slice.Tests[name].Line); err != nil {
+				return err
+			}
+		}
+	}
+	return checkKeyStyles(doc)
+}
+
+// checkStrictName rejects an empty name and one with leading, trailing or
+// repeated whitespace.
+func checkStrictName(kind, name string, line int) error {
+	if name == "" {
+		return fmt.Errorf("anonymous %s at line %d", kind, line)
+	}
+	if strings.Join(strings.Fields(name), " ") != name {
+		return fmt.Errorf("%s name %q has stray whitespace at line %d", kind, name, line)
+	}
+	return nil
+}
+
+// hasEvent reports whether elems contains an event.
+func hasEvent(elems []*ast.Element) bool {
+	for _, elem := range elems {
+		if elem.Type == ast.ElementEvent {
+			return true
+		}
+	}
+	return false
+}
+
+// checkKeyStyles rejects a document whose element keys mix the short
+// ("c"), medium ("cmd") and long ("command") styles.
+func checkKeyStyles(doc *ast.Document) error {
+	var first *ast.Element
+	var err error
+	ast.Walk(doc, ast.Visitor{
+		Element: func(elem *ast.Element, _ ast.ElementContext) {
+			if err != nil || elem.RawKey == "" {
+				return
+			}
+			if first == nil {
+				first = elem
+				return
+			}
+			if keyStyle(elem) != keyStyle(first) {
+				err = fmt.Errorf("%s key %q at line %d mixes key styles with %s key %q at line %d",
+					keyStyle(elem), elem.RawKey, elem.Line, keyStyle(first), first.RawKey, first.Line)
+			}
+		},
+	})
+	return err
+}
+
+// keyStyle returns the style of the key elem was written with.
+func keyStyle(elem *ast.Element) string {
+	switch elem.RawKey {
+	case elem.Type.Short():
+		return "short"
+	case elem.Type.String():
+		return "long"
+	}
+	return "medium"
+}
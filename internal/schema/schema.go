@@ -0,0 +1,185 @@
+// Package schema describes the Emlang file format as a JSON Schema,
+// for editor integration through YAML language servers.
+package schema
+
+import (
+	"encoding/json"
+
+	"github.com/emlang-project/emlang/internal/ast"
+)
+
+// ID is the $id of the generated schema.
+const ID = "https://emlang-project.github.io/schema/emlang.json"
+
+// object is a JSON Schema node.
+type object = map[string]interface{}
+
+// Schema returns the JSON Schema of an Emlang document (one YAML document
+// of a file). Element keys are derived from ast.ElementTypes so that the
+// schema follows the grammar accepted by the parser.
+func Schema() map[string]interface{} {
+	all := allTypes()
+	given := []ast.ElementType{ast.ElementEvent, ast.ElementView, ast.ElementProjection}
+	when := []ast.ElementType{ast.ElementCommand}
+	then := []ast.ElementType{ast.ElementEvent, ast.ElementView, ast.ElementProjection, ast.ElementException}
+
+	return object{
+		"$schema":              "http://json-schema.org/draft-07/schema#",
+		"$id":                  ID,
+		"title":                "Emlang document",
+		"type":                 "object",
+		"additionalProperties": false,
+		"properties": object{
+			"title": object{"type": "string"},
+			"slices": object{
+				"type":                 []string{"object", "null"},
+				"additionalProperties": object{"$ref": "#/definitions/slice"},
+			},
+			"groups": object{
+				"type": []string{"object", "null"},
+				"additionalProperties": object{
+					"type":  "array",
+					"items": object{"type": "string"},
+				},
+			},
+			"given_templates": object{
+				"type":                 []string{"object", "null"},
+				"additionalProperties": elementList(given),
+			},
+			"css": object{
+				"type":                 []string{"object", "null"},
+				"propertyNames":        object{"pattern": "^--"},
+				"additionalProperties": object{"type": []string{"string", "number"}},
+			},
+		},
+		"definitions": object{
+			"slice": object{
+				"oneOf": []interface{}{
+					object{"type": "null"},
+					object{"$ref": "#/definitions/steps"},
+					object{
+						"type":                 "object",
+						"additionalProperties": false,
+						"required":             []string{"steps"},
+						"properties": object{
+							"props": object{"type": "object"},
+							"steps": object{
+								"oneOf": []interface{}{
+									object{"type": "null"},
+									object{"$ref": "#/definitions/steps"},
+								},
+							},
+							"tests": object{
+								"type":                 []string{"object", "null"},
+								"additionalProperties": object{"$ref": "#/definitions/test"},
+							},
+						},
+					},
+				},
+			},
+			"steps": object{
+				"type":     "array",
+				"minItems": 1,
+				"items": object{
+					"oneOf": []interface{}{
+						element(all),
+						object{"$ref": "#/definitions/alt"},
+					},
+				},
+			},
+			"alt": object{
+				"type":                 "object",
+				"additionalProperties": false,
+				"required":             []string{"alt"},
+				"properties": object{
+					"alt": object{
+						"type":                 "object",
+						"minProperties":        2,
+						"additionalProperties": elementList(all),
+					},
+				},
+			},
+			"test": object{
+				"type":                 []string{"object", "null"},
+				"additionalProperties": false,
+				"properties": object{
+					"props": object{
+						"type": "object",
+						"properties": object{
+							"deprecated": object{"type": "boolean"},
+						},
+					},
+					"given": object{
+						"oneOf": []interface{}{
+							nullable(elementList(given)),
+							object{
+								"type":                 "object",
+								"additionalProperties": false,
+								"required":             []string{"use"},
+								"properties":           object{"use": object{"type": "string"}},
+							},
+						},
+					},
+					"when":     nullable(elementList(when)),
+					"then":     nullable(elementList(then)),
+					"then-not": nullable(elementList(then)),
+				},
+			},
+			"props": object{
+				"type": "object",
+				"properties": object{
+					"external":   object{"type": "boolean"},
+					"label":      object{"type": "string"},
+					"owner":      object{"type": "string"},
+					"col":        object{"type": "integer", "minimum": 1},
+					"deprecated": object{"type": "boolean"},
+				},
+			},
+		},
+	}
+}
+
+// JSON returns the schema as indented JSON.
+func JSON() ([]byte, error) {
+	return json.MarshalIndent(Schema(), "", "  ")
+}
+
+func allTypes() []ast.ElementType {
+	types := make([]ast.ElementType, len(ast.ElementTypes))
+	for i, info := range ast.ElementTypes {
+		types[i] = info.Type
+	}
+	return types
+}
+
+// element returns the schema of an element of one of the given types:
+// a mapping with exactly one type key and optional props.
+func element(types []ast.ElementType) object {
+	properties := object{"props": object{"$ref": "#/definitions/props"}}
+	var oneOf []interface{}
+	for _, t := range types {
+		for _, key := range ast.ElementTypes[t].Aliases {
+			properties[key] = object{"type": "string", "pattern": `[^/\s]\s*$`}
+			oneOf = append(oneOf, object{"required": []string{key}})
+		}
+	}
+	return object{
+		"type":                 "object",
+		"additionalProperties": false,
+		"properties":           properties,
+		"oneOf":                oneOf,
+	}
+}
+
+func elementList(types []ast.ElementType) object {
+	return object{
+		"type":  "array",
+		"items": element(types),
+	}
+}
+
+func nullable(schema object) object {
+	return object{
+		"oneOf": []interface{}{object{"type": "null"}, schema},
+	}
+}
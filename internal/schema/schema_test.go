@@ -0,0 +1,191 @@
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+	"gopkg.in/yaml.v3"
+
+	"github.com/emlang-project/emlang/internal/parser"
+)
+
+func compile(t *testing.T) *jsonschema.Schema {
+	t.Helper()
+	b, err := JSON()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	s, err := jsonschema.CompileString(ID, string(b))
+	if err != nil {
+		t.Fatalf("schema does not compile: %v", err)
+	}
+	return s
+}
+
+// validate converts a YAML document to JSON and validates it against s.
+func validate(t *testing.T, s *jsonschema.Schema, input string) error {
+	t.Helper()
+	var v interface{}
+	if err := yaml.Unmarshal([]byte(input), &v); err != nil {
+		t.Fatalf("invalid YAML: %v", err)
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var doc interface{}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	if err := dec.Decode(&doc); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return s.Validate(doc)
+}
+
+func TestSchemaAcceptsValidFixtures(t *testing.T) {
+	fixtures := map[string]string{
+		"direct": `
+slices:
+  registration:
+    - t: Customer/Form
+    - c: Register
+      props:
+        email: string
+    - e: Registered
+  placeholder:
+`,
+		"extended": `
+slices:
+  registration:
+    steps:
+      - cmd: Register
+      - evt: Registered
+        props:
+          external: true
+          label: Customer registered
+      - x: AlreadyRegistered
+    tests:
+      happy-path:
+        given:
+          - v: NoCustomers
+        when:
+          - c: Register
+        then:
+          - e: Registered
+        then-not:
+          - x: AlreadyRegistered
+      empty:
+`,
+		"alt": `
+slices:
+  checkout:
+    - c: Checkout
+    - alt:
+        paid:
+          - e: OrderPaid
+        declined:
+          - x: PaymentDeclined
+`,
+		"sections": `
+slices:
+  a:
+    steps:
+      - c: DoA
+      - e: DidA
+    tests:
+      uses-template:
+        given:
+          use: fresh
+        when:
+          - c: DoA
+        then:
+          - e: DidA
+  b:
+    - c: DoB
+    - e: DidB
+groups:
+  Ordering: [a, b]
+given_templates:
+  fresh:
+    - e: Created
+css:
+  --color-event: "#ffa500"
+`,
+	}
+
+	s := compile(t)
+	for name, input := range fixtures {
+		if _, err := parser.Parse(strings.NewReader(input)); err != nil {
+			t.Fatalf("%s: fixture rejected by parser: %v", name, err)
+		}
+		if err := validate(t, s, input); err != nil {
+			t.Errorf("%s: fixture rejected by schema: %v", name, err)
+		}
+	}
+}
+
+func TestSchemaRejectsInvalidFixtures(t *testing.T) {
+	fixtures := map[string]string{
+		"unknown top-level key": `
+slice:
+  a:
+    - e: A
+`,
+		"unknown element key": `
+slices:
+  a:
+    - z: A
+`,
+		"multiple type keys": `
+slices:
+  a:
+    - c: A
+      e: B
+`,
+		"name ending with slash": `
+slices:
+  a:
+    - e: Lane/
+`,
+		"command in given": `
+slices:
+  a:
+    steps:
+      - c: A
+      - e: B
+    tests:
+      t:
+        given:
+          - c: A
+`,
+		"extended slice without steps": `
+slices:
+  a:
+    tests:
+`,
+		"alt with one branch": `
+slices:
+  a:
+    - alt:
+        only:
+          - e: A
+`,
+		"css name without dashes": `
+css:
+  color-event: red
+`,
+	}
+
+	s := compile(t)
+	for name, input := range fixtures {
+		if _, err := parser.Parse(strings.NewReader(input)); err == nil {
+			t.Fatalf("%s: fixture accepted by parser", name)
+		}
+		if err := validate(t, s, input); err == nil {
+			t.Errorf("%s: fixture accepted by schema", name)
+		}
+	}
+}
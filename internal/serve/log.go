@@ -0,0 +1,110 @@
+package serve
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LevelNotice is the level of messages the user always needs, such as
+// the URL the server listens on. NewLogger writes them to out, without a
+// prefix, whatever its level.
+const LevelNotice = slog.Level(12)
+
+// NewLogger returns a logger that writes plain console lines: messages
+// below slog.LevelWarn go to out, warnings and errors to errOut, prefixed
+// with "Warning: " or "Error: ". Records below level are dropped, except
+// at LevelNotice.
+func NewLogger(out, errOut io.Writer, level slog.Level) *slog.Logger {
+	return slog.New(&consoleHandler{out: out, errOut: errOut, level: level, mu: &sync.Mutex{}})
+}
+
+// consoleHandler is a slog.Handler that formats records as
+// "message key=value ..." without a timestamp, so that the server's
+// default output reads like ordinary command output.
+type consoleHandler struct {
+	out, errOut io.Writer
+	level       slog.Level
+	attrs       []slog.Attr
+	mu          *sync.Mutex
+}
+
+func (h *consoleHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level == LevelNotice || level >= h.level
+}
+
+func (h *consoleHandler) Handle(_ context.Context, r slog.Record) error {
+	var b strings.Builder
+	w := h.out
+	switch {
+	case r.Level == LevelNotice:
+	case r.Level >= slog.LevelError:
+		w = h.errOut
+		b.WriteString("Error: ")
+	case r.Level >= slog.LevelWarn:
+		w = h.errOut
+		b.WriteString("Warning: ")
+	}
+	b.WriteString(r.Message)
+
+	writeAttr := func(a slog.Attr) bool {
+		v := a.Value.String()
+		if strings.ContainsAny(v, " \t\n\"") {
+			v = fmt.Sprintf("%q", v)
+		}
+		fmt.Fprintf(&b, " %s=%s", a.Key, v)
+		return true
+	}
+	for _, a := range h.attrs {
+		writeAttr(a)
+	}
+	r.Attrs(writeAttr)
+	b.WriteByte('\n')
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func (h *consoleHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	h2 := *h
+	h2.attrs = append(append([]slog.Attr(nil), h.attrs...), attrs...)
+	return &h2
+}
+
+// WithGroup is not needed by the server; group names are ignored.
+func (h *consoleHandler) WithGroup(string) slog.Handler {
+	return h
+}
+
+// statusRecorder captures the status code written by a handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// logRequests wraps next so that every request is logged at the debug
+// level with its method, path, status and duration.
+func logRequests(log *slog.Logger, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		log.Debug("request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", rec.status,
+			"duration", time.Since(start))
+	})
+}
@@ -4,15 +4,17 @@ import (
 	"context"
 	"crypto/sha256"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/exec"
 	"os/signal"
+	"path/filepath"
 	"runtime"
+	"strings"
 	"sync"
 	"time"
 
-	"github.com/emlang-project/emlang/internal/config"
 	"github.com/emlang-project/emlang/internal/diagram"
 	"github.com/emlang-project/emlang/internal/parser"
 )
@@ -29,11 +31,14 @@ const pollJS = `<script>
 })();
 </script>`
 
-// wrapHTML wraps an HTML fragment in a full HTML page with live-reload script.
-func wrapHTML(fragment []byte) []byte {
-	return []byte("<!DOCTYPE html>\n<html><head><meta charset=\"utf-8\"><title>emlang diagram</title></head>\n<body>\n" +
-		string(fragment) +
-		pollJS + "\n</body></html>\n")
+// shutdownTimeout bounds how long the server waits for open connections
+// to finish before they are forcibly closed.
+const shutdownTimeout = 5 * time.Second
+
+// wrapHTML wraps an HTML fragment in a full HTML page with the given
+// title and live-reload script, using page, or the built-in page if nil.
+func wrapHTML(page *diagram.Page, fragment []byte, title string) ([]byte, error) {
+	return page.Render(fragment, title, pollJS)
 }
 
 // hashBytes returns a hex-encoded SHA-256 hash of the given bytes.
@@ -43,10 +48,9 @@ func hashBytes(b []byte) string {
 }
 
 type state struct {
-	mu      sync.RWMutex
-	html    []byte
-	hash    string
-	lastMod time.Time
+	mu   sync.RWMutex
+	html []byte
+	hash string
 }
 
 func (s *state) update(html []byte) {
@@ -68,49 +72,237 @@ func (s *state) getHash() string {
 	return s.hash
 }
 
-// generate parses the file and generates the wrapped HTML page.
-func generate(filePath string, cfg *config.Config) ([]byte, error) {
+// generate parses the file and generates the HTML page wrapped with page.
+// Generation warnings are logged to log.
+func generate(filePath string, gen *diagram.Generator, parseOpts parser.Options, page *diagram.Page, log *slog.Logger) ([]byte, error) {
 	f, err := os.Open(filePath)
 	if err != nil {
 		return nil, err
 	}
 	defer f.Close()
 
-	doc, err := parser.Parse(f)
+	doc, err := parser.ParseWithOptions(f, parseOpts)
 	if err != nil {
 		return nil, fmt.Errorf("parse error: %w", err)
 	}
 
-	gen := diagram.New()
-	gen.CSSOverrides = cfg.Diagram.CSS
-	fragment, err := gen.Generate(doc)
+	result, err := gen.GenerateResult(doc)
 	if err != nil {
 		return nil, fmt.Errorf("diagram generation error: %w", err)
 	}
+	for _, w := range result.Warnings {
+		log.Warn(fmt.Sprintf("%s:%s", filePath, w))
+	}
 
-	return wrapHTML(fragment), nil
+	return wrapHTML(page, result.HTML, filepath.Base(filePath))
 }
 
-// openBrowser tries to open the given URL in the default browser.
-// Errors are silently ignored.
-func openBrowser(url string) {
-	var cmd *exec.Cmd
-	switch runtime.GOOS {
+// watcher regenerates the diagram when its file or the config changes.
+type watcher struct {
+	filePath   string
+	configPath string
+	gen        *diagram.Generator
+	parse      parser.Options
+	page       *diagram.Page
+	reload     func() (*diagram.Generator, parser.Options, error)
+	log        *slog.Logger
+	state      *state
+
+	fileMod   time.Time // of the last successfully rendered file
+	configMod time.Time // of the last loaded config
+}
+
+// modTime returns the modification time of path, or the zero time if it
+// cannot be read, e.g. because the config file does not exist (yet).
+func modTime(path string) time.Time {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+// check regenerates the diagram if the file changed since it was last
+// rendered, or the config since it was last loaded, and reports whether
+// the diagram was updated. A config that fails to reload is logged and
+// the previous settings are kept.
+func (w *watcher) check() bool {
+	fileMod := modTime(w.filePath)
+	fileChanged := fileMod.After(w.fileMod)
+
+	configChanged := false
+	if w.reload != nil {
+		configMod := modTime(w.configPath)
+		configChanged = !configMod.Equal(w.configMod)
+		if configChanged {
+			w.configMod = configMod
+			gen, parse, err := w.reload()
+			if err != nil {
+				w.log.Error("config reload failed: " + err.Error())
+				configChanged = false
+			} else {
+				w.gen, w.parse = gen, parse
+				w.log.Info("Config reloaded.")
+			}
+		}
+	}
+
+	if !fileChanged && !configChanged {
+		return false
+	}
+	html, err := generate(w.filePath, w.gen, w.parse, w.page, w.log)
+	if err != nil {
+		w.log.Error("regeneration failed: " + err.Error())
+		return false
+	}
+	w.fileMod = fileMod
+	w.state.update(html)
+	w.log.Info("Diagram updated.")
+	return true
+}
+
+// Options configures the live-reload server.
+type Options struct {
+	Address string
+	Port    int
+	NoOpen  bool             // never open a browser
+	Open    func(url string) // opens the served URL; nil uses DefaultOpen
+
+	// Parse relaxes the grammar the file is parsed with.
+	Parse parser.Options
+
+	// Page wraps the diagram in a custom page template, which should
+	// include its PollScript for live reload. Nil uses the built-in page.
+	Page *diagram.Page
+
+	// Logger receives server messages. Reloads are logged at the info
+	// level and requests at the debug level. Nil logs to stdout and
+	// stderr at the info level.
+	Logger *slog.Logger
+
+	// ConfigPath is watched along with the diagram file. When it changes,
+	// Reload provides the generator and parse options to render with from
+	// then on. Nil Reload leaves the config unwatched.
+	ConfigPath string
+	Reload     func() (*diagram.Generator, parser.Options, error)
+}
+
+// browserCommand returns the command line that opens url: the command in
+// EMLANG_BROWSER (with url appended) if set, then the first command of
+// the standard BROWSER list, otherwise the platform default. Under WSL,
+// where xdg-open is often missing, the default is wslview if lookPath
+// finds it and the Windows shell otherwise.
+// Returns nil if the platform is not supported.
+func browserCommand(url string, goos string, getenv func(string) string, wsl bool, lookPath func(string) (string, error)) []string {
+	if custom := strings.Fields(getenv("EMLANG_BROWSER")); len(custom) > 0 {
+		return append(custom, url)
+	}
+	if custom := browserEnvCommand(url, getenv("BROWSER")); custom != nil {
+		return custom
+	}
+	switch goos {
 	case "linux":
-		cmd = exec.Command("xdg-open", url)
+		if !wsl {
+			return []string{"xdg-open", url}
+		}
+		if _, err := lookPath("wslview"); err == nil {
+			return []string{"wslview", url}
+		}
+		return []string{"cmd.exe", "/c", "start", "", url}
 	case "darwin":
-		cmd = exec.Command("open", url)
+		return []string{"open", url}
 	case "windows":
-		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", url)
+		return []string{"rundll32", "url.dll,FileProtocolHandler", url}
 	default:
+		return nil
+	}
+}
+
+// browserEnvCommand returns the command line of the first command of a
+// BROWSER value, a colon-separated list of commands in which %s stands
+// for the URL (appended when absent) and %% for a percent sign.
+// Returns nil if the value names no command.
+func browserEnvCommand(url, value string) []string {
+	first, _, _ := strings.Cut(value, ":")
+	fields := strings.Fields(first)
+	if len(fields) == 0 {
+		return nil
+	}
+	hasURL := false
+	for i, f := range fields {
+		if strings.Contains(f, "%s") {
+			hasURL = true
+		}
+		f = strings.ReplaceAll(f, "%s", url)
+		fields[i] = strings.ReplaceAll(f, "%%", "%")
+	}
+	if !hasURL {
+		fields = append(fields, url)
+	}
+	return fields
+}
+
+// isWSL reports whether the kernel version, read with readFile, is that
+// of the Windows Subsystem for Linux.
+func isWSL(readFile func(string) ([]byte, error)) bool {
+	version, err := readFile("/proc/version")
+	return err == nil && strings.Contains(strings.ToLower(string(version)), "microsoft")
+}
+
+// DefaultOpen tries to open the given URL in a browser.
+// Errors are silently ignored.
+func DefaultOpen(url string) {
+	wsl := runtime.GOOS == "linux" && isWSL(os.ReadFile)
+	args := browserCommand(url, runtime.GOOS, os.Getenv, wsl, exec.LookPath)
+	if args == nil {
 		return
 	}
-	_ = cmd.Start()
+	_ = exec.Command(args[0], args[1:]...).Start()
+}
+
+// isHeadless reports whether there is no display to open a browser on.
+// Only Linux is detected, by the absence of both X11 and Wayland displays.
+func isHeadless(goos string, getenv func(string) string) bool {
+	return goos == "linux" && getenv("DISPLAY") == "" && getenv("WAYLAND_DISPLAY") == ""
 }
 
-// Start starts the live-reload HTTP server for the given file.
-func Start(filePath string, addr string, port int, cfg *config.Config) error {
-	html, err := generate(filePath, cfg)
+// maybeOpen opens url with open unless noOpen is set or the environment is headless.
+// Returns true if open was called.
+func maybeOpen(url string, noOpen bool, headless bool, open func(string), log *slog.Logger) bool {
+	if noOpen {
+		return false
+	}
+	if headless {
+		log.Log(context.Background(), LevelNotice, "No display detected; open the URL above in a browser.")
+		return false
+	}
+	open(url)
+	return true
+}
+
+// shutdown gracefully stops the server, forcibly closing any connections
+// still open once the timeout elapses.
+func shutdown(server *http.Server, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		server.Close()
+		return err
+	}
+	return nil
+}
+
+// Start starts the live-reload HTTP server for the given file,
+// rendering it with the given generator.
+// Unless opts.NoOpen is set, the diagram is opened in a browser.
+func Start(filePath string, gen *diagram.Generator, opts Options) error {
+	addr, port := opts.Address, opts.Port
+	log := opts.Logger
+	if log == nil {
+		log = NewLogger(os.Stdout, os.Stderr, slog.LevelInfo)
+	}
+
+	html, err := generate(filePath, gen, opts.Parse, opts.Page, log)
 	if err != nil {
 		return err
 	}
@@ -122,7 +314,18 @@ func Start(filePath string, addr string, port int, cfg *config.Config) error {
 	if err != nil {
 		return err
 	}
-	s.lastMod = info.ModTime()
+	w := &watcher{
+		filePath:   filePath,
+		configPath: opts.ConfigPath,
+		gen:        gen,
+		parse:      opts.Parse,
+		page:       opts.Page,
+		reload:     opts.Reload,
+		log:        log,
+		state:      s,
+		fileMod:    info.ModTime(),
+		configMod:  modTime(opts.ConfigPath),
+	}
 
 	// File watcher goroutine
 	ctx, cancel := context.WithCancel(context.Background())
@@ -136,26 +339,7 @@ func Start(filePath string, addr string, port int, cfg *config.Config) error {
 			case <-ctx.Done():
 				return
 			case <-ticker.C:
-				info, err := os.Stat(filePath)
-				if err != nil {
-					continue
-				}
-				s.mu.RLock()
-				changed := info.ModTime().After(s.lastMod)
-				s.mu.RUnlock()
-				if !changed {
-					continue
-				}
-				newHTML, err := generate(filePath, cfg)
-				if err != nil {
-					fmt.Fprintf(os.Stderr, "Regeneration error: %v\n", err)
-					continue
-				}
-				s.mu.Lock()
-				s.lastMod = info.ModTime()
-				s.mu.Unlock()
-				s.update(newHTML)
-				fmt.Println("Diagram updated.")
+				w.check()
 			}
 		}
 	}()
@@ -173,7 +357,7 @@ func Start(filePath string, addr string, port int, cfg *config.Config) error {
 	listenAddr := fmt.Sprintf("%s:%d", addr, port)
 	server := &http.Server{
 		Addr:    listenAddr,
-		Handler: mux,
+		Handler: logRequests(log, mux),
 	}
 
 	// Graceful shutdown on SIGINT/SIGTERM
@@ -182,9 +366,11 @@ func Start(filePath string, addr string, port int, cfg *config.Config) error {
 
 	go func() {
 		<-sigCh
-		fmt.Println("\nShutting down server...")
+		fmt.Println()
+		log.Info("Shutting down server...")
+		signal.Stop(sigCh)
 		cancel()
-		server.Shutdown(context.Background())
+		shutdown(server, shutdownTimeout)
 	}()
 
 	displayHost := addr
@@ -192,8 +378,15 @@ func Start(filePath string, addr string, port int, cfg *config.Config) error {
 		displayHost = "localhost"
 	}
 	url := fmt.Sprintf("http://%s:%d", displayHost, port)
-	fmt.Printf("Serving diagram at %s\n", url)
-	openBrowser(url)
+	log.Log(context.Background(), LevelNotice, "Serving diagram at "+url)
+	open := opts.Open
+	headless := false
+	if open == nil {
+		open = DefaultOpen
+		headless = os.Getenv("EMLANG_BROWSER") == "" && os.Getenv("BROWSER") == "" &&
+			isHeadless(runtime.GOOS, os.Getenv) && !isWSL(os.ReadFile)
+	}
+	maybeOpen(url, opts.NoOpen, headless, open, log)
 
 	if err := server.ListenAndServe(); err != http.ErrServerClosed {
 		return err
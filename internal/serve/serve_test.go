@@ -1,17 +1,30 @@
 package serve
 
 import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/emlang-project/emlang/internal/diagram"
+	"github.com/emlang-project/emlang/internal/parser"
 )
 
 func TestWrapHTML(t *testing.T) {
 	fragment := []byte(`<style>.test{}</style><div>hello</div>`)
-	page := string(wrapHTML(fragment))
+	html, err := wrapHTML(nil, fragment, "emlang diagram")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	page := string(html)
 
 	if !strings.HasPrefix(page, "<!DOCTYPE html>") {
 		t.Error("expected page to start with DOCTYPE")
@@ -30,6 +43,46 @@ func TestWrapHTML(t *testing.T) {
 	}
 }
 
+func TestWrapHTMLCustomPage(t *testing.T) {
+	page, err := diagram.ParsePage(`<html><title>{{.Title}} | Docs</title><header id="site-chrome"></header>{{.Body}}{{.PollScript}}</html>`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	fragment := []byte(`<div>hello</div>`)
+	html, err := wrapHTML(page, fragment, "orders.yaml")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	out := string(html)
+	for _, want := range []string{"<title>orders.yaml | Docs</title>", `<header id="site-chrome"></header>`, "<div>hello</div>", `fetch("/hash")`} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected page to contain %q, got:\n%s", want, out)
+		}
+	}
+}
+
+func TestGenerateTitle(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "orders.yaml")
+	if err := os.WriteFile(filePath, []byte("slices:\n  a:\n    - c: A\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	page, err := diagram.ParsePage(`<title>{{.Title}}</title>{{.Body}}`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var out bytes.Buffer
+	html, err := generate(filePath, diagram.New(), parser.Options{}, page, NewLogger(&out, &out, slog.LevelInfo))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(string(html), "<title>orders.yaml</title>") {
+		t.Errorf("expected the file name as title, got:\n%s", html)
+	}
+}
+
 func TestHashBytes(t *testing.T) {
 	h1 := hashBytes([]byte("hello"))
 	h2 := hashBytes([]byte("hello"))
@@ -141,3 +194,249 @@ func TestFileChangeDetection(t *testing.T) {
 		t.Error("new file mtime should not be before original")
 	}
 }
+
+func TestConfigChangeRegenerates(t *testing.T) {
+	dir := t.TempDir()
+	filePath := filepath.Join(dir, "model.yaml")
+	configPath := filepath.Join(dir, ".emlang.yaml")
+	if err := os.WriteFile(filePath, []byte("slices:\n  a:\n    - c: DoA\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(configPath, []byte("#111111"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The config file holds the command color, for the test
+	reload := func() (*diagram.Generator, parser.Options, error) {
+		color, err := os.ReadFile(configPath)
+		if err != nil {
+			return nil, parser.Options{}, err
+		}
+		gen := diagram.New()
+		gen.CSSOverrides = map[string]string{"--command-color": string(color)}
+		return gen, parser.Options{}, nil
+	}
+	gen, _, _ := reload()
+
+	var out bytes.Buffer
+	log := NewLogger(&out, &out, slog.LevelInfo)
+	html, err := generate(filePath, gen, parser.Options{}, nil, log)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	s := &state{}
+	s.update(html)
+	w := &watcher{
+		filePath:   filePath,
+		configPath: configPath,
+		gen:        gen,
+		reload:     reload,
+		log:        log,
+		state:      s,
+		fileMod:    modTime(filePath),
+		configMod:  modTime(configPath),
+	}
+
+	if w.check() {
+		t.Error("expected no regeneration without changes")
+	}
+
+	if err := os.WriteFile(configPath, []byte("#222222"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// Ensure a distinct mtime on filesystems with coarse timestamps
+	later := time.Now().Add(time.Second)
+	if err := os.Chtimes(configPath, later, later); err != nil {
+		t.Fatal(err)
+	}
+
+	if !w.check() {
+		t.Fatalf("expected the config change to regenerate the diagram, log:\n%s", out.String())
+	}
+	if !strings.Contains(string(s.getHTML()), "--command-color: #222222") {
+		t.Error("expected the regenerated diagram to use the reloaded config")
+	}
+	if !strings.Contains(out.String(), "Config reloaded.") {
+		t.Errorf("expected the reload to be logged, got:\n%s", out.String())
+	}
+	if w.check() {
+		t.Error("expected no regeneration once the change is applied")
+	}
+}
+
+func TestMaybeOpen(t *testing.T) {
+	var opened []string
+	open := func(url string) { opened = append(opened, url) }
+	var out bytes.Buffer
+	// The headless notice is shown even with -q.
+	log := NewLogger(&out, &out, slog.LevelWarn)
+
+	if maybeOpen("http://localhost:8274", true, false, open, log) {
+		t.Error("expected --no-open to suppress opening")
+	}
+	if maybeOpen("http://localhost:8274", false, true, open, log) {
+		t.Error("expected headless environment to suppress opening")
+	}
+	if !strings.Contains(out.String(), "No display detected") {
+		t.Errorf("expected headless notice, got %q", out.String())
+	}
+	if len(opened) != 0 {
+		t.Fatalf("expected opener not to be called, got %v", opened)
+	}
+
+	if !maybeOpen("http://localhost:8274", false, false, open, log) {
+		t.Error("expected opener to be called")
+	}
+	if len(opened) != 1 || opened[0] != "http://localhost:8274" {
+		t.Errorf("unexpected opener calls: %v", opened)
+	}
+}
+
+func TestIsHeadless(t *testing.T) {
+	env := func(vars map[string]string) func(string) string {
+		return func(k string) string { return vars[k] }
+	}
+
+	if !isHeadless("linux", env(nil)) {
+		t.Error("expected linux without DISPLAY to be headless")
+	}
+	if isHeadless("linux", env(map[string]string{"DISPLAY": ":0"})) {
+		t.Error("expected linux with DISPLAY not to be headless")
+	}
+	if isHeadless("linux", env(map[string]string{"WAYLAND_DISPLAY": "wayland-0"})) {
+		t.Error("expected linux with WAYLAND_DISPLAY not to be headless")
+	}
+	if isHeadless("darwin", env(nil)) {
+		t.Error("expected darwin not to be headless")
+	}
+}
+
+func TestBrowserCommand(t *testing.T) {
+	env := func(vars map[string]string) func(string) string {
+		return func(k string) string { return vars[k] }
+	}
+	url := "http://localhost:8274"
+	found := func(string) (string, error) { return "/usr/bin/wslview", nil }
+	missing := func(string) (string, error) { return "", exec.ErrNotFound }
+
+	tests := []struct {
+		name     string
+		goos     string
+		env      map[string]string
+		wsl      bool
+		lookPath func(string) (string, error)
+		want     []string
+	}{
+		{"linux", "linux", nil, false, found, []string{"xdg-open", url}},
+		{"darwin", "darwin", nil, false, found, []string{"open", url}},
+		{"windows", "windows", nil, false, found, []string{"rundll32", "url.dll,FileProtocolHandler", url}},
+		{"unsupported", "plan9", nil, false, found, nil},
+		{"override", "linux", map[string]string{"EMLANG_BROWSER": "firefox --new-window"}, false, found, []string{"firefox", "--new-window", url}},
+		{"override on unsupported", "plan9", map[string]string{"EMLANG_BROWSER": "lynx"}, false, found, []string{"lynx", url}},
+		{"BROWSER", "linux", map[string]string{"BROWSER": "firefox"}, false, found, []string{"firefox", url}},
+		{"BROWSER template", "linux", map[string]string{"BROWSER": "chromium --app=%s:firefox"}, false, found, []string{"chromium", "--app=" + url}},
+		{"BROWSER under EMLANG_BROWSER", "linux", map[string]string{"BROWSER": "firefox", "EMLANG_BROWSER": "lynx"}, false, found, []string{"lynx", url}},
+		{"BROWSER under WSL", "linux", map[string]string{"BROWSER": "firefox"}, true, found, []string{"firefox", url}},
+		{"WSL", "linux", nil, true, found, []string{"wslview", url}},
+		{"WSL without wslview", "linux", nil, true, missing, []string{"cmd.exe", "/c", "start", "", url}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := browserCommand(url, tc.goos, env(tc.env), tc.wsl, tc.lookPath)
+			if strings.Join(got, " ") != strings.Join(tc.want, " ") {
+				t.Errorf("expected %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestIsWSL(t *testing.T) {
+	version := func(s string) func(string) ([]byte, error) {
+		return func(string) ([]byte, error) { return []byte(s), nil }
+	}
+	if !isWSL(version("Linux version 5.15.90.1-microsoft-standard-WSL2")) {
+		t.Error("expected a microsoft kernel to be WSL")
+	}
+	if isWSL(version("Linux version 6.1.0-18-amd64 (debian-kernel@lists.debian.org)")) {
+		t.Error("expected a debian kernel not to be WSL")
+	}
+	if isWSL(func(string) ([]byte, error) { return nil, os.ErrNotExist }) {
+		t.Error("expected a missing /proc/version not to be WSL")
+	}
+}
+
+func TestShutdownWithOpenConnection(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	started := make(chan struct{})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	})}
+	go server.Serve(ln)
+
+	go http.Get("http://" + ln.Addr().String())
+	<-started
+
+	begin := time.Now()
+	err = shutdown(server, 100*time.Millisecond)
+	if err == nil {
+		t.Error("expected timeout error with a hung connection")
+	}
+	if elapsed := time.Since(begin); elapsed > 2*time.Second {
+		t.Errorf("shutdown took %v, expected prompt return", elapsed)
+	}
+}
+
+func TestNewLogger(t *testing.T) {
+	var out, errOut bytes.Buffer
+	log := NewLogger(&out, &errOut, slog.LevelInfo)
+
+	log.Debug("request", "path", "/hash")
+	log.Info("Diagram updated.")
+	log.Warn("model.yaml:3: unknown prop")
+	log.Error("regeneration failed", "err", "bad input")
+
+	if out.String() != "Diagram updated.\n" {
+		t.Errorf("unexpected stdout: %q", out.String())
+	}
+	want := "Warning: model.yaml:3: unknown prop\nError: regeneration failed err=\"bad input\"\n"
+	if errOut.String() != want {
+		t.Errorf("unexpected stderr:\ngot:  %q\nwant: %q", errOut.String(), want)
+	}
+
+	out.Reset()
+	errOut.Reset()
+	quiet := NewLogger(&out, &errOut, slog.LevelWarn)
+	quiet.Info("Diagram updated.")
+	if out.Len() != 0 {
+		t.Errorf("expected info to be dropped at warn level, got %q", out.String())
+	}
+	quiet.Log(context.Background(), LevelNotice, "Serving diagram at http://localhost:8274")
+	if out.String() != "Serving diagram at http://localhost:8274\n" || errOut.Len() != 0 {
+		t.Errorf("expected notice on stdout at warn level, got %q and %q", out.String(), errOut.String())
+	}
+}
+
+func TestLogRequests(t *testing.T) {
+	var out bytes.Buffer
+	log := NewLogger(&out, &out, slog.LevelDebug)
+	handler := logRequests(log, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.NotFound(w, r)
+	}))
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/missing", nil))
+
+	line := out.String()
+	for _, want := range []string{"request ", "method=GET", "path=/missing", "status=404", "duration="} {
+		if !strings.Contains(line, want) {
+			t.Errorf("expected %q in log line %q", want, line)
+		}
+	}
+}